@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// AI backends
+const (
+	AIBackendCLI      = "cli"      // the Claude Code CLI (default)
+	AIBackendScripted = "scripted" // deterministic canned replies for tests and offline demos
+)
+
+// AIRequest is one conversation turn sent to a backend
+type AIRequest struct {
+	Prompt         string
+	SessionContext string // text summary of earlier messages, used when not resuming
+	ResumeID       string // backend session to continue, if any
+}
+
+// AIBackend answers conversation turns for a project. Stream delivers events
+// as they happen and always ends with a ClaudeEventResult or ClaudeEventError;
+// Ask collects them into the final result. Cancel aborts every run the backend
+// has in flight.
+type AIBackend interface {
+	Ask(ctx context.Context, req AIRequest) (*ClaudeResult, error)
+	Stream(ctx context.Context, req AIRequest) (<-chan ClaudeEvent, error)
+	Cancel()
+}
+
+// runTracker lets a backend cancel all of its in-flight runs
+type runTracker struct {
+	mu      sync.Mutex
+	next    int
+	cancels map[int]context.CancelFunc
+}
+
+// start derives a cancellable context for a run; finish must be called when it ends
+func (t *runTracker) start(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	t.mu.Lock()
+	if t.cancels == nil {
+		t.cancels = make(map[int]context.CancelFunc)
+	}
+	id := t.next
+	t.next++
+	t.cancels[id] = cancel
+	t.mu.Unlock()
+
+	finish := func() {
+		t.mu.Lock()
+		delete(t.cancels, id)
+		t.mu.Unlock()
+		cancel()
+	}
+	return ctx, finish
+}
+
+// cancelAll cancels every tracked run
+func (t *runTracker) cancelAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, cancel := range t.cancels {
+		cancel()
+	}
+}
+
+// trackEvents forwards events and calls finish once the stream is drained
+func trackEvents(events <-chan ClaudeEvent, finish func()) <-chan ClaudeEvent {
+	out := make(chan ClaudeEvent, 64)
+	go func() {
+		defer close(out)
+		defer finish()
+		for event := range events {
+			out <- event
+		}
+	}()
+	return out
+}
+
+// CLIBackend runs turns through the Claude Code CLI, in the project container
+// or on the host as the project's AgentConfig says. In the prompt permission
+// mode every Edit, Write and Bash call is put to the user through gate.
+type CLIBackend struct {
+	projectID     string
+	config        AgentConfig
+	dockerManager *DockerManager
+	gate          *ToolPermissionGate
+	runs          runTracker
+}
+
+// NewCLIBackend creates a CLI backend for a project
+func NewCLIBackend(projectID string, config AgentConfig, dm *DockerManager, gate *ToolPermissionGate) *CLIBackend {
+	return &CLIBackend{projectID: projectID, config: config, dockerManager: dm, gate: gate}
+}
+
+// agent resolves the ClaudeAgent for one run. The container is looked up each
+// time so a restarted or recreated container is picked up.
+func (b *CLIBackend) agent() (*ClaudeAgent, error) {
+	var agent *ClaudeAgent
+	switch b.config.Runtime {
+	case "", AgentRuntimeContainer:
+		var err error
+		if agent, err = b.dockerManager.ContainerClaudeAgent(b.projectID); err != nil {
+			return nil, err
+		}
+	case AgentRuntimeHost:
+		agent = NewClaudeAgent("claude")
+		agent.workDir = b.config.WorkDir
+	default:
+		return nil, fmt.Errorf("unknown agent runtime %q for project %s", b.config.Runtime, b.projectID)
+	}
+
+	if b.config.CLIPath != "" {
+		agent.cliPath = b.config.CLIPath
+	}
+	return agent, nil
+}
+
+func (b *CLIBackend) Stream(ctx context.Context, req AIRequest) (<-chan ClaudeEvent, error) {
+	agent, err := b.agent()
+	if err != nil {
+		return nil, err
+	}
+
+	args, release, err := b.permissionArgs(agent)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, finish := b.runs.start(ctx)
+	done := func() {
+		finish()
+		release()
+	}
+	events, err := agent.StreamConversation(ctx, req.Prompt, req.SessionContext, req.ResumeID, args...)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	return trackEvents(events, done), nil
+}
+
+// permissionArgs sets the agent up for the project's permission mode and
+// returns the CLI flags for it. release must be called once the run is over.
+func (b *CLIBackend) permissionArgs(agent *ClaudeAgent) ([]string, func(), error) {
+	switch b.config.Permissions {
+	case "", AgentPermissionsPrompt:
+		args, env, release := b.gate.register(b.projectID, agent)
+		agent.env = append(agent.env, env...)
+		return args, release, nil
+	case AgentPermissionsSkip:
+		if agent.containerID == "" {
+			return nil, nil, fmt.Errorf("permission mode %q needs the container runtime (project %s)", AgentPermissionsSkip, b.projectID)
+		}
+		return agent.conversationArgs(), func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown permission mode %q for project %s", b.config.Permissions, b.projectID)
+	}
+}
+
+func (b *CLIBackend) Ask(ctx context.Context, req AIRequest) (*ClaudeResult, error) {
+	events, err := b.Stream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return collectClaudeResult(events)
+}
+
+func (b *CLIBackend) Cancel() {
+	b.runs.cancelAll()
+}
+
+// backendEntry caches a project's backend with the config it was built from
+type backendEntry struct {
+	key     string
+	backend AIBackend
+}
+
+// backendFor returns the AI backend configured for a project. Backends are
+// cached per project and rebuilt when the project's agent config changes.
+func (s *Server) backendFor(projectID string) (AIBackend, error) {
+	config, err := s.configManager.LoadContainerConfig(projectID)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, _ := json.Marshal(config.Agent)
+	key := string(keyBytes)
+
+	s.backendsMutex.Lock()
+	defer s.backendsMutex.Unlock()
+
+	if entry, exists := s.backends[projectID]; exists && entry.key == key {
+		return entry.backend, nil
+	}
+
+	var backend AIBackend
+	switch config.Agent.Backend {
+	case "", AIBackendCLI:
+		backend = NewCLIBackend(projectID, config.Agent, s.dockerManager, s.permissionGate)
+	case AIBackendScripted:
+		backend = NewScriptedBackend(projectID, config.Agent.Script)
+	default:
+		return nil, fmt.Errorf("unknown AI backend %q for project %s", config.Agent.Backend, projectID)
+	}
+
+	s.backends[projectID] = &backendEntry{key: key, backend: backend}
+	log.Printf("🧠 Using %s AI backend for project %s", backendName(config.Agent.Backend), projectID)
+	return backend, nil
+}
+
+// cancelBackendRuns aborts every AI run in flight for a project
+func (s *Server) cancelBackendRuns(projectID string) {
+	s.backendsMutex.Lock()
+	entry := s.backends[projectID]
+	s.backendsMutex.Unlock()
+
+	if entry != nil {
+		entry.backend.Cancel()
+	}
+}
+
+func backendName(name string) string {
+	if name == "" {
+		return AIBackendCLI
+	}
+	return name
+}
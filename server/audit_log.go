@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Audit sources
+const (
+	AuditSourceCommand      = "command"       // routed command from claude_execute
+	AuditSourceStream       = "stream"        // shell command streamed by claude_execute_stream
+	AuditSourceQuickCommand = "quick_command" // quick command run through DockerManager.ExecuteCommand
+	AuditSourceSudo         = "sudo"          // host command run with sudo from the web interface
+	AuditSourceClaude       = "claude"        // Claude agent run
+	AuditSourceClaudeTool   = "claude_tool"   // tool call requested by the Claude agent
+	AuditSourceServer       = "server"        // the server's own work
+)
+
+// Audit permission decisions
+const (
+	AuditDecisionNotRequired = "not_required" // sent directly by the user, nothing to approve
+	AuditDecisionConfirmed   = "confirmed"    // confirmed by the user before running
+	AuditDecisionPassword    = "password"     // authorised by the sudo password
+	AuditDecisionAllow       = "allow"
+	AuditDecisionDeny        = "deny"
+)
+
+// maxAuditCommand caps the command text stored per entry
+const maxAuditCommand = 4 << 10
+
+// AuditContext says who runs a command, from which client, and why it was
+// allowed to run
+type AuditContext struct {
+	UserID    string
+	Client    string // "ws:<addr>" for phones, "web" for the web interface, "claude" for agent tool calls
+	Source    string
+	Decision  string
+	DecidedBy string // "user", "rule:<scope>/<id>", "timeout", ...
+}
+
+// AuditEntry is one line of the audit log. Hash covers the entry with an
+// empty Hash and the previous entry's hash, chaining the whole file.
+type AuditEntry struct {
+	Seq        int64     `json:"seq"`
+	Time       time.Time `json:"time"`
+	UserID     string    `json:"user_id"`
+	Client     string    `json:"client"`
+	ProjectID  string    `json:"project_id,omitempty"`
+	Source     string    `json:"source"`
+	Command    string    `json:"command"`
+	Decision   string    `json:"decision"`
+	DecidedBy  string    `json:"decided_by,omitempty"`
+	ExitCode   *int      `json:"exit_code,omitempty"` // nil when the command never ran
+	DurationMs int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// computeHash returns the chained hash of the entry
+func (e AuditEntry) computeHash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(append([]byte(e.PrevHash), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+// auditHead is the last appended entry, kept beside the log so that cutting
+// entries off the end of the file is detected
+type auditHead struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// AuditQuery filters audit entries; empty fields match everything
+type AuditQuery struct {
+	UserID    string    `json:"user_id,omitempty"`
+	ProjectID string    `json:"project_id,omitempty"`
+	Client    string    `json:"client,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	Decision  string    `json:"decision,omitempty"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Limit     int       `json:"limit"` // newest entries kept; defaults to 200
+}
+
+func (q AuditQuery) matches(e *AuditEntry) bool {
+	switch {
+	case q.UserID != "" && e.UserID != q.UserID,
+		q.ProjectID != "" && e.ProjectID != q.ProjectID,
+		q.Client != "" && e.Client != q.Client,
+		q.Source != "" && e.Source != q.Source,
+		q.Decision != "" && e.Decision != q.Decision,
+		!q.From.IsZero() && e.Time.Before(q.From),
+		!q.To.IsZero() && !e.Time.Before(q.To):
+		return false
+	}
+	return true
+}
+
+// AuditVerification is the result of checking the hash chain
+type AuditVerification struct {
+	Valid    bool     `json:"valid"`
+	Entries  int64    `json:"entries"`
+	LastSeq  int64    `json:"last_seq"`
+	LastHash string   `json:"last_hash"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// AuditLog is an append-only, hash-chained JSON lines log of executed
+// commands and permission decisions
+type AuditLog struct {
+	logPath  string
+	headPath string
+
+	mu       sync.Mutex
+	lastSeq  int64
+	lastHash string
+}
+
+// NewAuditLog opens the audit log under ~/.remoteclaude/audit and continues
+// its chain
+func NewAuditLog() *AuditLog {
+	auditDir := filepath.Join(os.Getenv("HOME"), ".remoteclaude", "audit")
+	os.MkdirAll(auditDir, 0700)
+
+	a := &AuditLog{
+		logPath:  filepath.Join(auditDir, "audit.log"),
+		headPath: filepath.Join(auditDir, "audit.head"),
+	}
+	if err := a.loadHead(); err != nil {
+		log.Printf("⚠️ Failed to read audit log head: %v", err)
+	}
+	return a
+}
+
+// loadHead picks up the chain where the last run left it
+func (a *AuditLog) loadHead() error {
+	var last *AuditEntry
+	err := a.scan(-1, func(e *AuditEntry, _ int) error {
+		last = e
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if last != nil {
+		a.lastSeq, a.lastHash = last.Seq, last.Hash
+	}
+	return nil
+}
+
+// Record appends an entry for a command run in projectID. exitCode is nil
+// when the command never started.
+func (a *AuditLog) Record(ac AuditContext, projectID, command string, exitCode *int, duration time.Duration, runErr error) {
+	if a == nil {
+		return
+	}
+	if ac.UserID == "" {
+		ac.UserID = DefaultUserID
+	}
+	if len(command) > maxAuditCommand {
+		command = command[:maxAuditCommand] + "… (truncated)"
+	}
+
+	entry := AuditEntry{
+		Time:       time.Now().UTC(),
+		UserID:     ac.UserID,
+		Client:     ac.Client,
+		ProjectID:  projectID,
+		Source:     ac.Source,
+		Command:    command,
+		Decision:   ac.Decision,
+		DecidedBy:  ac.DecidedBy,
+		ExitCode:   exitCode,
+		DurationMs: duration.Milliseconds(),
+	}
+	if runErr != nil {
+		entry.Error = runErr.Error()
+	}
+
+	if err := a.append(&entry); err != nil {
+		log.Printf("❌ Failed to write audit entry: %v", err)
+	}
+}
+
+func (a *AuditLog) append(entry *AuditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry.Seq = a.lastSeq + 1
+	entry.PrevHash = a.lastHash
+	entry.Hash = entry.computeHash()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	a.lastSeq, a.lastHash = entry.Seq, entry.Hash
+	head, _ := json.Marshal(auditHead{Seq: entry.Seq, Hash: entry.Hash})
+	return ioutil.WriteFile(a.headPath, head, 0600)
+}
+
+// scan calls fn for every entry in file order within the first size bytes
+// of the log, or the whole log when size is negative
+func (a *AuditLog) scan(size int64, fn func(e *AuditEntry, line int) error) error {
+	f, err := os.Open(a.logPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if size >= 0 {
+		r = io.LimitReader(f, size)
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if err := fn(&e, line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// snapshot returns the size of the log and the head file content as of the
+// last completed append. Readers scan that much of the log without holding
+// the lock, so they never stall Record and never see a half-written line.
+func (a *AuditLog) snapshot() (size int64, head []byte, headErr error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if info, err := os.Stat(a.logPath); err == nil {
+		size = info.Size()
+	}
+	head, headErr = ioutil.ReadFile(a.headPath)
+	return size, head, headErr
+}
+
+// Query returns the newest entries matching q, oldest first
+func (a *AuditLog) Query(q AuditQuery) ([]AuditEntry, error) {
+	size, _, _ := a.snapshot()
+
+	if q.Limit <= 0 {
+		q.Limit = 200
+	}
+	entries := []AuditEntry{}
+	err := a.scan(size, func(e *AuditEntry, _ int) error {
+		if q.matches(e) {
+			entries = append(entries, *e)
+			if len(entries) > q.Limit {
+				entries = entries[1:]
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// Verify walks the hash chain. Edited, reordered or removed entries break
+// the chain; a log cut short no longer reaches the recorded head.
+func (a *AuditLog) Verify() *AuditVerification {
+	size, data, err := a.snapshot()
+
+	result := &AuditVerification{}
+	problem := func(format string, args ...interface{}) {
+		if len(result.Problems) < 50 {
+			result.Problems = append(result.Problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	scanErr := a.scan(size, func(e *AuditEntry, line int) error {
+		if e.Seq != result.LastSeq+1 {
+			problem("line %d: sequence %d follows %d", line, e.Seq, result.LastSeq)
+		}
+		if e.PrevHash != result.LastHash {
+			problem("line %d (seq %d): previous hash does not match the entry before it", line, e.Seq)
+		}
+		if e.computeHash() != e.Hash {
+			problem("line %d (seq %d): content does not match its hash", line, e.Seq)
+		}
+		result.Entries++
+		result.LastSeq, result.LastHash = e.Seq, e.Hash
+		return nil
+	})
+	if scanErr != nil {
+		problem("unreadable log: %v", scanErr)
+	}
+
+	switch {
+	case os.IsNotExist(err):
+		if result.Entries > 0 {
+			problem("head file is missing")
+		}
+	case err != nil:
+		problem("unreadable head file: %v", err)
+	default:
+		var head auditHead
+		if err := json.Unmarshal(data, &head); err != nil {
+			problem("unreadable head file: %v", err)
+		} else if head.Seq != result.LastSeq || head.Hash != result.LastHash {
+			problem("log ends at seq %d but the head records seq %d; entries were removed or replaced", result.LastSeq, head.Seq)
+		}
+	}
+
+	result.Valid = len(result.Problems) == 0
+	return result
+}
+
+// exitCodeOf returns a pointer for AuditLog.Record
+func exitCodeOf(code int) *int {
+	return &code
+}
+
+// processExitCode is the exit code behind a process error: 0 without an
+// error, nil when the process did not run to an exit status
+func processExitCode(err error) *int {
+	if err == nil {
+		return exitCodeOf(0)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		return exitCodeOf(exitErr.ExitCode())
+	}
+	var statusErr *ExitStatusError
+	if errors.As(err, &statusErr) {
+		return exitCodeOf(statusErr.Code)
+	}
+	return nil
+}
+
+// auditQueryFromMap reads an AuditQuery from URL parameters
+func auditQueryFromMap(get func(string) string) (AuditQuery, error) {
+	q := AuditQuery{
+		UserID:    get("user_id"),
+		ProjectID: get("project_id"),
+		Client:    get("client"),
+		Source:    get("source"),
+		Decision:  get("decision"),
+	}
+	for key, t := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if value := get(key); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				if parsed, err = time.Parse(usageDateFormat, value); err != nil {
+					return q, fmt.Errorf("invalid %s date %q (want RFC 3339 or YYYY-MM-DD)", key, value)
+				}
+			}
+			*t = parsed
+		}
+	}
+	if value := get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return q, fmt.Errorf("invalid limit %q", value)
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
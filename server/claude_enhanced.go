@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"os"
 	"os/exec"
 	"strings"
 	"time"
@@ -10,199 +16,378 @@ import (
 
 // ClaudeAgent handles communication with local Claude Code CLI
 type ClaudeAgent struct {
-	cliPath string
-	timeout time.Duration
+	cliPath     string
+	timeout     time.Duration  // upper bound for a single run; 0 means no limit
+	containerID string         // run the CLI in this container; empty runs on the host
+	dm          *DockerManager // container runs only: execs through its engine
+	workDir     string         // host runs only: default working directory
+	env         []string       // extra NAME=value environment for the CLI
 }
 
+// claudeStreamArgs makes the CLI print one JSON event per line, including
+// partial text deltas
+var claudeStreamArgs = []string{"--print", "--output-format", "stream-json", "--verbose", "--include-partial-messages"}
+
 // NewClaudeAgent creates a new Claude agent instance
 func NewClaudeAgent(cliPath string) *ClaudeAgent {
 	return &ClaudeAgent{
 		cliPath: cliPath,
-		timeout: 30 * time.Second, // 30 second timeout
+		timeout: 10 * time.Minute, // agentic runs with tool use take minutes
 	}
 }
 
-// Ask sends a prompt to Claude Code CLI and returns the response (with full permissions)
-func (c *ClaudeAgent) Ask(prompt string) (string, error) {
-	return c.AskWithFullPermissions(prompt)
+// NewContainerClaudeAgent creates an agent that runs the CLI inside a container's /workspace
+func NewContainerClaudeAgent(dm *DockerManager, containerID string) *ClaudeAgent {
+	agent := NewClaudeAgent("claude")
+	agent.containerID = containerID
+	agent.dm = dm
+	return agent
 }
 
-// AskWithoutPermissions sends a prompt to Claude CLI without file operation permissions
-func (c *ClaudeAgent) AskWithoutPermissions(prompt string) (string, error) {
-	// Use claude --print without permission flags - Claude will ask for permission if needed
-	cmd := exec.Command(c.cliPath, "--print", prompt)
-	
-	// Set up output buffers
-	var out bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &stderr
-	
-	// Set timeout
-	if c.timeout > 0 {
-		cmd.WaitDelay = c.timeout
+// conversationArgs are the flags for conversation turns that skip permission
+// prompts. That is only safe when the CLI is confined to a container.
+func (c *ClaudeAgent) conversationArgs() []string {
+	if c.containerID == "" {
+		return nil
 	}
-	
-	// Run the command
-	err := cmd.Run()
+	return []string{"--permission-mode", "acceptEdits", "--dangerously-skip-permissions"}
+}
+
+// ContainerClaudeAgent returns an agent bound to the project's running container
+func (dm *DockerManager) ContainerClaudeAgent(projectID string) (*ClaudeAgent, error) {
+	containerID, err := dm.getContainerID(projectID)
 	if err != nil {
-		// If Claude CLI fails, return a fallback response
-		return fmt.Sprintf("I apologize, but I'm having trouble processing your request right now. Error: %s", err.Error()), nil
+		return nil, err
 	}
-	
-	response := strings.TrimSpace(out.String())
-	if response == "" {
-		return "I'm sorry, but I couldn't generate a response to your request.", nil
+	if err := dm.ensureContainerRunning(containerID, projectID); err != nil {
+		return nil, fmt.Errorf("failed to ensure container is running: %w", err)
 	}
-	
-	return response, nil
+	return NewContainerClaudeAgent(dm, containerID), nil
 }
 
-// AskWithFullPermissions sends a prompt to Claude CLI with full permissions granted
-func (c *ClaudeAgent) AskWithFullPermissions(prompt string) (string, error) {
-	// Use claude --print with permission settings for Docker container safety
-	cmd := exec.Command(c.cliPath, 
-		"--print", 
-		"--permission-mode", "acceptEdits",
-		"--dangerously-skip-permissions", // Safe in Docker container
-		prompt)
-	
-	// Set up output buffers
-	var out bytes.Buffer
+// claudeProcess is one running CLI invocation. stderr may only be read
+// once wait has returned.
+type claudeProcess struct {
+	stdout io.Reader
+	stderr *bytes.Buffer
+	wait   func() error
+}
+
+// start runs the CLI with the prompt on stdin. Cancelling ctx kills the
+// process group on the host, or every process of the run in the container.
+func (c *ClaudeAgent) start(ctx context.Context, args []string, prompt, workDir string) (*claudeProcess, error) {
+	argv := append([]string{c.cliPath}, args...)
+	if c.containerID != "" {
+		return c.startInContainer(ctx, argv, prompt), nil
+	}
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	if len(c.env) > 0 {
+		cmd.Env = append(os.Environ(), c.env...)
+	}
+	cmd.Dir = workDir
+	configureProcessGroup(cmd, nil)
+	cmd.Stdin = strings.NewReader(prompt)
+
 	var stderr bytes.Buffer
-	cmd.Stdout = &out
 	cmd.Stderr = &stderr
-	
-	// Set timeout
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start claude: %v", err)
+	}
+	return &claudeProcess{stdout: stdout, stderr: &stderr, wait: cmd.Wait}, nil
+}
+
+// startInContainer runs the CLI through the Docker Engine API. Closing the
+// exec stream leaves the CLI running, so a cancelled run is killed by its
+// run ID.
+func (c *ClaudeAgent) startInContainer(ctx context.Context, argv []string, prompt string) *claudeProcess {
+	runID := newRunID()
+	env := []string{"PATH=/usr/local/bin:/usr/bin:/bin:/sbin", containerRunEnv + "=" + runID}
+	if key := os.Getenv("ANTHROPIC_API_KEY"); key != "" {
+		env = append(env, "ANTHROPIC_API_KEY="+key)
+	}
+	env = append(env, c.env...)
+
+	stdout, output := io.Pipe()
+	var stderr bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		code, err := c.dm.engine.Exec(ctx, c.containerID, ExecOptions{
+			Cmd:        argv,
+			Env:        env,
+			WorkingDir: workspaceRoot,
+			Stdin:      strings.NewReader(prompt),
+			Stdout:     output,
+			Stderr:     &stderr,
+		})
+		if ctx.Err() != nil {
+			c.dm.killRun(c.containerID, runID)
+		}
+		if err == nil && code != 0 {
+			err = &ExitStatusError{Code: code}
+		}
+		output.Close()
+		done <- err
+	}()
+	return &claudeProcess{stdout: stdout, stderr: &stderr, wait: func() error { return <-done }}
+}
+
+// Stream runs the CLI with stream-json output and delivers its events on the
+// returned channel. The prompt is passed on stdin so it needs no quoting.
+// The channel always ends with a ClaudeEventResult or a ClaudeEventError and
+// is then closed. Cancelling ctx, or exceeding the agent timeout, kills the
+// run and ends the stream with ErrRunCanceled or ErrRunTimeout.
+func (c *ClaudeAgent) Stream(ctx context.Context, prompt, workDir string, extraArgs ...string) (<-chan ClaudeEvent, error) {
+	cancel := context.CancelFunc(func() {})
 	if c.timeout > 0 {
-		cmd.WaitDelay = c.timeout
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+	}
+
+	if workDir == "" {
+		workDir = c.workDir
 	}
-	
-	// Run the command
-	err := cmd.Run()
+	proc, err := c.start(ctx, append(append([]string{}, claudeStreamArgs...), extraArgs...), prompt, workDir)
 	if err != nil {
-		// If Claude CLI fails, return a fallback response
-		return fmt.Sprintf("I apologize, but I'm having trouble processing your request right now. Error: %s", err.Error()), nil
+		cancel()
+		return nil, err
 	}
-	
-	response := strings.TrimSpace(out.String())
-	if response == "" {
-		return "I'm sorry, but I couldn't generate a response to your request.", nil
+	stdout := proc.stdout
+
+	events := make(chan ClaudeEvent, 64)
+	go func() {
+		defer close(events)
+		defer cancel()
+
+		parser := &claudeStreamParser{}
+		var unparsed []string
+		sawResult := false
+
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64*1024), 16<<20)
+		for scanner.Scan() {
+			parsed, ok := parser.parseLine(scanner.Bytes())
+			if !ok {
+				if line := strings.TrimSpace(scanner.Text()); line != "" {
+					unparsed = append(unparsed, line)
+				}
+				continue
+			}
+			for _, event := range parsed {
+				if event.Type == ClaudeEventResult {
+					sawResult = true
+				}
+				events <- event
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			unparsed = append(unparsed, fmt.Sprintf("failed to read claude output: %v", err))
+			io.Copy(io.Discard, stdout)
+		}
+
+		waitErr := proc.wait()
+		if sawResult {
+			return
+		}
+		if err := runContextError(ctx, c.timeout); err != nil {
+			events <- ClaudeEvent{Type: ClaudeEventError, Error: err.Error(), Err: err}
+			return
+		}
+
+		// No result event: report whatever the CLI printed instead
+		msg := strings.TrimSpace(proc.stderr.String())
+		if msg == "" {
+			msg = strings.Join(unparsed, "\n")
+		}
+		if msg == "" && waitErr != nil {
+			msg = waitErr.Error()
+		}
+		if msg == "" {
+			msg = "claude exited without a result"
+		}
+		events <- ClaudeEvent{Type: ClaudeEventError, Error: msg, Err: errors.New(msg)}
+	}()
+
+	return events, nil
+}
+
+// Run streams a prompt and collects the events into the final result
+func (c *ClaudeAgent) Run(ctx context.Context, prompt, workDir string, extraArgs ...string) (*ClaudeResult, error) {
+	events, err := c.Stream(ctx, prompt, workDir, extraArgs...)
+	if err != nil {
+		return nil, err
 	}
-	
-	return response, nil
+	return collectClaudeResult(events)
+}
+
+// collectClaudeResult drains an event stream into its final result
+func collectClaudeResult(events <-chan ClaudeEvent) (*ClaudeResult, error) {
+	var result *ClaudeResult
+	var text strings.Builder
+	var runErr error
+	for event := range events {
+		switch event.Type {
+		case ClaudeEventText:
+			text.WriteString(event.Text)
+		case ClaudeEventResult:
+			result = event.Result
+		case ClaudeEventError:
+			runErr = event.Err
+		}
+	}
+
+	if result == nil {
+		return nil, runErr
+	}
+	if result.Text == "" {
+		result.Text = text.String()
+	}
+	if result.IsError {
+		return result, fmt.Errorf("claude run failed (%s): %s", result.Subtype, result.Text)
+	}
+	return result, nil
+}
+
+// StreamConversation streams one conversation turn. With a resumeID the CLI
+// continues that session, tool history included, and sessionContext is not
+// needed. When the CLI no longer knows the session the turn starts a new one
+// with sessionContext pasted into the prompt instead.
+func (c *ClaudeAgent) StreamConversation(ctx context.Context, input, sessionContext, resumeID string, extraArgs ...string) (<-chan ClaudeEvent, error) {
+	fresh := func() (<-chan ClaudeEvent, error) {
+		return c.Stream(ctx, promptWithContext(input, sessionContext), "", extraArgs...)
+	}
+	if resumeID == "" {
+		return fresh()
+	}
+
+	resumed, err := c.Stream(ctx, input, "", append([]string{"--resume", resumeID}, extraArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan ClaudeEvent, 64)
+	go func() {
+		defer close(events)
+
+		// A missing session fails before anything else is printed
+		first, ok := <-resumed
+		if ok && first.Type == ClaudeEventError && isMissingSessionError(first.Err) {
+			log.Printf("⚠️ Claude session %s not found, starting a new one", resumeID)
+			retry, err := fresh()
+			if err != nil {
+				events <- ClaudeEvent{Type: ClaudeEventError, Error: err.Error(), Err: err}
+				return
+			}
+			resumed, ok = retry, false
+		}
+		if ok {
+			events <- first
+		}
+		for event := range resumed {
+			events <- event
+		}
+	}()
+	return events, nil
+}
+
+// Converse runs one conversation turn and returns its result; see StreamConversation
+func (c *ClaudeAgent) Converse(ctx context.Context, input, sessionContext, resumeID string, extraArgs ...string) (*ClaudeResult, error) {
+	events, err := c.StreamConversation(ctx, input, sessionContext, resumeID, extraArgs...)
+	if err != nil {
+		return nil, err
+	}
+	return collectClaudeResult(events)
+}
+
+// isMissingSessionError reports whether a --resume run failed because the CLI
+// has no record of the session, e.g. after its history was cleaned up
+func isMissingSessionError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "No conversation found")
+}
+
+// promptWithContext prefixes input with the text summary of earlier messages
+func promptWithContext(input, sessionContext string) string {
+	if sessionContext == "" {
+		return input
+	}
+	return fmt.Sprintf("Context from previous conversation:\n%s\nCurrent request: %s", sessionContext, input)
+}
+
+// Ask sends a prompt to Claude Code CLI and returns the response (with full permissions)
+func (c *ClaudeAgent) Ask(ctx context.Context, prompt string) (string, error) {
+	return c.AskWithFullPermissions(ctx, prompt)
+}
+
+// AskWithoutPermissions sends a prompt to Claude CLI without file operation permissions
+func (c *ClaudeAgent) AskWithoutPermissions(ctx context.Context, prompt string) (string, error) {
+	// No permission flags - tools that need approval are refused
+	return c.ask(ctx, prompt, "")
+}
+
+// AskWithFullPermissions sends a prompt to Claude CLI with full permissions granted
+func (c *ClaudeAgent) AskWithFullPermissions(ctx context.Context, prompt string) (string, error) {
+	return c.ask(ctx, prompt, "",
+		"--permission-mode", "acceptEdits",
+		"--dangerously-skip-permissions") // Caller has granted permission; see conversationArgs for the default
 }
 
 // AskWithWorkspace sends a prompt to Claude Code CLI with specific workspace access
-func (c *ClaudeAgent) AskWithWorkspace(prompt string, workspaceDir string) (string, error) {
-	// Use claude --print with workspace directory access
-	cmd := exec.Command(c.cliPath,
-		"--print",
-		"--permission-mode", "acceptEdits", 
+func (c *ClaudeAgent) AskWithWorkspace(ctx context.Context, prompt string, workspaceDir string) (string, error) {
+	return c.ask(ctx, prompt, workspaceDir,
+		"--permission-mode", "acceptEdits",
 		"--add-dir", workspaceDir,
-		"--dangerously-skip-permissions", // Safe in Docker container
-		prompt)
-	
-	// Set working directory to the workspace
-	cmd.Dir = workspaceDir
-	
-	// Set up output buffers
-	var out bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &stderr
-	
-	// Set timeout
-	if c.timeout > 0 {
-		cmd.WaitDelay = c.timeout
+		"--dangerously-skip-permissions") // Caller has granted permission; see conversationArgs for the default
+}
+
+// ask runs a prompt and turns failures into the fallback replies shown to the
+// user. Timeouts and cancellation are returned as errors instead.
+func (c *ClaudeAgent) ask(ctx context.Context, prompt, workDir string, extraArgs ...string) (string, error) {
+	result, err := c.Run(ctx, prompt, workDir, extraArgs...)
+	if errors.Is(err, ErrRunTimeout) || errors.Is(err, ErrRunCanceled) {
+		return "", err
 	}
-	
-	// Run the command
-	err := cmd.Run()
 	if err != nil {
+		// If Claude CLI fails, return a fallback response
 		return fmt.Sprintf("I apologize, but I'm having trouble processing your request right now. Error: %s", err.Error()), nil
 	}
-	
-	response := strings.TrimSpace(out.String())
+
+	response := strings.TrimSpace(result.Text)
 	if response == "" {
 		return "I'm sorry, but I couldn't generate a response to your request.", nil
 	}
-	
+
 	return response, nil
 }
 
-// Global Claude agent instance and permission manager
-var claudeAgent *ClaudeAgent
-var permissionManager *PermissionManager
-
-// Initialize Claude agent and permission manager on startup
-func init() {
-	claudeAgent = NewClaudeAgent("claude")
-	permissionManager = NewPermissionManager()
-}
+// Global permission manager
+var permissionManager = NewPermissionManager()
 
-// generateEnhancedClaudeResponse uses real Claude Code CLI with permission handling
-func generateEnhancedClaudeResponse(input, context string) string {
+// generateEnhancedClaudeResponse runs a conversation turn on backend,
+// resuming the session resumeID when set. The run's final result, which
+// carries the session to resume next time and the usage, is returned
+// whenever the backend produced one, even for a failed turn.
+func generateEnhancedClaudeResponse(ctx context.Context, backend AIBackend, input, sessionContext, resumeID string) (string, *ClaudeResult, error) {
 	input = strings.TrimSpace(input)
-	
+
 	if input == "" {
-		return "Hello! How can I help you today?"
+		return "Hello! How can I help you today?", nil, nil
+	}
+
+	result, err := backend.Ask(ctx, AIRequest{Prompt: input, SessionContext: sessionContext, ResumeID: resumeID})
+	if errors.Is(err, ErrRunTimeout) || errors.Is(err, ErrRunCanceled) {
+		return "", result, err
 	}
-	
-	// Call real Claude Code CLI (without permissions initially)
-	response, err := claudeAgent.AskWithoutPermissions(input)
 	if err != nil {
 		// Fallback to simple response on error
-		return fmt.Sprintf("I understand you're asking about \"%s\". Could you provide a bit more context so I can give you the most helpful response?", input)
+		return fmt.Sprintf("I apologize, but I'm having trouble processing your request right now. Error: %s", err.Error()), result, nil
 	}
-	
-	return response
-}
 
-// generateClaudeResponseWithPermissions handles Claude responses that may need permissions
-func generateClaudeResponseWithPermissions(input, context string, projectID string, s *Server) (string, error) {
-	input = strings.TrimSpace(input)
-	
-	if input == "" {
-		return "Hello! How can I help you today?", nil
-	}
-	
-	// Call Claude CLI without permissions first to get the response
-	response, err := claudeAgent.AskWithoutPermissions(input)
-	if err != nil {
-		return fmt.Sprintf("I understand you're asking about \"%s\". Could you provide a bit more context so I can give you the most helpful response?", input), nil
-	}
-	
-	// Check if response requires permission
-	permReq := permissionManager.DetectPermissionNeeded(response)
-	if permReq != nil {
-		// Add to pending requests
-		permissionManager.AddPendingRequest(permReq)
-		
-		// Send permission request to client via WebSocket
-		err := s.sendPermissionRequest(projectID, permReq)
-		if err != nil {
-			return response, nil // Return original response if can't send permission request
-		}
-		
-		// Wait for user response
-		userResp, received := permissionManager.WaitForResponse(permReq.RequestID)
-		if !received {
-			return response + "\n\n⏰ Permission request timed out. The operation was not performed.", nil
-		}
-		
-		if !userResp.Approved {
-			return response + "\n\n❌ Permission denied. The operation was not performed.", nil
-		}
-		
-		// Permission granted - execute with full permissions
-		authorizedResponse, err := claudeAgent.AskWithFullPermissions(input)
-		if err != nil {
-			return response + "\n\n✅ Permission granted, but execution failed: " + err.Error(), nil
-		}
-		
-		return "✅ Permission granted!\n\n" + authorizedResponse, nil
+	response := strings.TrimSpace(result.Text)
+	if response == "" {
+		response = "I'm sorry, but I couldn't generate a response to your request."
 	}
-	
-	return response, nil
-}
\ No newline at end of file
+	return response, result, nil
+}
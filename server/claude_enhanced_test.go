@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestContainerAgentRunsThroughEngine(t *testing.T) {
+	dm, engine := newTestDockerManager(t)
+	project := startTestProject(t, dm, "agent")
+
+	var prompt string
+	var env []string
+	engine.ExecFunc = func(ctx context.Context, c *FakeContainer, opts ExecOptions) (int, error) {
+		input, _ := ioutil.ReadAll(opts.Stdin)
+		prompt, env = string(input), opts.Env
+		fmt.Fprintln(opts.Stdout, `{"type":"result","subtype":"success","result":"done","session_id":"s1"}`)
+		return 0, nil
+	}
+
+	agent, err := dm.ContainerClaudeAgent(project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := agent.Run(context.Background(), "fix the tests", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Text != "done" || result.SessionID != "s1" {
+		t.Errorf("result = %+v", result)
+	}
+	if prompt != "fix the tests" {
+		t.Errorf("CLI read %q on stdin", prompt)
+	}
+	if !strings.Contains(strings.Join(env, " "), containerRunEnv+"=") {
+		t.Errorf("run is not tagged: env = %v", env)
+	}
+}
+
+func TestContainerAgentCancelKillsRun(t *testing.T) {
+	dm, engine := newTestDockerManager(t)
+	project := startTestProject(t, dm, "cancel")
+
+	started := make(chan struct{})
+	engine.ExecFunc = func(ctx context.Context, c *FakeContainer, opts ExecOptions) (int, error) {
+		if opts.Cmd[0] != "claude" {
+			return 0, nil // the kill script
+		}
+		close(started)
+		<-ctx.Done()
+		return -1, ctx.Err()
+	}
+
+	agent, err := dm.ContainerClaudeAgent(project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := agent.Stream(ctx, "long task", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	cancel()
+
+	all := drain(t, events)
+	if last := all[len(all)-1]; last.Type != ClaudeEventError || !errors.Is(last.Err, ErrRunCanceled) {
+		t.Fatalf("last event = %+v, want a canceled error", last)
+	}
+	c, _ := engine.Container(project.ContainerID)
+	last := c.Execs[len(c.Execs)-1]
+	if len(last) < 3 || last[2] != killRunScript {
+		t.Errorf("last exec = %q, want the kill script", last)
+	}
+}
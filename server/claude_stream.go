@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// ClaudeEventType identifies a parsed event from `claude --output-format stream-json`
+type ClaudeEventType string
+
+const (
+	ClaudeEventInit       ClaudeEventType = "init"        // session started
+	ClaudeEventText       ClaudeEventType = "text"        // assistant text, incremental when partial messages are on
+	ClaudeEventToolUse    ClaudeEventType = "tool_use"    // Claude invoked a tool
+	ClaudeEventToolResult ClaudeEventType = "tool_result" // output of a tool call
+	ClaudeEventResult     ClaudeEventType = "result"      // final result with cost and usage
+	ClaudeEventError      ClaudeEventType = "error"       // the run failed before producing a result
+)
+
+// maxToolOutputLen caps tool output carried in a single event
+const maxToolOutputLen = 16 << 10
+
+// ClaudeUsage is the token usage reported by the CLI
+type ClaudeUsage struct {
+	InputTokens              int64 `json:"input_tokens"`
+	OutputTokens             int64 `json:"output_tokens"`
+	CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+}
+
+// ClaudeResult is the final "result" event of a run
+type ClaudeResult struct {
+	SessionID     string      `json:"session_id"`
+	Subtype       string      `json:"subtype"` // "success" or an error_* subtype
+	Text          string      `json:"text"`
+	IsError       bool        `json:"is_error"`
+	NumTurns      int         `json:"num_turns"`
+	DurationMs    int64       `json:"duration_ms"`
+	DurationAPIMs int64       `json:"duration_api_ms"`
+	TotalCostUSD  float64     `json:"total_cost_usd"`
+	Usage         ClaudeUsage `json:"usage"`
+}
+
+// ClaudeEvent is one typed event delivered on the ClaudeAgent.Stream channel
+type ClaudeEvent struct {
+	Type       ClaudeEventType `json:"type"`
+	SessionID  string          `json:"session_id,omitempty"`
+	Model      string          `json:"model,omitempty"`
+	Text       string          `json:"text,omitempty"`
+	ToolUseID  string          `json:"tool_use_id,omitempty"`
+	ToolName   string          `json:"tool_name,omitempty"`
+	ToolInput  json.RawMessage `json:"tool_input,omitempty"`
+	ToolOutput string          `json:"tool_output,omitempty"`
+	IsError    bool            `json:"is_error,omitempty"`
+	Result     *ClaudeResult   `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Err        error           `json:"-"` // typed error behind Error, e.g. ErrRunTimeout
+}
+
+// claudeStreamLine is the raw shape of one stream-json line
+type claudeStreamLine struct {
+	Type      string `json:"type"`
+	Subtype   string `json:"subtype"`
+	SessionID string `json:"session_id"`
+	Model     string `json:"model"`
+
+	// assistant / user
+	Message *claudeMessage `json:"message"`
+
+	// stream_event (--include-partial-messages)
+	Event *struct {
+		Type  string `json:"type"`
+		Delta *struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"delta"`
+	} `json:"event"`
+
+	// result
+	IsError       bool        `json:"is_error"`
+	Result        string      `json:"result"`
+	NumTurns      int         `json:"num_turns"`
+	DurationMs    int64       `json:"duration_ms"`
+	DurationAPIMs int64       `json:"duration_api_ms"`
+	TotalCostUSD  float64     `json:"total_cost_usd"`
+	Usage         ClaudeUsage `json:"usage"`
+}
+
+// claudeMessage holds the content of an assistant or user message
+type claudeMessage struct {
+	Content json.RawMessage `json:"content"`
+}
+
+// claudeContentBlock is one entry of a message's content array
+type claudeContentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+	ToolUseID string          `json:"tool_use_id"`
+	Content   json.RawMessage `json:"content"`
+	IsError   bool            `json:"is_error"`
+}
+
+// claudeStreamParser turns stream-json lines into ClaudeEvents. It remembers
+// whether text already arrived as deltas so the complete assistant message
+// that follows does not repeat it.
+type claudeStreamParser struct {
+	streamedText bool
+}
+
+// parseLine parses one line of output. ok is false when the line is not a
+// stream-json object, e.g. a plain error message printed by the CLI.
+func (p *claudeStreamParser) parseLine(line []byte) (events []ClaudeEvent, ok bool) {
+	var raw claudeStreamLine
+	if err := json.Unmarshal(line, &raw); err != nil || raw.Type == "" {
+		return nil, false
+	}
+
+	switch raw.Type {
+	case "system":
+		if raw.Subtype == "init" {
+			events = append(events, ClaudeEvent{Type: ClaudeEventInit, SessionID: raw.SessionID, Model: raw.Model})
+		}
+
+	case "stream_event":
+		if raw.Event != nil && raw.Event.Type == "content_block_delta" &&
+			raw.Event.Delta != nil && raw.Event.Delta.Type == "text_delta" && raw.Event.Delta.Text != "" {
+			p.streamedText = true
+			events = append(events, ClaudeEvent{Type: ClaudeEventText, SessionID: raw.SessionID, Text: raw.Event.Delta.Text})
+		}
+
+	case "assistant":
+		for _, block := range messageBlocks(raw.Message) {
+			switch block.Type {
+			case "text":
+				if !p.streamedText && block.Text != "" {
+					events = append(events, ClaudeEvent{Type: ClaudeEventText, SessionID: raw.SessionID, Text: block.Text})
+				}
+			case "tool_use":
+				events = append(events, ClaudeEvent{
+					Type:      ClaudeEventToolUse,
+					SessionID: raw.SessionID,
+					ToolUseID: block.ID,
+					ToolName:  block.Name,
+					ToolInput: block.Input,
+				})
+			}
+		}
+		p.streamedText = false
+
+	case "user":
+		for _, block := range messageBlocks(raw.Message) {
+			if block.Type != "tool_result" {
+				continue
+			}
+			events = append(events, ClaudeEvent{
+				Type:       ClaudeEventToolResult,
+				SessionID:  raw.SessionID,
+				ToolUseID:  block.ToolUseID,
+				ToolOutput: toolResultText(block.Content),
+				IsError:    block.IsError,
+			})
+		}
+
+	case "result":
+		events = append(events, ClaudeEvent{
+			Type:      ClaudeEventResult,
+			SessionID: raw.SessionID,
+			IsError:   raw.IsError,
+			Result: &ClaudeResult{
+				SessionID:     raw.SessionID,
+				Subtype:       raw.Subtype,
+				Text:          raw.Result,
+				IsError:       raw.IsError,
+				NumTurns:      raw.NumTurns,
+				DurationMs:    raw.DurationMs,
+				DurationAPIMs: raw.DurationAPIMs,
+				TotalCostUSD:  raw.TotalCostUSD,
+				Usage:         raw.Usage,
+			},
+		})
+	}
+	return events, true
+}
+
+// messageBlocks decodes a message content array; string content yields nothing
+func messageBlocks(message *claudeMessage) []claudeContentBlock {
+	if message == nil {
+		return nil
+	}
+	var blocks []claudeContentBlock
+	if err := json.Unmarshal(message.Content, &blocks); err != nil {
+		return nil
+	}
+	return blocks
+}
+
+// toolResultText flattens tool_result content, which is either a string or a
+// list of content blocks, and truncates it to maxToolOutputLen
+func toolResultText(raw json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		var blocks []claudeContentBlock
+		if err := json.Unmarshal(raw, &blocks); err == nil {
+			var parts []string
+			for _, block := range blocks {
+				if block.Type == "text" {
+					parts = append(parts, block.Text)
+				}
+			}
+			text = strings.Join(parts, "\n")
+		}
+	}
+	if len(text) > maxToolOutputLen {
+		cut := maxToolOutputLen
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut] + "\n… (truncated)"
+	}
+	return text
+}
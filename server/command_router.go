@@ -1,116 +1,571 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Command Router for unified command processing
 type CommandRouter struct {
-	prefixMap map[string]CommandHandler
+	mu       sync.RWMutex
+	handlers map[string]*commandRoute
+	// prefixes is kept sorted longest-first so matching is deterministic
+	prefixes []string
 }
 
+// commandRoute binds a registered prefix to its handler
+type commandRoute struct {
+	prefix  string
+	primary string // prefix the handler was registered under (differs for aliases)
+	handler CommandHandler
+}
+
+// CommandHandler executes a prefixed command. The command passed to Execute
+// is the text following the matched prefix.
 type CommandHandler interface {
-	Execute(s *Server, projectID, command, context string) (string, error)
+	Execute(s *Server, projectID, command, context string) (*CommandResult, error)
 	GetDescription() string
 }
 
+// ContextCommandHandler is implemented by handlers whose work can be cancelled.
+// The router prefers ExecuteContext over Execute when it is available.
+type ContextCommandHandler interface {
+	ExecuteContext(ctx context.Context, s *Server, projectID, command, sessionContext string) (*CommandResult, error)
+}
+
+// CommandResult is the structured outcome of a routed command
+type CommandResult struct {
+	Stdout      string      `json:"stdout"`
+	Stderr      string      `json:"stderr,omitempty"`
+	ExitCode    int         `json:"exit_code"`
+	DurationMs  int64       `json:"duration_ms"`
+	Handler     string      `json:"handler"`                // prefix or "shell" / "claude"
+	PayloadType string      `json:"payload_type,omitempty"` // identifies the Payload schema for clients
+	Payload     interface{} `json:"payload,omitempty"`
+	Usage       *UsageRecord `json:"usage,omitempty"` // Claude runs only
+}
+
+// Success reports whether the command completed with a zero exit code
+func (r *CommandResult) Success() bool {
+	return r.ExitCode == 0
+}
+
+// Output returns stdout followed by stderr, matching the legacy text output
+func (r *CommandResult) Output() string {
+	if r.Stderr == "" {
+		return r.Stdout
+	}
+	if r.Stdout == "" || strings.HasSuffix(r.Stdout, "\n") {
+		return r.Stdout + r.Stderr
+	}
+	return r.Stdout + "\n" + r.Stderr
+}
+
+// execCommandResult converts a container exec result into a CommandResult
+func execCommandResult(r *ExecResult) *CommandResult {
+	return &CommandResult{
+		Stdout:     string(r.Stdout),
+		Stderr:     string(r.Stderr),
+		ExitCode:   r.ExitCode,
+		DurationMs: r.Duration.Milliseconds(),
+	}
+}
+
+// textCommandResult wraps plain text produced by the server itself
+func textCommandResult(text string) *CommandResult {
+	return &CommandResult{Stdout: text}
+}
+
+// errorCommandResult records a handler error as a failed result
+func errorCommandResult(handler string, err error) *CommandResult {
+	return &CommandResult{
+		Stderr:   err.Error(),
+		ExitCode: 1,
+		Handler:  handler,
+	}
+}
+
+// execCommand runs a shell command in the project container as a CommandResult
+func (s *Server) execCommand(projectID, command string) (*CommandResult, error) {
+	result, err := s.dockerManager.Exec(projectID, command)
+	if err != nil {
+		return nil, err
+	}
+	return execCommandResult(result), nil
+}
+
+// CommandSpec describes how a prefixed command is invoked
+type CommandSpec struct {
+	Aliases  []string // additional prefixes routed to the same handler
+	Usage    string   // argument synopsis shown in help, e.g. "<operation> <file> [content]"
+	Help     string   // longer help text
+	Examples []string
+}
+
+// CommandSpecProvider is implemented by handlers that declare aliases, arguments and help text
+type CommandSpecProvider interface {
+	GetSpec() CommandSpec
+}
+
+// CommandInfo summarises a registered handler for help output
+type CommandInfo struct {
+	Prefix      string   `json:"prefix"`
+	Aliases     []string `json:"aliases,omitempty"`
+	Description string   `json:"description"`
+	Usage       string   `json:"usage,omitempty"`
+	Help        string   `json:"help,omitempty"`
+	Examples    []string `json:"examples,omitempty"`
+}
+
 // Code Execution Handler
 type CodeExecutionHandler struct{}
 
-func (h *CodeExecutionHandler) Execute(s *Server, projectID, command, context string) (string, error) {
-	// Remove "code:" prefix and execute as shell command
-	actualCommand := strings.TrimSpace(strings.TrimPrefix(command, "code:"))
-	return s.dockerManager.ExecuteCommand(projectID, actualCommand)
+func (h *CodeExecutionHandler) Execute(s *Server, projectID, command, context string) (*CommandResult, error) {
+	// Execute remainder as shell command
+	actualCommand := strings.TrimSpace(command)
+	return s.execCommand(projectID, actualCommand)
 }
 
 func (h *CodeExecutionHandler) GetDescription() string {
 	return "Execute shell commands and code directly in the container"
 }
 
+func (h *CodeExecutionHandler) GetSpec() CommandSpec {
+	return CommandSpec{
+		Usage:    "<shell command>",
+		Examples: []string{"code: ls -la", "code: python hello.py"},
+	}
+}
+
 // Enhanced Claude CLI Handler
 type ClaudeHandler struct{}
 
-func (h *ClaudeHandler) Execute(s *Server, projectID, command, context string) (string, error) {
+func (h *ClaudeHandler) Execute(s *Server, projectID, command, sessionContext string) (*CommandResult, error) {
+	return h.ExecuteContext(context.Background(), s, projectID, command, sessionContext)
+}
+
+func (h *ClaudeHandler) ExecuteContext(ctx context.Context, s *Server, projectID, command, sessionContext string) (*CommandResult, error) {
 	// Special handling for claude --help command
 	if strings.Contains(strings.ToLower(command), "claude") && strings.Contains(strings.ToLower(command), "help") {
-		return generateContextualHelp("en"), nil
+		return textCommandResult(generateContextualHelp("en")), nil
+	}
+	
+	if err := s.checkBudget(projectID); err != nil {
+		return nil, err
+	}
+	
+	backend, err := s.backendFor(projectID)
+	if err != nil {
+		return nil, err
 	}
 	
-	// Generate enhanced Claude response
-	return generateEnhancedClaudeResponse(command, context), nil
+	// Generate enhanced Claude response, resuming the project's session
+	response, claudeResult, err := generateEnhancedClaudeResponse(ctx, backend, command, sessionContext, s.claudeSessionID(projectID))
+	usage := newUsageRecord(claudeResult)
+	s.recordUsage(projectID, usage)
+	if err != nil {
+		return nil, err
+	}
+	if claudeResult != nil {
+		s.setClaudeSessionID(projectID, claudeResult.SessionID)
+	}
+	result := textCommandResult(response)
+	result.Usage = usage
+	return result, nil
 }
 
 func (h *ClaudeHandler) GetDescription() string {
 	return "Natural language conversation with Claude AI assistant"
 }
 
-// File Operation Handler
+// File Operation Handler. Paths are confined to /workspace and content is
+// streamed to the container over stdin, never interpolated into a shell.
 type FileHandler struct{}
 
-func (h *FileHandler) Execute(s *Server, projectID, command, context string) (string, error) {
-	// Remove "file:" prefix
-	fileCommand := strings.TrimSpace(strings.TrimPrefix(command, "file:"))
-	parts := strings.Fields(fileCommand)
-	
-	if len(parts) < 2 {
-		return "❌ File command format: file:[read|write|create|list] <filename> [content]", fmt.Errorf("invalid file command")
+func (h *FileHandler) Execute(s *Server, projectID, command, context string) (*CommandResult, error) {
+	operation, rest := splitCommandField(command)
+	operation = strings.ToLower(operation)
+	if operation == "" {
+		return nil, fmt.Errorf("invalid file command, expected file:<operation> <path> [args]")
 	}
 	
-	operation := parts[0]
-	filename := parts[1]
+	dm := s.dockerManager
 	
 	switch operation {
-	case "read":
-		return s.dockerManager.ExecuteCommand(projectID, fmt.Sprintf("cat %s", filename))
+	case "read", "cat":
+		// file:read <path> [offset] [length]
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("missing path, expected file:read <path> [offset] [length]")
+		}
+		var offset, length int64
+		var err error
+		if len(fields) > 1 {
+			if offset, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid offset: %s", fields[1])
+			}
+		}
+		if len(fields) > 2 {
+			if length, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid length: %s", fields[2])
+			}
+		}
+		content, err := dm.ReadFile(projectID, fields[0], offset, length)
+		if err != nil {
+			return nil, err
+		}
+		return &CommandResult{
+			Stdout:      string(content.Data),
+			PayloadType: "file_content",
+			Payload:     content,
+		}, nil
+		
 	case "list", "ls":
-		return s.dockerManager.ExecuteCommand(projectID, "ls -la")
-	case "create", "write":
-		if len(parts) < 3 {
-			return "❌ Write command needs content: file:write <filename> <content>", fmt.Errorf("missing content")
+		// file:list [path]
+		dir := strings.TrimSpace(rest)
+		if dir == "" {
+			dir = "."
+		}
+		entries, err := dm.ListDir(projectID, dir)
+		if err != nil {
+			return nil, err
 		}
-		content := strings.Join(parts[2:], " ")
-		return s.dockerManager.ExecuteCommand(projectID, fmt.Sprintf("echo '%s' > %s", content, filename))
+		var listing strings.Builder
+		for _, entry := range entries {
+			name := entry.Name
+			if entry.Type == "dir" {
+				name += "/"
+			}
+			listing.WriteString(fmt.Sprintf("%-8s %10d  %s  %s\n", entry.Type, entry.Size, entry.ModTime.Format("2006-01-02 15:04"), name))
+		}
+		return &CommandResult{
+			Stdout:      listing.String(),
+			PayloadType: "file_list",
+			Payload:     entries,
+		}, nil
+		
+	case "create", "write", "append":
+		// file:write <path> <content...> - content keeps its newlines
+		filePath, content := splitCommandField(rest)
+		if filePath == "" {
+			return nil, fmt.Errorf("missing path, expected file:%s <path> <content>", operation)
+		}
+		var target string
+		var err error
+		if operation == "append" {
+			target, err = dm.AppendFile(projectID, filePath, []byte(content))
+		} else {
+			target, err = dm.WriteFile(projectID, filePath, []byte(content))
+		}
+		if err != nil {
+			return nil, err
+		}
+		return textCommandResult(fmt.Sprintf("%s: %d bytes to %s\n", operation, len(content), target)), nil
+		
+	case "delete", "rm":
+		// file:delete [-r] <path>
+		fields := strings.Fields(rest)
+		recursive := len(fields) > 0 && (fields[0] == "-r" || fields[0] == "-rf")
+		if recursive {
+			fields = fields[1:]
+		}
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("expected file:delete [-r] <path>")
+		}
+		target, err := dm.DeletePath(projectID, fields[0], recursive)
+		if err != nil {
+			return nil, err
+		}
+		return textCommandResult(fmt.Sprintf("deleted %s\n", target)), nil
+		
+	case "move", "mv", "rename":
+		// file:move <from> <to>
+		fields := strings.Fields(rest)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("expected file:move <from> <to>")
+		}
+		from, to, err := dm.MovePath(projectID, fields[0], fields[1])
+		if err != nil {
+			return nil, err
+		}
+		return textCommandResult(fmt.Sprintf("moved %s -> %s\n", from, to)), nil
+		
+	case "mkdir":
+		// file:mkdir <path>
+		fields := strings.Fields(rest)
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("expected file:mkdir <path>")
+		}
+		target, err := dm.MakeDir(projectID, fields[0])
+		if err != nil {
+			return nil, err
+		}
+		return textCommandResult(fmt.Sprintf("created %s\n", target)), nil
+		
+	case "patch":
+		// file:patch [--check] <unified diff>
+		diff := rest
+		checkOnly := false
+		if flag, remainder := splitCommandField(rest); flag == "--check" {
+			diff, checkOnly = remainder, true
+		}
+		if strings.TrimSpace(diff) == "" {
+			return nil, fmt.Errorf("missing diff, expected file:patch [--check] <unified diff>")
+		}
+		patch, err := dm.ApplyPatch(projectID, diff, checkOnly)
+		if err != nil {
+			return nil, err
+		}
+		return patchCommandResult(patch), nil
+		
 	default:
-		return fmt.Sprintf("❌ Unknown file operation: %s", operation), fmt.Errorf("unknown operation")
+		return nil, fmt.Errorf("unknown file operation: %s", operation)
 	}
 }
 
+// patchCommandResult summarises a PatchResult, failing when any hunk was rejected
+func patchCommandResult(patch *PatchResult) *CommandResult {
+	result := &CommandResult{PayloadType: "patch_result", Payload: patch}
+	
+	var summary strings.Builder
+	for _, f := range patch.Files {
+		if f.OK {
+			summary.WriteString(fmt.Sprintf("%s: %d hunk(s) ok\n", f.Path, f.Hunks))
+			continue
+		}
+		if f.Error != "" {
+			summary.WriteString(fmt.Sprintf("%s: %s\n", f.Path, f.Error))
+		}
+		for _, hunk := range f.FailedHunks {
+			summary.WriteString(fmt.Sprintf("%s: hunk #%d (line %d) failed: %s\n", f.Path, hunk.Index, hunk.OldStart, hunk.Reason))
+		}
+	}
+	
+	switch {
+	case patch.Applied:
+		result.Stdout = "patch applied\n" + summary.String()
+	case patch.Error == "":
+		result.Stdout = "patch applies cleanly\n" + summary.String()
+	default:
+		result.ExitCode = 1
+		result.Stderr = fmt.Sprintf("patch not applied: %s\n%s", patch.Error, summary.String())
+	}
+	return result
+}
+
 func (h *FileHandler) GetDescription() string {
-	return "File operations: file:[read|write|create|list] <filename> [content]"
+	return "File operations inside /workspace: file:[read|write|append|delete|move|mkdir|list|patch] <path> [args]"
+}
+
+func (h *FileHandler) GetSpec() CommandSpec {
+	return CommandSpec{
+		Usage: "[read|write|append|delete|move|mkdir|list|patch] <path> [args]",
+		Help:  "read <path> [offset] [length] · write|append <path> <content> · delete [-r] <path> · move <from> <to> · mkdir <path> · list [path] · patch [--check] <unified diff>",
+		Examples: []string{
+			"file:read README.md",
+			"file:write test.py print('Hi')",
+			"file:move notes.txt docs/notes.txt",
+		},
+	}
+}
+
+// splitCommandField returns the first whitespace-separated field of s and the
+// remainder with a single separator removed, so multi-line content survives.
+func splitCommandField(s string) (string, string) {
+	s = strings.TrimLeft(s, " \t\r\n")
+	end := strings.IndexAny(s, " \t\r\n")
+	if end < 0 {
+		return s, ""
+	}
+	rest := s[end:]
+	if strings.HasPrefix(rest, "\r\n") {
+		return s[:end], rest[2:]
+	}
+	return s[:end], rest[1:]
 }
 
-// Git Operation Handler  
+// Git Operation Handler. status, log, diff, branch, stage and unstage return
+// parsed payloads; any other subcommand runs as plain git.
 type GitHandler struct{}
 
-func (h *GitHandler) Execute(s *Server, projectID, command, context string) (string, error) {
-	// Remove "git:" prefix
-	gitCommand := strings.TrimSpace(strings.TrimPrefix(command, "git:"))
-	return s.dockerManager.ExecuteCommand(projectID, fmt.Sprintf("git %s", gitCommand))
+func (h *GitHandler) Execute(s *Server, projectID, command, context string) (*CommandResult, error) {
+	gitCommand := strings.TrimSpace(command)
+	subcommand, rest := splitCommandField(gitCommand)
+	args := strings.Fields(rest)
+	dm := s.dockerManager
+	
+	switch strings.ToLower(subcommand) {
+	case "status":
+		// git:status - extra flags fall back to plain git
+		if len(args) > 0 {
+			break
+		}
+		status, err := dm.GitStatus(projectID)
+		if err != nil {
+			return nil, err
+		}
+		return gitStatusCommandResult(status), nil
+		
+	case "log":
+		// git:log [-n N] [path...]
+		limit := 20
+		if len(args) >= 2 && args[0] == "-n" {
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid log limit: %s", args[1])
+			}
+			limit, args = n, args[2:]
+		}
+		if hasGitFlag(args) {
+			break
+		}
+		commits, err := dm.GitLog(projectID, limit, args)
+		if err != nil {
+			return nil, err
+		}
+		var out strings.Builder
+		for _, c := range commits {
+			out.WriteString(fmt.Sprintf("%s %s %s (%s)\n", c.ShortHash, c.Date.Format("2006-01-02"), c.Subject, c.AuthorName))
+		}
+		return &CommandResult{Stdout: out.String(), PayloadType: "git_log", Payload: commits}, nil
+		
+	case "diff":
+		// git:diff [--staged|--cached] [path...]
+		staged := len(args) > 0 && (args[0] == "--staged" || args[0] == "--cached")
+		if staged {
+			args = args[1:]
+		}
+		if hasGitFlag(args) {
+			break
+		}
+		diff, err := dm.GitDiff(projectID, staged, args)
+		if err != nil {
+			return nil, err
+		}
+		var out strings.Builder
+		for _, f := range diff.Files {
+			added, removed := 0, 0
+			for _, hunk := range f.Hunks {
+				for _, line := range hunk.Lines {
+					switch line[0] {
+					case '+':
+						added++
+					case '-':
+						removed++
+					}
+				}
+			}
+			out.WriteString(fmt.Sprintf("%-8s +%d -%d  %s\n", f.Status, added, removed, f.Path()))
+		}
+		return &CommandResult{Stdout: out.String(), PayloadType: "git_diff", Payload: diff}, nil
+		
+	case "branch", "branches":
+		// git:branch - anything more (create, delete) falls back to plain git
+		if len(args) > 0 {
+			break
+		}
+		branches, err := dm.GitBranches(projectID)
+		if err != nil {
+			return nil, err
+		}
+		var out strings.Builder
+		for _, b := range branches.Local {
+			marker := " "
+			if b.Current {
+				marker = "*"
+			}
+			out.WriteString(fmt.Sprintf("%s %s %s\n", marker, b.Name, b.Commit))
+		}
+		for _, b := range branches.Remote {
+			out.WriteString(fmt.Sprintf("  %s %s\n", b.Name, b.Commit))
+		}
+		return &CommandResult{Stdout: out.String(), PayloadType: "git_branches", Payload: branches}, nil
+		
+	case "stage", "unstage":
+		// git:stage <path...> / git:unstage <path...> - replies with the new status
+		var err error
+		if strings.ToLower(subcommand) == "stage" {
+			err = dm.GitStage(projectID, args)
+		} else {
+			err = dm.GitUnstage(projectID, args)
+		}
+		if err != nil {
+			return nil, err
+		}
+		status, err := dm.GitStatus(projectID)
+		if err != nil {
+			return nil, err
+		}
+		return gitStatusCommandResult(status), nil
+	}
+	
+	return s.execCommand(projectID, fmt.Sprintf("git %s", gitCommand))
+}
+
+// hasGitFlag reports whether args contain an option the structured path doesn't support
+func hasGitFlag(args []string) bool {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			return true
+		}
+	}
+	return false
+}
+
+// gitStatusCommandResult renders a GitStatus in short-status form
+func gitStatusCommandResult(status *GitStatus) *CommandResult {
+	var out strings.Builder
+	out.WriteString(fmt.Sprintf("## %s", status.Branch.Head))
+	if status.Branch.Upstream != "" {
+		out.WriteString(fmt.Sprintf("...%s [ahead %d, behind %d]", status.Branch.Upstream, status.Branch.Ahead, status.Branch.Behind))
+	}
+	out.WriteString("\n")
+	for _, f := range status.Files {
+		code := strings.ReplaceAll(f.Index+f.Worktree, ".", " ")
+		if f.OrigPath != "" {
+			out.WriteString(fmt.Sprintf("%s %s -> %s\n", code, f.OrigPath, f.Path))
+			continue
+		}
+		out.WriteString(fmt.Sprintf("%s %s\n", code, f.Path))
+	}
+	return &CommandResult{Stdout: out.String(), PayloadType: "git_status", Payload: status}
 }
 
 func (h *GitHandler) GetDescription() string {
-	return "Git operations: git:<git-command>"
+	return "Git operations: git:[status|log|diff|branch|stage|unstage] or any git command"
+}
+
+func (h *GitHandler) GetSpec() CommandSpec {
+	return CommandSpec{
+		Usage: "[status|log|diff|branch|stage|unstage] [args] | <git-command>",
+		Help:  "status · log [-n N] [path...] · diff [--staged] [path...] · branch · stage <path...> · unstage <path...> · anything else runs as plain git",
+		Examples: []string{"git: status", "git: diff --staged", "git: stage main.py", "git: log --oneline -5"},
+	}
 }
 
 // Docker Operation Handler
 type DockerInfoHandler struct{}
 
-func (h *DockerInfoHandler) Execute(s *Server, projectID, command, context string) (string, error) {
-	// Remove "info:" prefix
-	infoCommand := strings.TrimSpace(strings.TrimPrefix(command, "info:"))
+func (h *DockerInfoHandler) Execute(s *Server, projectID, command, context string) (*CommandResult, error) {
+	infoCommand := strings.TrimSpace(command)
 	
 	switch infoCommand {
 	case "status":
-		return s.dockerManager.ExecuteCommand(projectID, "ps aux | head -10")
+		return s.execCommand(projectID, "ps aux | head -10")
 	case "disk":
-		return s.dockerManager.ExecuteCommand(projectID, "df -h")
+		return s.execCommand(projectID, "df -h")
 	case "memory":
-		return s.dockerManager.ExecuteCommand(projectID, "free -h")
+		return s.execCommand(projectID, "free -h")
 	case "env":
-		return s.dockerManager.ExecuteCommand(projectID, "env")
+		return s.execCommand(projectID, "env")
 	default:
-		return s.dockerManager.ExecuteCommand(projectID, "uname -a && whoami && pwd")
+		return s.execCommand(projectID, "uname -a && whoami && pwd")
 	}
 }
 
@@ -118,19 +573,34 @@ func (h *DockerInfoHandler) GetDescription() string {
 	return "Container info: info:[status|disk|memory|env]"
 }
 
+func (h *DockerInfoHandler) GetSpec() CommandSpec {
+	return CommandSpec{
+		Usage:    "[status|disk|memory|env]",
+		Examples: []string{"info:disk"},
+	}
+}
+
 // Help Handler
 type HelpHandler struct{}
 
-func (h *HelpHandler) Execute(s *Server, projectID, command, context string) (string, error) {
+func (h *HelpHandler) Execute(s *Server, projectID, command, context string) (*CommandResult, error) {
 	var help strings.Builder
 	
 	help.WriteString("🚀 RemoteClaude Command System - Enhanced UX\n")
 	help.WriteString("==========================================\n\n")
 	help.WriteString("📋 Available Command Prefixes:\n\n")
 	
-	router := NewCommandRouter()
-	for prefix, handler := range router.prefixMap {
-		help.WriteString(fmt.Sprintf("🔸 %s - %s\n", prefix, handler.GetDescription()))
+	for _, info := range s.commandRouter.Commands() {
+		help.WriteString(fmt.Sprintf("🔸 %s - %s\n", info.Prefix, info.Description))
+		if info.Usage != "" {
+			help.WriteString(fmt.Sprintf("     usage: %s%s\n", info.Prefix, info.Usage))
+		}
+		if len(info.Aliases) > 0 {
+			help.WriteString(fmt.Sprintf("     aliases: %s\n", strings.Join(info.Aliases, ", ")))
+		}
+		if info.Help != "" {
+			help.WriteString(fmt.Sprintf("     %s\n", info.Help))
+		}
 	}
 	
 	help.WriteString("\n📝 Examples:\n")
@@ -150,83 +620,237 @@ func (h *HelpHandler) Execute(s *Server, projectID, command, context string) (st
 	help.WriteString("• Commands are case-insensitive\n")
 	help.WriteString("• Japanese and English supported\n")
 	
-	return help.String(), nil
+	return &CommandResult{
+		Stdout:      help.String(),
+		PayloadType: "command_list",
+		Payload:     s.commandRouter.Commands(),
+	}, nil
 }
 
 func (h *HelpHandler) GetDescription() string {
 	return "Show command help and usage examples"
 }
 
-// Initialize Command Router
+func (h *HelpHandler) GetSpec() CommandSpec {
+	return CommandSpec{
+		Aliases: []string{"help"}, // Allow both help: and help
+	}
+}
+
+// Initialize Command Router with the built-in handlers
 func NewCommandRouter() *CommandRouter {
 	router := &CommandRouter{
-		prefixMap: make(map[string]CommandHandler),
+		handlers: make(map[string]*commandRoute),
 	}
 	
 	// Register handlers
-	router.prefixMap["code:"] = &CodeExecutionHandler{}
-	router.prefixMap["file:"] = &FileHandler{}  
-	router.prefixMap["git:"] = &GitHandler{}
-	router.prefixMap["info:"] = &DockerInfoHandler{}
-	router.prefixMap["help:"] = &HelpHandler{}
-	router.prefixMap["help"] = &HelpHandler{} // Allow both help: and help
+	router.mustRegister("code:", &CodeExecutionHandler{})
+	router.mustRegister("file:", &FileHandler{})
+	router.mustRegister("git:", &GitHandler{})
+	router.mustRegister("info:", &DockerInfoHandler{})
+	router.mustRegister("help:", &HelpHandler{})
 	
 	return router
 }
 
-// Simplified 3-pattern command processing
-func (s *Server) processEnhancedCommand(projectID, command, context string) (string, error) {
+// Register adds a handler under prefix, plus any aliases it declares via
+// CommandSpecProvider. Prefixes are case-insensitive and must be unique.
+func (cr *CommandRouter) Register(prefix string, handler CommandHandler) error {
+	prefix = normalizePrefix(prefix)
+	if prefix == "" {
+		return fmt.Errorf("command prefix must not be empty")
+	}
+	if handler == nil {
+		return fmt.Errorf("nil handler for prefix %s", prefix)
+	}
+	
+	prefixes := []string{prefix}
+	if provider, ok := handler.(CommandSpecProvider); ok {
+		for _, alias := range provider.GetSpec().Aliases {
+			if alias = normalizePrefix(alias); alias != "" && alias != prefix {
+				prefixes = append(prefixes, alias)
+			}
+		}
+	}
+	
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	
+	for _, p := range prefixes {
+		if _, exists := cr.handlers[p]; exists {
+			return fmt.Errorf("command prefix already registered: %s", p)
+		}
+	}
+	for _, p := range prefixes {
+		cr.handlers[p] = &commandRoute{prefix: p, primary: prefix, handler: handler}
+		cr.prefixes = append(cr.prefixes, p)
+	}
+	
+	// Longest prefix first, ties broken alphabetically
+	sort.Slice(cr.prefixes, func(i, j int) bool {
+		if len(cr.prefixes[i]) != len(cr.prefixes[j]) {
+			return len(cr.prefixes[i]) > len(cr.prefixes[j])
+		}
+		return cr.prefixes[i] < cr.prefixes[j]
+	})
+	
+	log.Printf("🧭 Registered command prefix %v -> %T", prefixes, handler)
+	return nil
+}
+
+// Unregister removes a handler and all of its aliases
+func (cr *CommandRouter) Unregister(prefix string) bool {
+	prefix = normalizePrefix(prefix)
+	
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	
+	route, exists := cr.handlers[prefix]
+	if !exists {
+		return false
+	}
+	
+	remaining := cr.prefixes[:0]
+	for _, p := range cr.prefixes {
+		if cr.handlers[p].primary == route.primary {
+			delete(cr.handlers, p)
+			continue
+		}
+		remaining = append(remaining, p)
+	}
+	cr.prefixes = remaining
+	return true
+}
+
+// Match returns the handler with the longest prefix matching command, along
+// with the matched prefix and the remaining arguments.
+func (cr *CommandRouter) Match(command string) (handler CommandHandler, prefix, args string, ok bool) {
 	command = strings.TrimSpace(command)
+	commandLower := strings.ToLower(command)
+	
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	
+	for _, p := range cr.prefixes {
+		if strings.HasPrefix(commandLower, p) {
+			route := cr.handlers[p]
+			return route.handler, route.primary, strings.TrimSpace(command[len(p):]), true
+		}
+	}
+	return nil, "", command, false
+}
+
+// Commands lists the registered handlers in prefix order
+func (cr *CommandRouter) Commands() []CommandInfo {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	
+	var infos []CommandInfo
+	for p, route := range cr.handlers {
+		if p != route.primary {
+			continue
+		}
+		info := CommandInfo{
+			Prefix:      route.primary,
+			Description: route.handler.GetDescription(),
+		}
+		if provider, ok := route.handler.(CommandSpecProvider); ok {
+			spec := provider.GetSpec()
+			info.Usage = spec.Usage
+			info.Help = spec.Help
+			info.Examples = spec.Examples
+		}
+		for alias, aliasRoute := range cr.handlers {
+			if alias != p && aliasRoute.primary == p {
+				info.Aliases = append(info.Aliases, alias)
+			}
+		}
+		sort.Strings(info.Aliases)
+		infos = append(infos, info)
+	}
+	
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Prefix < infos[j].Prefix })
+	return infos
+}
+
+func (cr *CommandRouter) mustRegister(prefix string, handler CommandHandler) {
+	if err := cr.Register(prefix, handler); err != nil {
+		panic(err)
+	}
+}
+
+func normalizePrefix(prefix string) string {
+	return strings.ToLower(strings.TrimSpace(prefix))
+}
+
+// Simplified 3-pattern command processing. The returned result is never nil;
+// when err is set it carries the error text in Stderr. The outcome is
+// recorded in the audit log under ac.
+func (s *Server) processEnhancedCommand(ctx context.Context, ac AuditContext, projectID, command, sessionContext string) (*CommandResult, error) {
+	command = strings.TrimSpace(command)
+	start := time.Now()
 	
 	// Detect command type using simple 3-pattern detection
-	commandType := detectCommandType(command)
+	commandType := s.commandRouter.detectCommandType(command)
+	
+	var handler CommandHandler
+	var handlerName, args string
 	
 	switch commandType {
 	case "prefixed":
-		// Handle prefixed commands (code:, file:, git:, info:, help:)
-		router := NewCommandRouter()
-		commandLower := strings.ToLower(command)
-		for prefix, handler := range router.prefixMap {
-			if strings.HasPrefix(commandLower, strings.ToLower(prefix)) {
-				result, err := handler.Execute(s, projectID, command, context)
-				if err != nil {
-					return fmt.Sprintf("❌ %s command failed: %s", prefix, err.Error()), err
-				}
-				return result, nil
-			}
+		// Handle prefixed commands via the longest matching registered prefix
+		if h, prefix, rest, ok := s.commandRouter.Match(command); ok {
+			handler, handlerName, args = h, prefix, rest
+			break
 		}
 		fallthrough // If no prefix handler found, treat as docker
 		
 	case "docker":
 		// Handle Quick Commands & Docker commands directly
-		return s.dockerManager.ExecuteCommand(projectID, command)
-		
-	case "claude":
-		// Handle Claude AI conversation
-		claudeHandler := &ClaudeHandler{}
-		return claudeHandler.Execute(s, projectID, command, context)
+		handler, handlerName, args = &CodeExecutionHandler{}, "shell", command
 		
 	default:
-		// Default to Claude AI for unknown types
-		claudeHandler := &ClaudeHandler{}
-		return claudeHandler.Execute(s, projectID, command, context)
+		// Handle Claude AI conversation (also the default for unknown types)
+		handler, handlerName, args = &ClaudeHandler{}, "claude", command
+	}
+	
+	var result *CommandResult
+	var err error
+	if ch, ok := handler.(ContextCommandHandler); ok {
+		result, err = ch.ExecuteContext(ctx, s, projectID, args, sessionContext)
+	} else {
+		result, err = handler.Execute(s, projectID, args, sessionContext)
+	}
+	if err != nil && result == nil {
+		result = errorCommandResult(handlerName, fmt.Errorf("%s command failed: %v", handlerName, err))
 	}
+	if result == nil {
+		result = &CommandResult{}
+	}
+	result.Handler = handlerName
+	if result.DurationMs == 0 {
+		result.DurationMs = time.Since(start).Milliseconds()
+	}
+	
+	if handlerName == "claude" {
+		ac.Source = AuditSourceClaude
+	}
+	s.auditLog.Record(ac, projectID, command, exitCodeOf(result.ExitCode), time.Duration(result.DurationMs)*time.Millisecond, err)
+	
+	return result, err
 }
 
 // Simple 3-pattern command detection
-func detectCommandType(command string) string {
+func (cr *CommandRouter) detectCommandType(command string) string {
 	command = strings.TrimSpace(strings.ToLower(command))
 	
 	if len(command) == 0 {
 		return "claude"
 	}
 	
-	// 1. Prefixed commands (existing system)
-	prefixes := []string{"code:", "file:", "git:", "info:", "help:", "help"}
-	for _, prefix := range prefixes {
-		if strings.HasPrefix(command, prefix) {
-			return "prefixed"
-		}
+	// 1. Prefixed commands (registered handlers)
+	if _, _, _, ok := cr.Match(command); ok {
+		return "prefixed"
 	}
 	
 	// 2. Quick Commands & Docker commands (common shell commands)
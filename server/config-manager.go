@@ -12,6 +12,9 @@ import (
 	"time"
 )
 
+// DefaultUserID is the user every client acts as until clients identify themselves
+const DefaultUserID = "default"
+
 // ConfigManager handles user and container configuration management
 type ConfigManager struct {
 	configDir string
@@ -37,6 +40,16 @@ type UserConfiguration struct {
 
 	// Quick Commands
 	QuickCommands []QuickCommand `json:"quick_commands"`
+
+	// Claude usage limits
+	Budget UsageBudget `json:"budget"`
+
+	// Tool permission rules for every project
+	PermissionRules []PermissionRule `json:"permission_rules,omitempty"`
+
+	// Days removed projects stay in the trash; 0 uses the default and a
+	// negative value keeps them until purged by hand
+	TrashRetentionDays int `json:"trash_retention_days,omitempty"`
 }
 
 // GitConfig contains Git-related settings
@@ -119,6 +132,31 @@ type ContainerConfiguration struct {
 
 	// Container runtime settings
 	Runtime RuntimeConfig `json:"runtime"`
+
+	// Where Claude runs for this project
+	Agent AgentConfig `json:"agent"`
+
+	// Tool permission rules for this project
+	PermissionRules []PermissionRule `json:"permission_rules,omitempty"`
+}
+
+// Agent runtimes
+const (
+	AgentRuntimeContainer = "container" // docker exec in the project container (default)
+	AgentRuntimeHost      = "host"      // on the server host, outside any container
+)
+
+// AgentConfig selects the AI backend for a project and where the Claude CLI runs
+type AgentConfig struct {
+	Backend string         `json:"backend,omitempty"`  // "cli" or "scripted"; empty means cli
+	Runtime string         `json:"runtime,omitempty"`  // "container" or "host"; empty means container
+	CLIPath string         `json:"cli_path,omitempty"` // defaults to "claude"
+	WorkDir string         `json:"work_dir,omitempty"` // host runtime only; defaults to the server's directory
+	Script  []ScriptedTurn `json:"script,omitempty"`   // replies of the scripted backend
+
+	// "prompt" asks the user for each Edit, Write and Bash call; "skip" lets
+	// Claude run every tool unasked (container runtime only). Empty means prompt.
+	Permissions string `json:"permissions,omitempty"`
 }
 
 // RuntimeConfig contains container runtime settings
@@ -443,6 +481,10 @@ func (cm *ConfigManager) getDefaultContainerConfig(projectID string) *ContainerC
 			Aliases:          make(map[string]string),
 			StartupCommands:  []string{},
 		},
+		Agent: AgentConfig{
+			Backend: AIBackendCLI,
+			Runtime: AgentRuntimeContainer,
+		},
 	}
 }
 
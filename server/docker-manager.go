@@ -1,19 +1,32 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"os/exec"
 	"strings"
 	"time"
 )
 
-// DockerManager handles Docker container operations
+// dockerAPITimeout bounds a single Docker Engine API call
+const dockerAPITimeout = 30 * time.Second
+
+// DockerManager handles Docker container operations through the Docker
+// Engine API
 type DockerManager struct {
 	projectsPath string
+	engine       ContainerEngine
+	audit        *AuditLog // records ExecuteCommand runs; may be nil
+	registry     *ProjectRegistry
+	templates    *TemplateStore
+	snapshots    *SnapshotStore
+
+	// onColdStart is told when a command has to start a stopped container
+	// first, at stage "starting" and again at "ready"; may be nil
+	onColdStart func(projectID, stage string, hibernated bool)
 }
 
 // Project represents a Docker-based development project
@@ -21,13 +34,25 @@ type Project struct {
 	ID          string            `json:"id"`
 	Name        string            `json:"name"`
 	Type        string            `json:"type"`
+	Template    string            `json:"template,omitempty"`
+	Creator     string            `json:"creator,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
 	Status      string            `json:"status"`
 	ContainerID string            `json:"container_id"`
 	Image       string            `json:"image"`
 	CreatedAt   time.Time         `json:"created_at"`
-	LastAccess  time.Time         `json:"last_access"`
+	LastAccess  time.Time         `json:"last_access"` // last command, or creation
 	Config      map[string]string `json:"config"`
 	Resources   ResourceLimits    `json:"resources"`
+
+	// Kept in the ProjectRegistry rather than on the container
+	LastCommandAt time.Time     `json:"last_command_at"`
+	Tags          []string      `json:"tags,omitempty"`
+	Notes         string        `json:"notes,omitempty"`
+	Error         string        `json:"error,omitempty"` // why creation failed
+	TrashedAt     time.Time     `json:"trashed_at"`      // zero unless in the trash
+	HibernatedAt  time.Time     `json:"hibernated_at"`   // zero unless stopped for being idle
+	IdleTimeout   time.Duration `json:"idle_timeout"`    // negative never hibernates
 }
 
 // ResourceLimits defines container resource constraints
@@ -42,19 +67,69 @@ type ProjectCreateRequest struct {
 	Type      string            `json:"type"`
 	Config    map[string]string `json:"config"`
 	Resources *ResourceLimits   `json:"resources,omitempty"`
+	Creator   string            `json:"creator,omitempty"` // user ID of whoever asked for it
+	Source    *ProjectSource    `json:"source,omitempty"`  // nil starts from the template
+
+	// Progress, if set, is told about each creation step
+	Progress func(stage, message string) `json:"-"`
 }
 
-// NewDockerManager creates a new Docker manager instance
+// NewDockerManager creates a new Docker manager instance talking to the
+// local Docker daemon
 func NewDockerManager(projectsPath string) *DockerManager {
+	return NewDockerManagerWithEngine(projectsPath, NewDockerEngineClient())
+}
+
+// NewDockerManagerWithEngine creates a Docker manager on top of engine, e.g.
+// a FakeEngine
+func NewDockerManagerWithEngine(projectsPath string, engine ContainerEngine) *DockerManager {
 	return &DockerManager{
 		projectsPath: projectsPath,
+		engine:       engine,
+		registry:     NewProjectRegistry(),
+		templates:    NewTemplateStore(),
+		snapshots:    NewSnapshotStore(),
+	}
+}
+
+// dockerContext returns the context for one Engine API call
+func dockerContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), dockerAPITimeout)
+}
+
+// containerName is the name of a project's container
+func containerName(projectID string) string {
+	return fmt.Sprintf("remoteclaude-%s", projectID)
+}
+
+// shortContainerID returns the 12 character form of a container ID
+func shortContainerID(containerID string) string {
+	if len(containerID) > 12 {
+		return containerID[:12]
 	}
+	return containerID
+}
+
+// volumeName is the name of a project's workspace volume
+func volumeName(projectID string) string {
+	return fmt.Sprintf("remoteclaude-project-%s", projectID)
 }
 
 // CreateProject creates a new isolated development environment
 func (dm *DockerManager) CreateProject(req ProjectCreateRequest) (*Project, error) {
 	log.Printf("🐳 Creating new Docker project: %s (%s)", req.Name, req.Type)
 
+	// Resolve the project type against the templates
+	tmpl, err := dm.templates.Resolve(req.Type)
+	if err != nil {
+		return nil, err
+	}
+	if req.Source != nil {
+		if err := req.Source.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	// Generate unique project ID
 	projectID := generateProjectID(req.Name)
 
@@ -63,76 +138,168 @@ func (dm *DockerManager) CreateProject(req ProjectCreateRequest) (*Project, erro
 		Memory: "2g",
 		CPUs:   "1.0",
 	}
+	if tmpl.Resources != nil {
+		resources = *tmpl.Resources
+	}
 	if req.Resources != nil {
 		resources = *req.Resources
 	}
 
+	// Template env first, so the request can override it
+	config := make(map[string]string)
+	for key, value := range tmpl.Env {
+		config[key] = value
+	}
+	for key, value := range req.Config {
+		config[key] = value
+	}
+
 	// Create project configuration
 	project := &Project{
 		ID:         projectID,
 		Name:       req.Name,
 		Type:       req.Type,
+		Template:   tmpl.Name,
+		Creator:    req.Creator,
 		Status:     "creating",
-		Image:      "remoteclaude-ubuntu-claude:latest",
+		Image:      tmpl.Image,
 		CreatedAt:  time.Now(),
 		LastAccess: time.Now(),
-		Config:     req.Config,
+		Config:     config,
 		Resources:  resources,
 	}
 
+	progress := req.Progress
+	if progress == nil {
+		progress = func(stage, message string) {}
+	}
+
 	// Create Docker container
+	progress("container", fmt.Sprintf("Creating container from %s", project.Image))
 	containerID, err := dm.createContainer(project)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create container: %v", err)
+		return nil, dm.failProject(project, fmt.Errorf("failed to create container: %w", err))
 	}
 
 	project.ContainerID = containerID
 	project.Status = "ready"
 
-	// Initialize project workspace
-	err = dm.initializeProject(project)
-	if err != nil {
-		// Clean up container on failure
-		dm.removeContainer(containerID)
-		return nil, fmt.Errorf("failed to initialize project: %v", err)
+	if req.Source != nil {
+		// Populate the workspace from the source; the template only
+		// contributes image, env, resources and quick commands
+		progress("source", fmt.Sprintf("Populating workspace from %s", req.Source))
+		if err := dm.populateWorkspace(context.Background(), project, req.Source, progress); err != nil {
+			dm.discardContainer(project)
+			return nil, dm.failProject(project, err)
+		}
+	} else {
+		// Initialize project workspace
+		progress("initializing", "Initializing workspace")
+		err = dm.initializeProject(project)
+		if err != nil {
+			// Clean up container on failure
+			dm.discardContainer(project)
+			return nil, dm.failProject(project, fmt.Errorf("failed to initialize project: %v", err))
+		}
+
+		// Scaffold the workspace from the template
+		progress("template", fmt.Sprintf("Applying template %s", tmpl.Name))
+		if err := dm.applyTemplate(project, tmpl); err != nil {
+			dm.discardContainer(project)
+			return nil, dm.failProject(project, fmt.Errorf("failed to apply template %s: %v", tmpl.Name, err))
+		}
 	}
 
+	dm.registry.Sync(project)
+
 	log.Printf("✅ Project created successfully: %s (Container: %s)", projectID, containerID[:12])
 	return project, nil
 }
 
+// failProject records a project whose creation failed, so it shows up as
+// failed with its error until removed, and returns err
+func (dm *DockerManager) failProject(project *Project, err error) error {
+	log.Printf("❌ Project %s failed: %v", project.ID, err)
+	project.Status = ProjectStatusFailed
+	project.Error = err.Error()
+	project.ContainerID = ""
+	dm.registry.Sync(project)
+	return &ProjectCreateError{ProjectID: project.ID, Err: err}
+}
+
+// discardContainer removes the container and volume of a failed project
+func (dm *DockerManager) discardContainer(project *Project) {
+	if err := dm.removeContainer(project.ContainerID); err != nil {
+		log.Printf("⚠️ Failed to clean up container of %s: %v", project.ID, err)
+	}
+	ctx, cancel := dockerContext()
+	defer cancel()
+	if err := dm.engine.VolumeRemove(ctx, volumeName(project.ID)); err != nil && !errors.Is(err, ErrContainerNotFound) {
+		log.Printf("⚠️ Failed to clean up volume of %s: %v", project.ID, err)
+	}
+}
+
+// ProjectCreateError is a failed CreateProject for a project that was
+// already registered
+type ProjectCreateError struct {
+	ProjectID string
+	Err       error
+}
+
+func (e *ProjectCreateError) Error() string { return e.Err.Error() }
+
+func (e *ProjectCreateError) Unwrap() error { return e.Err }
+
 // createContainer creates and starts a Docker container for the project
 func (dm *DockerManager) createContainer(project *Project) (string, error) {
-	args := []string{
-		"run", "-d",
-		"--name", fmt.Sprintf("remoteclaude-%s", project.ID),
-		"--memory", project.Resources.Memory,
-		"--cpus", project.Resources.CPUs,
-		"--security-opt", "no-new-privileges:true",
-		"--user", "1000:1000",
-		"--network", "remoteclaude-network",
-		"--env", fmt.Sprintf("PROJECT_ID=%s", project.ID),
-		"--env", fmt.Sprintf("PROJECT_NAME=%s", project.Name),
-		"--env", fmt.Sprintf("PROJECT_TYPE=%s", project.Type),
-		"--volume", fmt.Sprintf("remoteclaude-project-%s:/workspace", project.ID),
-		"--workdir", "/workspace",
+	memory, err := parseMemoryLimit(project.Resources.Memory)
+	if err != nil {
+		return "", err
+	}
+	cpus, err := parseCPULimit(project.Resources.CPUs)
+	if err != nil {
+		return "", err
 	}
 
+	env := []string{
+		fmt.Sprintf("PROJECT_ID=%s", project.ID),
+		fmt.Sprintf("PROJECT_NAME=%s", project.Name),
+		fmt.Sprintf("PROJECT_TYPE=%s", project.Type),
+	}
 	// Add project-specific environment variables
 	for key, value := range project.Config {
-		args = append(args, "--env", fmt.Sprintf("%s=%s", key, value))
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
 	}
 
-	// Add image name and command - start with simple bash to keep container running
-	args = append(args, project.Image, "/bin/bash", "-c", "tail -f /dev/null")
+	spec := ContainerSpec{
+		Image: project.Image,
+		// Start with simple bash to keep container running
+		Cmd:         []string{"/bin/bash", "-c", "tail -f /dev/null"},
+		Env:         env,
+		WorkingDir:  "/workspace",
+		User:        "1000:1000",
+		Labels:      projectLabels(project),
+		Memory:      memory,
+		NanoCPUs:    cpus,
+		SecurityOpt: []string{"no-new-privileges:true"},
+		NetworkMode: "remoteclaude-network",
+		ExtraHosts:  []string{"host.docker.internal:host-gateway"}, // lets the permission hook reach the server
+		Binds:       []string{volumeName(project.ID) + ":/workspace"},
+	}
+
+	ctx, cancel := dockerContext()
+	defer cancel()
 
-	cmd := exec.Command("docker", args...)
-	output, err := cmd.CombinedOutput()
+	containerID, err := dm.engine.ContainerCreate(ctx, containerName(project.ID), spec)
 	if err != nil {
-		return "", fmt.Errorf("docker run failed: %v, output: %s", err, string(output))
+		return "", fmt.Errorf("docker create failed: %w", err)
+	}
+	if err := dm.engine.ContainerStart(ctx, containerID); err != nil {
+		dm.engine.ContainerRemove(ctx, containerID, true)
+		dm.engine.VolumeRemove(ctx, volumeName(project.ID))
+		return "", fmt.Errorf("docker start failed: %w", err)
 	}
 
-	containerID := strings.TrimSpace(string(output))
 	log.Printf("🐳 Container created: %s", containerID[:12])
 
 	return containerID, nil
@@ -146,23 +313,21 @@ func (dm *DockerManager) initializeProject(project *Project) error {
 	time.Sleep(2 * time.Second)
 
 	// Try project initialization script first
-	cmd := exec.Command("docker", "exec", project.ContainerID, "/usr/local/bin/project-init")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("⚠️ Project initialization failed: %v, output: %s", err, string(output))
-		
+	result, err := dm.execContainer(context.Background(), project.ContainerID, []string{"/usr/local/bin/project-init"}, nil, nil)
+	if err = execFailure(result, err); err != nil {
+		log.Printf("⚠️ Project initialization failed: %v, output: %s", err, result.combined())
+
 		// Fallback: Manual directory creation without chown
 		log.Printf("🔧 Using fallback initialization method")
-		fallbackCmd := exec.Command("docker", "exec", project.ContainerID, "/bin/bash", "-c", 
-			"mkdir -p /workspace/{src,tests,docs,config,scripts} && "+
-			"cd /workspace && "+
-			"git init -q && "+
-			"echo '# "+project.Name+"' > README.md && "+
-			"echo 'Project initialized successfully'")
-		
-		fallbackOutput, fallbackErr := fallbackCmd.CombinedOutput()
-		if fallbackErr != nil {
-			log.Printf("❌ Fallback initialization also failed: %v, output: %s", fallbackErr, string(fallbackOutput))
+		fallbackResult, fallbackErr := dm.execContainer(context.Background(), project.ContainerID, []string{"/bin/bash", "-c",
+			"mkdir -p /workspace/{src,tests,docs,config,scripts} && " +
+				"cd /workspace && " +
+				"git init -q && " +
+				"echo '# " + project.Name + "' > README.md && " +
+				"echo 'Project initialized successfully'"}, nil, nil)
+
+		if fallbackErr = execFailure(fallbackResult, fallbackErr); fallbackErr != nil {
+			log.Printf("❌ Fallback initialization also failed: %v, output: %s", fallbackErr, fallbackResult.combined())
 			// Continue anyway - container is still usable for basic operations
 			log.Printf("⚠️ Container created but initialization incomplete. Container is still functional for basic commands.")
 		} else {
@@ -174,31 +339,92 @@ func (dm *DockerManager) initializeProject(project *Project) error {
 	return nil
 }
 
-// ExecuteCommand runs a command inside the project container
-func (dm *DockerManager) ExecuteCommand(projectID, command string) (string, error) {
-	log.Printf("🔧 Executing in %s: %s", projectID, command)
+// ExecResult holds the separated output of a command run inside a container
+type ExecResult struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+	Duration time.Duration
+}
 
-	// Find container for project
-	containerID, err := dm.getContainerID(projectID)
+// combined returns stdout followed by stderr; nil results are empty
+func (r *ExecResult) combined() string {
+	if r == nil {
+		return ""
+	}
+	return string(r.Stdout) + string(r.Stderr)
+}
+
+// execFailure turns an exec error or a non-zero exit into an error
+func execFailure(result *ExecResult, err error) error {
 	if err != nil {
-		return "", err
+		return err
+	}
+	if result.ExitCode != 0 {
+		return &ExitStatusError{Code: result.ExitCode}
 	}
+	return nil
+}
+
+// execContainer runs argv in a container from the workspace directory and
+// collects its output. A non-zero exit is reported via ExitCode.
+func (dm *DockerManager) execContainer(ctx context.Context, containerID string, argv, env []string, stdin io.Reader) (*ExecResult, error) {
+	var stdout, stderr bytes.Buffer
+	start := time.Now()
+	code, err := dm.engine.Exec(ctx, containerID, ExecOptions{
+		Cmd:        argv,
+		Env:        append([]string{"PATH=/usr/local/bin:/usr/bin:/bin:/sbin"}, env...),
+		WorkingDir: workspaceRoot,
+		Stdin:      stdin,
+		Stdout:     &stdout,
+		Stderr:     &stderr,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to run command in container: %w", err)
+	}
+	return &ExecResult{
+		Stdout:   stdout.Bytes(),
+		Stderr:   stderr.Bytes(),
+		ExitCode: code,
+		Duration: time.Since(start),
+	}, nil
+}
+
+// ExecuteCommand runs a command inside the project container and returns its
+// combined output. A non-zero exit status is reported as an error.
+func (dm *DockerManager) ExecuteCommand(projectID, command string) (string, error) {
+	return dm.ExecuteCommandAs(AuditContext{Client: "server", Source: AuditSourceServer, Decision: AuditDecisionNotRequired}, projectID, command)
+}
 
-	// Check if container is running and start if necessary
-	if err := dm.ensureContainerRunning(containerID, projectID); err != nil {
-		return "", fmt.Errorf("failed to ensure container is running: %v", err)
+// ExecuteCommandAs is ExecuteCommand on behalf of the user and client in ac,
+// who are recorded in the audit log with the outcome
+func (dm *DockerManager) ExecuteCommandAs(ac AuditContext, projectID, command string) (string, error) {
+	start := time.Now()
+	result, err := dm.Exec(projectID, command)
+	if err != nil {
+		dm.audit.Record(ac, projectID, command, nil, time.Since(start), err)
+		return "", err
 	}
+	dm.audit.Record(ac, projectID, command, exitCodeOf(result.ExitCode), result.Duration, nil)
 
-	// Execute command in container with proper PATH environment
-	args := []string{"exec", "-i", "-e", "PATH=/usr/local/bin:/usr/bin:/bin:/sbin", containerID, "/bin/bash", "-c", command}
-	cmd := exec.Command("docker", args...)
+	return result.combined(), execFailure(result, nil)
+}
 
-	output, err := cmd.CombinedOutput()
-	result := string(output)
+// Exec runs a command inside the project container, keeping stdout, stderr
+// and the exit code apart. The error is only set when the command could not
+// be run at all; a failing command is reported through ExitCode.
+func (dm *DockerManager) Exec(projectID, command string) (*ExecResult, error) {
+	log.Printf("🔧 Executing in %s: %s", projectID, command)
 
+	result, err := dm.ExecArgs(projectID, []string{"/bin/bash", "-c", command}, nil)
 	if err != nil {
 		log.Printf("❌ Command execution failed: %v", err)
-		return result, err
+		return nil, err
+	}
+
+	if result.ExitCode != 0 {
+		log.Printf("❌ Command exited with status %d in %s", result.ExitCode, projectID)
+		return result, nil
 	}
 
 	log.Printf("✅ Command executed successfully in %s", projectID)
@@ -207,29 +433,46 @@ func (dm *DockerManager) ExecuteCommand(projectID, command string) (string, erro
 
 // ensureContainerRunning checks if container is running and starts it if not
 func (dm *DockerManager) ensureContainerRunning(containerID, projectID string) error {
+	ctx, cancel := dockerContext()
+	defer cancel()
+
 	// Check container status
-	cmd := exec.Command("docker", "inspect", "--format", "{{.State.Status}}", containerID)
-	output, err := cmd.CombinedOutput()
+	info, err := dm.engine.ContainerInspect(ctx, containerID)
 	if err != nil {
-		return fmt.Errorf("failed to check container status: %v", err)
+		return fmt.Errorf("failed to check container status: %w", err)
+	}
+	record, _ := dm.registry.Get(projectID)
+	if !record.TrashedAt.IsZero() {
+		return fmt.Errorf("%w: %s", ErrProjectTrashed, projectID)
 	}
 
-	status := strings.TrimSpace(string(output))
-	log.Printf("🔍 Container %s status: %s", containerID[:12], status)
+	log.Printf("🔍 Container %s status: %s", containerID[:12], info.State)
 
-	// If not running, start it
-	if status != "running" {
-		log.Printf("🚀 Starting stopped container %s for project %s", containerID[:12], projectID)
-		startCmd := exec.Command("docker", "start", containerID)
-		if err := startCmd.Run(); err != nil {
-			return fmt.Errorf("failed to start container: %v", err)
+	// If not running, start it; this is also how hibernated projects wake
+	if !info.Running {
+		hibernated := !record.HibernatedAt.IsZero()
+		log.Printf("🚀 Starting stopped container %s for project %s (hibernated: %v)", containerID[:12], projectID, hibernated)
+		if dm.onColdStart != nil {
+			dm.onColdStart(projectID, "starting", hibernated)
+		}
+		if err := dm.engine.ContainerStart(ctx, containerID); err != nil {
+			return fmt.Errorf("failed to start container: %w", err)
 		}
 
 		// Wait a bit for container to be fully ready
 		time.Sleep(2 * time.Second)
+		if hibernated {
+			dm.markHibernated(projectID, time.Time{})
+		}
+		if dm.onColdStart != nil {
+			dm.onColdStart(projectID, "ready", hibernated)
+		}
 		log.Printf("✅ Container %s started successfully", containerID[:12])
 	}
 
+	// Every command runs through here, so this is where a project is used
+	dm.registry.TouchCommand(projectID)
+
 	return nil
 }
 
@@ -237,87 +480,87 @@ func (dm *DockerManager) ensureContainerRunning(containerID, projectID string) e
 func (dm *DockerManager) ListProjects() ([]*Project, error) {
 	log.Printf("📋 Listing Docker projects...")
 
-	// Get all RemoteClaude containers
-	cmd := exec.Command("docker", "ps", "-a", "--filter", "name=remoteclaude-", "--format", "{{.Names}}\t{{.ID}}\t{{.Status}}\t{{.CreatedAt}}")
-	output, err := cmd.CombinedOutput()
+	ctx, cancel := dockerContext()
+	defer cancel()
+
+	// Get all RemoteClaude containers by their project label
+	containers, err := dm.engine.ContainerList(ctx, map[string][]string{"label": {labelProjectID}})
 	if err != nil {
-		return nil, fmt.Errorf("failed to list containers: %v", err)
+		return nil, fmt.Errorf("failed to list containers: %w", err)
 	}
 
 	var projects []*Project
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-
-	for _, line := range lines {
-		if line == "" {
+	seen := make(map[string]bool)
+	for _, c := range containers {
+		project, ok := projectFromLabels(c, dm.hibernated(c.Labels[labelProjectID]))
+		if !ok {
 			continue
 		}
+		seen[c.ID] = true
+		projects = append(projects, project)
+	}
 
-		parts := strings.Split(line, "\t")
-		if len(parts) < 4 {
+	// Containers created before labels existed are still found by name
+	legacy, err := dm.engine.ContainerList(ctx, map[string][]string{"name": {"remoteclaude-"}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	for _, c := range legacy {
+		if seen[c.ID] || len(c.Names) == 0 || !strings.HasPrefix(c.Names[0], "remoteclaude-") {
 			continue
 		}
-
-		name := parts[0]
-		containerID := parts[1]
-		status := parts[2]
-		createdAt := parts[3]
-
-		// Extract project ID from container name
-		projectID := strings.TrimPrefix(name, "remoteclaude-")
-
-		// Parse creation time
-		createdTime, _ := time.Parse("2006-01-02 15:04:05 -0700 MST", createdAt)
-
-		// Get project details from container labels/environment
-		project := &Project{
-			ID:          projectID,
-			Name:        projectID, // Will be enhanced with actual project name
-			Status:      parseContainerStatus(status),
-			ContainerID: containerID,
-			Image:       "remoteclaude-ubuntu-claude:latest",
-			CreatedAt:   createdTime,
-			LastAccess:  time.Now(),
+		info, err := dm.engine.ContainerInspect(ctx, c.ID)
+		if err != nil {
+			continue
 		}
+		projectID := strings.TrimPrefix(c.Names[0], "remoteclaude-")
+		projects = append(projects, projectFromEnv(c, info.Env, dm.hibernated(projectID)))
+	}
 
-		// Get additional project details
-		dm.enrichProjectDetails(project)
-
-		projects = append(projects, project)
+	// Merge in the registry, flagging projects whose container is gone
+	found := make(map[string]bool)
+	for _, project := range projects {
+		dm.registry.Sync(project).apply(project)
+		found[project.ID] = true
+	}
+	for _, record := range dm.registry.Records() {
+		if !found[record.ID] {
+			projects = append(projects, record.missingProject())
+		}
 	}
 
 	log.Printf("✅ Found %d Docker projects", len(projects))
 	return projects, nil
 }
 
-// enrichProjectDetails adds additional information to a project
-func (dm *DockerManager) enrichProjectDetails(project *Project) {
-	// Get environment variables from container
-	cmd := exec.Command("docker", "inspect", project.ContainerID, "--format", "{{json .Config.Env}}")
-	output, err := cmd.CombinedOutput()
+// GetProject returns one project, with the same metadata as ListProjects
+func (dm *DockerManager) GetProject(projectID string) (*Project, error) {
+	ctx, cancel := dockerContext()
+	defer cancel()
+
+	info, err := dm.engine.ContainerInspect(ctx, containerName(projectID))
+	if errors.Is(err, ErrContainerNotFound) {
+		return nil, fmt.Errorf("project not found: %s: %w", projectID, err)
+	}
 	if err != nil {
-		return
+		return nil, fmt.Errorf("failed to find container: %w", err)
 	}
 
-	var env []string
-	if err := json.Unmarshal(output, &env); err != nil {
-		return
+	summary := ContainerSummary{
+		ID:      info.ID,
+		Names:   []string{info.Name},
+		Image:   info.Image,
+		State:   info.State,
+		Created: info.Created,
+		Labels:  info.Labels,
 	}
-
-	// Parse environment variables
-	for _, envVar := range env {
-		parts := strings.SplitN(envVar, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-
-		key, value := parts[0], parts[1]
-		switch key {
-		case "PROJECT_NAME":
-			project.Name = value
-		case "PROJECT_TYPE":
-			project.Type = value
-		}
+	hibernated := dm.hibernated(projectID)
+	project, ok := projectFromLabels(summary, hibernated)
+	if !ok {
+		project = projectFromEnv(summary, info.Env, hibernated)
 	}
+	dm.registry.Sync(project).apply(project)
+	return project, nil
 }
 
 // StartProject starts a stopped project container
@@ -327,10 +570,16 @@ func (dm *DockerManager) StartProject(projectID string) error {
 		return err
 	}
 
-	cmd := exec.Command("docker", "start", containerID)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to start container: %v", err)
+	if record, _ := dm.registry.Get(projectID); !record.TrashedAt.IsZero() {
+		return fmt.Errorf("%w: %s", ErrProjectTrashed, projectID)
+	}
+
+	ctx, cancel := dockerContext()
+	defer cancel()
+	if err := dm.engine.ContainerStart(ctx, containerID); err != nil {
+		return fmt.Errorf("failed to start container: %w", err)
 	}
+	dm.markHibernated(projectID, time.Time{})
 
 	log.Printf("✅ Project started: %s", projectID)
 	return nil
@@ -343,77 +592,91 @@ func (dm *DockerManager) StopProject(projectID string) error {
 		return err
 	}
 
-	cmd := exec.Command("docker", "stop", containerID)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to stop container: %v", err)
+	ctx, cancel := dockerContext()
+	defer cancel()
+	if err := dm.engine.ContainerStop(ctx, containerID, 10*time.Second); err != nil {
+		return fmt.Errorf("failed to stop container: %w", err)
 	}
+	dm.markHibernated(projectID, time.Time{})
 
 	log.Printf("✅ Project stopped: %s", projectID)
 	return nil
 }
 
-// RemoveProject removes a project and its container
-func (dm *DockerManager) RemoveProject(projectID string) error {
+// PurgeProject deletes a project for good: its container, its workspace
+// volume and its registry entry. Snapshots are kept.
+func (dm *DockerManager) PurgeProject(projectID string) error {
 	containerID, err := dm.getContainerID(projectID)
-	if err != nil {
+	if errors.Is(err, ErrContainerNotFound) && dm.registry.Remove(projectID) {
+		// The container was deleted behind our back; clean up the rest
+		log.Printf("🗑️ Forgetting project %s without a container", projectID)
+	} else if err != nil {
 		return err
-	}
+	} else {
+		// Stop container first
+		dm.StopProject(projectID)
 
-	// Stop container first
-	dm.StopProject(projectID)
-
-	// Remove container
-	if err := dm.removeContainer(containerID); err != nil {
-		return err
+		// Remove container
+		if err := dm.removeContainer(containerID); err != nil {
+			return err
+		}
+		dm.registry.Remove(projectID)
 	}
 
-	// Remove associated volume
-	volumeName := fmt.Sprintf("remoteclaude-project-%s", projectID)
-	cmd := exec.Command("docker", "volume", "rm", volumeName)
-	cmd.Run() // Don't fail if volume doesn't exist
+	// Remove associated volume; it may never have been created
+	ctx, cancel := dockerContext()
+	defer cancel()
+	if err := dm.engine.VolumeRemove(ctx, volumeName(projectID)); err != nil && !errors.Is(err, ErrContainerNotFound) {
+		log.Printf("⚠️ Failed to remove volume of %s: %v", projectID, err)
+	}
 
-	log.Printf("✅ Project removed: %s", projectID)
+	log.Printf("✅ Project purged: %s", projectID)
 	return nil
 }
 
 // getContainerID finds the container ID for a project
 func (dm *DockerManager) getContainerID(projectID string) (string, error) {
-	containerName := fmt.Sprintf("remoteclaude-%s", projectID)
-	cmd := exec.Command("docker", "ps", "-aq", "--filter", fmt.Sprintf("name=%s", containerName))
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("failed to find container: %v", err)
-	}
+	ctx, cancel := dockerContext()
+	defer cancel()
 
-	containerID := strings.TrimSpace(string(output))
-	if containerID == "" {
-		return "", fmt.Errorf("project not found: %s", projectID)
+	info, err := dm.engine.ContainerInspect(ctx, containerName(projectID))
+	if errors.Is(err, ErrContainerNotFound) {
+		return "", fmt.Errorf("project not found: %s: %w", projectID, err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to find container: %w", err)
 	}
 
-	return containerID, nil
+	return info.ID, nil
 }
 
 // removeContainer removes a Docker container
 func (dm *DockerManager) removeContainer(containerID string) error {
-	cmd := exec.Command("docker", "rm", "-f", containerID)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to remove container: %v", err)
+	ctx, cancel := dockerContext()
+	defer cancel()
+	if err := dm.engine.ContainerRemove(ctx, containerID, true); err != nil {
+		return fmt.Errorf("failed to remove container: %w", err)
 	}
 	return nil
 }
 
-// parseContainerStatus converts Docker status to our status format
-func parseContainerStatus(dockerStatus string) string {
-	status := strings.ToLower(dockerStatus)
-	switch {
-	case strings.Contains(status, "up"):
+// parseContainerStatus converts a Docker container state to our status
+// format. A stopped container the idle manager stopped is hibernated.
+func parseContainerStatus(state string, hibernated bool) string {
+	switch strings.ToLower(state) {
+	case "running":
 		return "running"
-	case strings.Contains(status, "exited"):
+	case "exited", "dead":
+		if hibernated {
+			return ProjectStatusHibernated
+		}
 		return "stopped"
-	case strings.Contains(status, "created"):
+	case "created":
 		return "ready"
-	case strings.Contains(status, "restarting"):
+	case "restarting":
 		return "restarting"
+	case "paused":
+		return "paused"
 	default:
 		return "unknown"
 	}
@@ -434,16 +697,19 @@ func (dm *DockerManager) GetContainerLogs(projectID string, lines int) (string,
 		return "", err
 	}
 
-	cmd := exec.Command("docker", "logs", "--tail", fmt.Sprintf("%d", lines), containerID)
-	output, err := cmd.CombinedOutput()
+	ctx, cancel := dockerContext()
+	defer cancel()
+	output, err := dm.engine.ContainerLogs(ctx, containerID, lines)
 	if err != nil {
-		return "", fmt.Errorf("failed to get logs: %v", err)
+		return "", fmt.Errorf("failed to get logs: %w", err)
 	}
 
-	return string(output), nil
+	return output, nil
 }
 
-// StreamCommand executes a command and streams the output
+// StreamCommand executes a command and streams the output. Cancelling ctx
+// kills the command inside the container and reports ErrRunCanceled, or
+// ErrRunTimeout when the context deadline passed.
 func (dm *DockerManager) StreamCommand(ctx context.Context, projectID, command string) (<-chan string, <-chan error) {
 	outputChan := make(chan string, 100)
 	errorChan := make(chan error, 1)
@@ -460,63 +726,48 @@ func (dm *DockerManager) StreamCommand(ctx context.Context, projectID, command s
 
 		// Check if container is running and start if necessary
 		if err := dm.ensureContainerRunning(containerID, projectID); err != nil {
-			errorChan <- fmt.Errorf("failed to ensure container is running: %v", err)
+			errorChan <- fmt.Errorf("failed to ensure container is running: %w", err)
 			return
 		}
 
-		cmd := exec.CommandContext(ctx, "docker", "exec", "-i", "-e", "PATH=/usr/local/bin:/usr/bin:/bin:/sbin", containerID, "/bin/bash", "-c", command)
-		
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
+		// claude_cancel closes the exec stream; the processes it started are
+		// found by their run ID and killed
+		runID := newRunID()
+		output := outputWriter(outputChan)
+		code, err := dm.engine.Exec(ctx, containerID, ExecOptions{
+			Cmd:    []string{"/bin/bash", "-c", command},
+			Env:    []string{"PATH=/usr/local/bin:/usr/bin:/bin:/sbin", containerRunEnv + "=" + runID},
+			Stdout: output,
+			Stderr: output,
+		})
+
+		if ctxErr := runContextError(ctx, 0); ctxErr != nil {
+			dm.killRun(containerID, runID)
+			errorChan <- ctxErr
+		} else if err != nil {
 			errorChan <- err
-			return
+		} else if code != 0 {
+			errorChan <- &ExitStatusError{Code: code}
 		}
+	}()
 
-		stderr, err := cmd.StderrPipe()
-		if err != nil {
-			errorChan <- err
-			return
-		}
+	return outputChan, errorChan
+}
 
-		if err := cmd.Start(); err != nil {
-			errorChan <- err
-			return
-		}
+// outputWriter forwards streamed command output to a channel
+type outputWriter chan<- string
 
-		// Stream stdout
-		go func() {
-			scanner := io.Reader(stdout)
-			buf := make([]byte, 1024)
-			for {
-				n, err := scanner.Read(buf)
-				if n > 0 {
-					outputChan <- string(buf[:n])
-				}
-				if err != nil {
-					break
-				}
-			}
-		}()
-
-		// Stream stderr
-		go func() {
-			scanner := io.Reader(stderr)
-			buf := make([]byte, 1024)
-			for {
-				n, err := scanner.Read(buf)
-				if n > 0 {
-					outputChan <- string(buf[:n])
-				}
-				if err != nil {
-					break
-				}
-			}
-		}()
-
-		if err := cmd.Wait(); err != nil {
-			errorChan <- err
-		}
-	}()
+func (w outputWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
 
-	return outputChan, errorChan
-}
\ No newline at end of file
+// killRun kills every process in the container tagged with runID
+func (dm *DockerManager) killRun(containerID, runID string) {
+	ctx, cancel := dockerContext()
+	defer cancel()
+	result, err := dm.execContainer(ctx, containerID, []string{"/bin/sh", "-c", killRunScript, "sh", containerRunEnv, runID}, nil, nil)
+	if err = execFailure(result, err); err != nil {
+		log.Printf("⚠️ Failed to kill run %s in container %s: %v (%s)", runID, containerID, err, result.combined())
+	}
+}
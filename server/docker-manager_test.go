@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestDockerManager returns a DockerManager on a FakeEngine, with its
+// registry, templates and snapshots under a temporary HOME
+func newTestDockerManager(t *testing.T) (*DockerManager, *FakeEngine) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	engine := NewFakeEngine()
+	return NewDockerManagerWithEngine(t.TempDir(), engine), engine
+}
+
+// startTestProject creates and starts a project container without running
+// the workspace initialization
+func startTestProject(t *testing.T, dm *DockerManager, name string) *Project {
+	t.Helper()
+	project := &Project{
+		ID:        generateProjectID(name),
+		Name:      name,
+		Type:      DefaultTemplate,
+		Template:  DefaultTemplate,
+		Status:    "running",
+		Image:     DefaultProjectImage,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	containerID, err := dm.createContainer(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	project.ContainerID = containerID
+	dm.registry.Sync(project)
+	return project
+}
+
+func TestCreateAndListProjects(t *testing.T) {
+	dm, engine := newTestDockerManager(t)
+
+	project, err := dm.CreateProject(ProjectCreateRequest{Name: "demo", Type: "python", Creator: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, ok := engine.Container(project.ContainerID)
+	if !ok || !c.Info.Running {
+		t.Fatalf("container of %s is not running", project.ID)
+	}
+	if c.Info.Labels[labelProjectID] != project.ID || c.Info.Name != containerName(project.ID) {
+		t.Errorf("container = %q with labels %v", c.Info.Name, c.Info.Labels)
+	}
+	if !engine.HasVolume(volumeName(project.ID)) {
+		t.Error("workspace volume was not created")
+	}
+
+	projects, err := dm.ListProjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 1 {
+		t.Fatalf("ListProjects returned %d projects, want 1", len(projects))
+	}
+	listed := projects[0]
+	if listed.ID != project.ID || listed.Name != "demo" || listed.Type != "python" || listed.Creator != "alice" || listed.Owner != "alice" || listed.Status != "running" {
+		t.Errorf("listed project = %+v", listed)
+	}
+
+	got, err := dm.GetProject(project.ID)
+	if err != nil || got.ContainerID != project.ContainerID {
+		t.Errorf("GetProject = %+v, %v", got, err)
+	}
+}
+
+func TestExecExitCodes(t *testing.T) {
+	dm, engine := newTestDockerManager(t)
+	project := startTestProject(t, dm, "exec")
+
+	engine.ExecFunc = func(ctx context.Context, c *FakeContainer, opts ExecOptions) (int, error) {
+		command := opts.Cmd[len(opts.Cmd)-1]
+		fmt.Fprintf(opts.Stdout, "out:%s", command)
+		if strings.HasPrefix(command, "fail") {
+			fmt.Fprint(opts.Stderr, "boom")
+			return 2, nil
+		}
+		return 0, nil
+	}
+
+	result, err := dm.Exec(project.ID, "ok")
+	if err != nil || result.ExitCode != 0 || string(result.Stdout) != "out:ok" {
+		t.Errorf("Exec(ok) = %+v, %v", result, err)
+	}
+
+	result, err = dm.Exec(project.ID, "fail")
+	if err != nil {
+		t.Fatalf("a failing command is not an Exec error: %v", err)
+	}
+	if result.ExitCode != 2 || string(result.Stderr) != "boom" {
+		t.Errorf("Exec(fail) = %+v", result)
+	}
+
+	output, err := dm.ExecuteCommand(project.ID, "fail")
+	var statusErr *ExitStatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != 2 {
+		t.Errorf("ExecuteCommand(fail) error = %v, want exit status 2", err)
+	}
+	if !strings.Contains(output, "out:fail") || !strings.Contains(output, "boom") {
+		t.Errorf("ExecuteCommand(fail) output = %q", output)
+	}
+}
+
+func TestEngineErrorsThroughManager(t *testing.T) {
+	dm, engine := newTestDockerManager(t)
+	project := startTestProject(t, dm, "errors")
+
+	if _, err := dm.ExecuteCommand("no-such-project", "ls"); !errors.Is(err, ErrContainerNotFound) {
+		t.Errorf("unknown project: %v, want ErrContainerNotFound", err)
+	}
+	if _, err := dm.createContainer(project); !errors.Is(err, ErrContainerConflict) {
+		t.Errorf("duplicate container: %v, want ErrContainerConflict", err)
+	}
+
+	engine.StartErr = errors.New("start failed")
+	failed := &Project{ID: generateProjectID("unstartable"), Image: DefaultProjectImage}
+	if _, err := dm.createContainer(failed); err == nil {
+		t.Error("createContainer succeeded without starting the container")
+	}
+	if _, ok := engine.Container(containerName(failed.ID)); ok || engine.HasVolume(volumeName(failed.ID)) {
+		t.Error("container or volume left behind by a failed start")
+	}
+	engine.StartErr = nil
+
+	engine.Unavailable = true
+	if _, err := dm.ListProjects(); !errors.Is(err, ErrDaemonUnavailable) {
+		t.Errorf("ListProjects: %v, want ErrDaemonUnavailable", err)
+	}
+	if err := dm.StopProject(project.ID); !errors.Is(err, ErrDaemonUnavailable) {
+		t.Errorf("StopProject: %v, want ErrDaemonUnavailable", err)
+	}
+}
+
+func TestStreamCommandCancel(t *testing.T) {
+	dm, engine := newTestDockerManager(t)
+	project := startTestProject(t, dm, "stream")
+
+	started := make(chan struct{})
+	engine.ExecFunc = func(ctx context.Context, c *FakeContainer, opts ExecOptions) (int, error) {
+		if opts.Cmd[0] != "/bin/bash" {
+			return 0, nil // the kill script
+		}
+		fmt.Fprint(opts.Stdout, "working")
+		close(started)
+		<-ctx.Done()
+		return -1, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	output, errs := dm.StreamCommand(ctx, project.ID, "sleep 600")
+	<-started
+	cancel()
+
+	var streamed strings.Builder
+	for chunk := range output {
+		streamed.WriteString(chunk)
+	}
+	if err := <-errs; !errors.Is(err, ErrRunCanceled) {
+		t.Errorf("StreamCommand error = %v, want ErrRunCanceled", err)
+	}
+	if streamed.String() != "working" {
+		t.Errorf("streamed output = %q", streamed.String())
+	}
+
+	c, _ := engine.Container(project.ContainerID)
+	last := c.Execs[len(c.Execs)-1]
+	if len(last) < 3 || last[2] != killRunScript {
+		t.Errorf("last exec = %q, want the kill script", last)
+	}
+}
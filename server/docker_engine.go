@@ -0,0 +1,585 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors of the container engine. EngineError wraps one of them, so callers
+// test with errors.Is.
+var (
+	ErrContainerNotFound = errors.New("not found")
+	ErrContainerConflict = errors.New("conflict")
+	ErrDaemonUnavailable = errors.New("docker daemon unavailable")
+)
+
+// ErrExecStillRunning is returned when an exec closed its output streams but
+// its process kept running, so it has no exit code yet
+var ErrExecStillRunning = errors.New("exec closed its output but is still running")
+
+// execExitWait bounds how long Exec waits for an exit code once the output
+// streams have ended
+const execExitWait = time.Second
+
+// EngineError is an error reply from the Docker daemon
+type EngineError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *EngineError) Error() string {
+	return fmt.Sprintf("docker: %s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+// Is maps the HTTP status to ErrContainerNotFound or ErrContainerConflict
+func (e *EngineError) Is(target error) bool {
+	switch target {
+	case ErrContainerNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrContainerConflict:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+// ExitStatusError reports a command that ran and exited non-zero
+type ExitStatusError struct {
+	Code int
+}
+
+func (e *ExitStatusError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ContainerSpec describes a container to create
+type ContainerSpec struct {
+	Image       string
+	Cmd         []string
+	Env         []string
+	WorkingDir  string
+	User        string
+	Labels      map[string]string
+	Memory      int64 // bytes; 0 means unlimited
+	NanoCPUs    int64 // CPU quota in units of 1e-9 CPUs
+	SecurityOpt []string
+	NetworkMode string
+	ExtraHosts  []string
+	Binds       []string // "volume:/path" mounts
+}
+
+// ContainerInfo is the inspected state of a container
+type ContainerInfo struct {
+	ID         string
+	Name       string // without the leading slash
+	Image      string
+	State      string // "created", "running", "paused", "restarting", "exited", ...
+	Running    bool
+	Created    time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Env        []string
+	Labels     map[string]string
+	ExecIDs    []string // exec sessions still running in the container
+}
+
+// ContainerSummary is a container as returned by a listing
+type ContainerSummary struct {
+	ID      string
+	Names   []string // without the leading slash
+	Image   string
+	State   string
+	Status  string // human readable, e.g. "Up 2 hours"
+	Created time.Time
+	Labels  map[string]string
+}
+
+// ExecOptions describes a command run inside a container. Output is
+// streamed to Stdout and Stderr as it arrives; Stdin, if set, is sent to
+// the command and closed at EOF.
+type ExecOptions struct {
+	Cmd        []string
+	Env        []string
+	WorkingDir string
+	User       string
+	Stdin      io.Reader
+	Stdout     io.Writer
+	Stderr     io.Writer
+}
+
+// ContainerEngine is the part of the Docker Engine API the DockerManager
+// uses. DockerEngineClient talks to a real daemon, FakeEngine keeps
+// containers in memory.
+type ContainerEngine interface {
+	Ping(ctx context.Context) error
+	ContainerCreate(ctx context.Context, name string, spec ContainerSpec) (string, error)
+	ContainerStart(ctx context.Context, id string) error
+	ContainerStop(ctx context.Context, id string, timeout time.Duration) error
+	ContainerRemove(ctx context.Context, id string, force bool) error
+	ContainerInspect(ctx context.Context, id string) (*ContainerInfo, error)
+	// ContainerList lists all containers matching the Engine API filters,
+	// e.g. {"name": ["remoteclaude-"]}
+	ContainerList(ctx context.Context, filters map[string][]string) ([]ContainerSummary, error)
+	ContainerLogs(ctx context.Context, id string, tail int) (string, error)
+	VolumeRemove(ctx context.Context, name string) error
+	// Exec runs a command to completion and returns its exit code.
+	// Cancelling ctx detaches from the command; it may keep running.
+	Exec(ctx context.Context, id string, opts ExecOptions) (int, error)
+}
+
+// DockerEngineClient is a ContainerEngine speaking the Engine HTTP API,
+// over the unix socket unless DOCKER_HOST says otherwise
+type DockerEngineClient struct {
+	network string // "unix" or "tcp"
+	address string
+	http    *http.Client
+}
+
+// NewDockerEngineClient creates a client for DOCKER_HOST, defaulting to
+// unix:///var/run/docker.sock
+func NewDockerEngineClient() *DockerEngineClient {
+	network, address := "unix", "/var/run/docker.sock"
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		if u, err := url.Parse(host); err == nil {
+			switch u.Scheme {
+			case "unix":
+				address = u.Path
+			case "tcp":
+				network, address = "tcp", u.Host
+			}
+		}
+	}
+
+	c := &DockerEngineClient{network: network, address: address}
+	c.http = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return c.dial(ctx)
+			},
+		},
+	}
+	return c
+}
+
+func (c *DockerEngineClient) dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDaemonUnavailable, err)
+	}
+	return conn, nil
+}
+
+// do sends a request and decodes a JSON reply into out, if given. Replies
+// of 400 and above become EngineErrors.
+func (c *DockerEngineClient) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	u := "http://docker" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, c.requestError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return resp.StatusCode, engineError(resp)
+	}
+	if out != nil && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotModified {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("invalid docker reply to %s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// requestError classifies a failed round trip: the caller gave up, or the
+// daemon could not be reached
+func (c *DockerEngineClient) requestError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errors.Is(err, ErrDaemonUnavailable) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrDaemonUnavailable, err)
+}
+
+// engineError reads the daemon's {"message": ...} error body
+func engineError(resp *http.Response) error {
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var body struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &body) != nil || body.Message == "" {
+		body.Message = strings.TrimSpace(string(data))
+	}
+	return &EngineError{StatusCode: resp.StatusCode, Message: body.Message}
+}
+
+// Ping checks that the daemon answers
+func (c *DockerEngineClient) Ping(ctx context.Context) error {
+	_, err := c.do(ctx, "GET", "/_ping", nil, nil, nil)
+	return err
+}
+
+// ContainerCreate creates a container and returns its ID
+func (c *DockerEngineClient) ContainerCreate(ctx context.Context, name string, spec ContainerSpec) (string, error) {
+	body := map[string]interface{}{
+		"Image":      spec.Image,
+		"Cmd":        spec.Cmd,
+		"Env":        spec.Env,
+		"WorkingDir": spec.WorkingDir,
+		"User":       spec.User,
+		"Labels":     spec.Labels,
+		"HostConfig": map[string]interface{}{
+			"Memory":      spec.Memory,
+			"NanoCpus":    spec.NanoCPUs,
+			"SecurityOpt": spec.SecurityOpt,
+			"NetworkMode": spec.NetworkMode,
+			"ExtraHosts":  spec.ExtraHosts,
+			"Binds":       spec.Binds,
+		},
+	}
+	var out struct {
+		ID string `json:"Id"`
+	}
+	_, err := c.do(ctx, "POST", "/containers/create", url.Values{"name": {name}}, body, &out)
+	return out.ID, err
+}
+
+// ContainerStart starts a container; starting a running one is not an error
+func (c *DockerEngineClient) ContainerStart(ctx context.Context, id string) error {
+	_, err := c.do(ctx, "POST", "/containers/"+url.PathEscape(id)+"/start", nil, nil, nil)
+	return err
+}
+
+// ContainerStop stops a container, killing it after timeout; stopping a
+// stopped one is not an error
+func (c *DockerEngineClient) ContainerStop(ctx context.Context, id string, timeout time.Duration) error {
+	query := url.Values{"t": {strconv.Itoa(int(timeout.Seconds()))}}
+	_, err := c.do(ctx, "POST", "/containers/"+url.PathEscape(id)+"/stop", query, nil, nil)
+	return err
+}
+
+// ContainerRemove removes a container, killing it first when force is set
+func (c *DockerEngineClient) ContainerRemove(ctx context.Context, id string, force bool) error {
+	query := url.Values{"force": {strconv.FormatBool(force)}}
+	_, err := c.do(ctx, "DELETE", "/containers/"+url.PathEscape(id), query, nil, nil)
+	return err
+}
+
+// ContainerInspect returns a container's state, by ID or name
+func (c *DockerEngineClient) ContainerInspect(ctx context.Context, id string) (*ContainerInfo, error) {
+	var out struct {
+		ID      string `json:"Id"`
+		Name    string `json:"Name"`
+		Created time.Time
+		State   struct {
+			Status     string
+			Running    bool
+			StartedAt  time.Time
+			FinishedAt time.Time
+		}
+		Config struct {
+			Image  string
+			Env    []string
+			Labels map[string]string
+		}
+		ExecIDs []string
+	}
+	if _, err := c.do(ctx, "GET", "/containers/"+url.PathEscape(id)+"/json", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &ContainerInfo{
+		ID:         out.ID,
+		Name:       strings.TrimPrefix(out.Name, "/"),
+		Image:      out.Config.Image,
+		State:      out.State.Status,
+		Running:    out.State.Running,
+		Created:    out.Created,
+		StartedAt:  out.State.StartedAt,
+		FinishedAt: out.State.FinishedAt,
+		Env:        out.Config.Env,
+		Labels:     out.Config.Labels,
+		ExecIDs:    out.ExecIDs,
+	}, nil
+}
+
+// ContainerList lists all containers, running or not, matching filters
+func (c *DockerEngineClient) ContainerList(ctx context.Context, filters map[string][]string) ([]ContainerSummary, error) {
+	query := url.Values{"all": {"true"}}
+	if len(filters) > 0 {
+		data, _ := json.Marshal(filters)
+		query.Set("filters", string(data))
+	}
+
+	var out []struct {
+		ID      string `json:"Id"`
+		Names   []string
+		Image   string
+		State   string
+		Status  string
+		Created int64
+		Labels  map[string]string
+	}
+	if _, err := c.do(ctx, "GET", "/containers/json", query, nil, &out); err != nil {
+		return nil, err
+	}
+
+	containers := make([]ContainerSummary, 0, len(out))
+	for _, o := range out {
+		names := make([]string, len(o.Names))
+		for i, n := range o.Names {
+			names[i] = strings.TrimPrefix(n, "/")
+		}
+		containers = append(containers, ContainerSummary{
+			ID:      o.ID,
+			Names:   names,
+			Image:   o.Image,
+			State:   o.State,
+			Status:  o.Status,
+			Created: time.Unix(o.Created, 0),
+			Labels:  o.Labels,
+		})
+	}
+	return containers, nil
+}
+
+// ContainerLogs returns the last tail lines of stdout and stderr
+func (c *DockerEngineClient) ContainerLogs(ctx context.Context, id string, tail int) (string, error) {
+	query := url.Values{"stdout": {"true"}, "stderr": {"true"}, "tail": {strconv.Itoa(tail)}}
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://docker/containers/"+url.PathEscape(id)+"/logs?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", c.requestError(ctx, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return "", engineError(resp)
+	}
+
+	var output bytes.Buffer
+	if err := demuxStreams(resp.Body, &output, &output); err != nil {
+		return "", err
+	}
+	return output.String(), nil
+}
+
+// VolumeRemove removes a named volume
+func (c *DockerEngineClient) VolumeRemove(ctx context.Context, name string) error {
+	_, err := c.do(ctx, "DELETE", "/volumes/"+url.PathEscape(name), nil, nil, nil)
+	return err
+}
+
+// Exec creates an exec instance, attaches to its streams over a hijacked
+// connection and waits for its exit code
+func (c *DockerEngineClient) Exec(ctx context.Context, id string, opts ExecOptions) (int, error) {
+	create := map[string]interface{}{
+		"Cmd":          opts.Cmd,
+		"Env":          opts.Env,
+		"WorkingDir":   opts.WorkingDir,
+		"User":         opts.User,
+		"AttachStdin":  opts.Stdin != nil,
+		"AttachStdout": true,
+		"AttachStderr": true,
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if _, err := c.do(ctx, "POST", "/containers/"+url.PathEscape(id)+"/exec", nil, create, &created); err != nil {
+		return -1, err
+	}
+
+	if err := c.attachExec(ctx, created.ID, opts); err != nil {
+		return -1, err
+	}
+
+	// The stream can end a moment before the daemon records the exit code
+	deadline := time.Now().Add(execExitWait)
+	for {
+		var state struct {
+			Running  bool
+			ExitCode int
+		}
+		if _, err := c.do(ctx, "GET", "/exec/"+created.ID+"/json", nil, nil, &state); err != nil {
+			return -1, err
+		}
+		if !state.Running {
+			return state.ExitCode, nil
+		}
+		if time.Now().After(deadline) {
+			return -1, fmt.Errorf("%w: exec %s", ErrExecStillRunning, shortContainerID(created.ID))
+		}
+		select {
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+// attachExec starts an exec instance on a raw connection, feeding stdin and
+// demultiplexing stdout and stderr until the command closes them
+func (c *DockerEngineClient) attachExec(ctx context.Context, execID string, opts ExecOptions) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Cancelling ctx tears the connection down, which ends both copies
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	body, _ := json.Marshal(map[string]bool{"Detach": false, "Tty": false})
+	req, err := http.NewRequest("POST", "http://docker/exec/"+execID+"/start", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	if err := req.Write(conn); err != nil {
+		return c.attachError(ctx, err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return c.attachError(ctx, err)
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return engineError(resp)
+	}
+
+	if opts.Stdin != nil {
+		go func() {
+			io.Copy(conn, opts.Stdin)
+			if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+			}
+		}()
+	}
+
+	stdout, stderr := opts.Stdout, opts.Stderr
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+	if err := demuxStreams(reader, stdout, stderr); err != nil {
+		return c.attachError(ctx, err)
+	}
+	return nil
+}
+
+// attachError prefers the context's error over the broken connection it caused
+func (c *DockerEngineClient) attachError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// demuxStreams splits Docker's multiplexed stream: each frame is an 8 byte
+// header holding the stream (1 stdout, 2 stderr) and payload size
+func demuxStreams(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		dst := stdout
+		if header[0] == 2 {
+			dst = stderr
+		}
+		if _, err := io.CopyN(dst, r, size); err != nil {
+			return err
+		}
+	}
+}
+
+// parseMemoryLimit converts a docker style size such as "512m" or "2g" to bytes
+func parseMemoryLimit(limit string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(limit))
+	if s == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	switch s[len(s)-1] {
+	case 'b':
+		s = s[:len(s)-1]
+	case 'k':
+		multiplier, s = 1<<10, s[:len(s)-1]
+	case 'm':
+		multiplier, s = 1<<20, s[:len(s)-1]
+	case 'g':
+		multiplier, s = 1<<30, s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory limit %q", limit)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// parseCPULimit converts a CPU count such as "1.5" to nano CPUs
+func parseCPULimit(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid CPU limit %q", s)
+	}
+	return int64(n * 1e9), nil
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeExecFunc answers an exec in a FakeEngine container. It writes the
+// command's output to opts.Stdout and opts.Stderr and returns its exit code.
+type FakeExecFunc func(ctx context.Context, container *FakeContainer, opts ExecOptions) (int, error)
+
+// FakeContainer is a container held by a FakeEngine
+type FakeContainer struct {
+	Info  ContainerInfo
+	Spec  ContainerSpec
+	Logs  string
+	Execs [][]string // commands run through Exec, in order
+}
+
+// FakeEngine is an in-memory ContainerEngine for exercising the
+// DockerManager without a Docker daemon. Exec runs ExecFunc, which by
+// default reads stdin and succeeds without output.
+type FakeEngine struct {
+	ExecFunc FakeExecFunc
+	// Unavailable makes every call fail with ErrDaemonUnavailable
+	Unavailable bool
+	// StartErr, when set, is returned by ContainerStart
+	StartErr error
+
+	mu         sync.Mutex
+	containers map[string]*FakeContainer // by ID
+	volumes    map[string]bool
+	execs      int // exec IDs handed out
+}
+
+// NewFakeEngine creates an empty fake engine
+func NewFakeEngine() *FakeEngine {
+	return &FakeEngine{
+		containers: make(map[string]*FakeContainer),
+		volumes:    make(map[string]bool),
+	}
+}
+
+// Container returns a copy of a container, by ID or name
+func (f *FakeEngine) Container(id string) (*FakeContainer, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.lookup(id)
+	if err != nil {
+		return nil, false
+	}
+	copied := *c
+	return &copied, true
+}
+
+// HasVolume reports whether a named volume exists
+func (f *FakeEngine) HasVolume(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.volumes[name]
+}
+
+// lookup finds a container by ID, ID prefix or name; f.mu must be held
+func (f *FakeEngine) lookup(id string) (*FakeContainer, error) {
+	for _, c := range f.containers {
+		if c.Info.ID == id || c.Info.Name == id || (len(id) >= 12 && strings.HasPrefix(c.Info.ID, id)) {
+			return c, nil
+		}
+	}
+	return nil, &EngineError{StatusCode: http.StatusNotFound, Message: "No such container: " + id}
+}
+
+func (f *FakeEngine) check() error {
+	if f.Unavailable {
+		return fmt.Errorf("%w: fake engine switched off", ErrDaemonUnavailable)
+	}
+	return nil
+}
+
+// Ping checks that the fake daemon is available
+func (f *FakeEngine) Ping(ctx context.Context) error {
+	return f.check()
+}
+
+// ContainerCreate stores a container in the "created" state
+func (f *FakeEngine) ContainerCreate(ctx context.Context, name string, spec ContainerSpec) (string, error) {
+	if err := f.check(); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.lookup(name); err == nil {
+		return "", &EngineError{StatusCode: http.StatusConflict, Message: fmt.Sprintf("Conflict. The container name %q is already in use", "/"+name)}
+	}
+
+	idBytes := make([]byte, 32)
+	rand.Read(idBytes)
+	id := hex.EncodeToString(idBytes)
+
+	for _, bind := range spec.Binds {
+		if volume := strings.SplitN(bind, ":", 2)[0]; !strings.HasPrefix(volume, "/") {
+			f.volumes[volume] = true
+		}
+	}
+	f.containers[id] = &FakeContainer{
+		Spec: spec,
+		Info: ContainerInfo{
+			ID:      id,
+			Name:    name,
+			Image:   spec.Image,
+			State:   "created",
+			Created: time.Now(),
+			Env:     spec.Env,
+			Labels:  spec.Labels,
+		},
+	}
+	return id, nil
+}
+
+// ContainerStart marks a container running
+func (f *FakeEngine) ContainerStart(ctx context.Context, id string) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+	if f.StartErr != nil {
+		return f.StartErr
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.lookup(id)
+	if err != nil {
+		return err
+	}
+	if !c.Info.Running {
+		c.Info.State, c.Info.Running, c.Info.StartedAt = "running", true, time.Now()
+	}
+	return nil
+}
+
+// ContainerStop marks a container exited
+func (f *FakeEngine) ContainerStop(ctx context.Context, id string, timeout time.Duration) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.lookup(id)
+	if err != nil {
+		return err
+	}
+	if c.Info.Running {
+		c.Info.State, c.Info.Running, c.Info.FinishedAt = "exited", false, time.Now()
+		c.Info.ExecIDs = nil
+	}
+	return nil
+}
+
+// ContainerRemove deletes a container; a running one needs force
+func (f *FakeEngine) ContainerRemove(ctx context.Context, id string, force bool) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.lookup(id)
+	if err != nil {
+		return err
+	}
+	if c.Info.Running && !force {
+		return &EngineError{StatusCode: http.StatusConflict, Message: "You cannot remove a running container " + c.Info.ID}
+	}
+	delete(f.containers, c.Info.ID)
+	return nil
+}
+
+// ContainerInspect returns a container's state
+func (f *FakeEngine) ContainerInspect(ctx context.Context, id string) (*ContainerInfo, error) {
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	info := c.Info
+	info.ExecIDs = append([]string(nil), c.Info.ExecIDs...)
+	return &info, nil
+}
+
+// ContainerList supports the "name" (substring), "label" ("key" or
+// "key=value") and "status" filters
+func (f *FakeEngine) ContainerList(ctx context.Context, filters map[string][]string) ([]ContainerSummary, error) {
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var list []ContainerSummary
+	for _, c := range f.containers {
+		if !fakeFiltersMatch(c, filters) {
+			continue
+		}
+		status := "Created"
+		switch c.Info.State {
+		case "running":
+			status = "Up " + time.Since(c.Info.StartedAt).Round(time.Second).String()
+		case "exited":
+			status = "Exited (0) " + time.Since(c.Info.FinishedAt).Round(time.Second).String() + " ago"
+		}
+		list = append(list, ContainerSummary{
+			ID:      c.Info.ID,
+			Names:   []string{c.Info.Name},
+			Image:   c.Info.Image,
+			State:   c.Info.State,
+			Status:  status,
+			Created: c.Info.Created,
+			Labels:  c.Info.Labels,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.After(list[j].Created) })
+	return list, nil
+}
+
+func fakeFiltersMatch(c *FakeContainer, filters map[string][]string) bool {
+	for key, values := range filters {
+		for _, value := range values {
+			switch key {
+			case "name":
+				if !strings.Contains(c.Info.Name, value) {
+					return false
+				}
+			case "status":
+				if c.Info.State != value {
+					return false
+				}
+			case "label":
+				parts := strings.SplitN(value, "=", 2)
+				actual, ok := c.Info.Labels[parts[0]]
+				if !ok || (len(parts) == 2 && actual != parts[1]) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// ContainerLogs returns the last tail lines of the container's Logs
+func (f *FakeEngine) ContainerLogs(ctx context.Context, id string, tail int) (string, error) {
+	if err := f.check(); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.lookup(id)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.SplitAfter(c.Logs, "\n")
+	if tail >= 0 && len(lines) > tail {
+		lines = lines[len(lines)-tail:]
+	}
+	return strings.Join(lines, ""), nil
+}
+
+// VolumeRemove deletes a named volume that no container mounts
+func (f *FakeEngine) VolumeRemove(ctx context.Context, name string) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.volumes[name] {
+		return &EngineError{StatusCode: http.StatusNotFound, Message: "get " + name + ": no such volume"}
+	}
+	for _, c := range f.containers {
+		for _, bind := range c.Spec.Binds {
+			if strings.HasPrefix(bind, name+":") {
+				return &EngineError{StatusCode: http.StatusConflict, Message: "remove " + name + ": volume is in use"}
+			}
+		}
+	}
+	delete(f.volumes, name)
+	return nil
+}
+
+// Exec runs ExecFunc in a running container
+func (f *FakeEngine) Exec(ctx context.Context, id string, opts ExecOptions) (int, error) {
+	if err := f.check(); err != nil {
+		return -1, err
+	}
+	f.mu.Lock()
+	c, err := f.lookup(id)
+	if err == nil && !c.Info.Running {
+		err = &EngineError{StatusCode: http.StatusConflict, Message: "Container " + c.Info.ID + " is not running"}
+	}
+	if err != nil {
+		f.mu.Unlock()
+		return -1, err
+	}
+	c.Execs = append(c.Execs, opts.Cmd)
+	f.execs++
+	execID := fmt.Sprintf("exec%d", f.execs)
+	c.Info.ExecIDs = append(c.Info.ExecIDs, execID)
+	snapshot := *c
+	f.mu.Unlock()
+	defer f.endExec(id, execID)
+
+	if opts.Stdout == nil {
+		opts.Stdout = ioutil.Discard
+	}
+	if opts.Stderr == nil {
+		opts.Stderr = ioutil.Discard
+	}
+	if f.ExecFunc == nil {
+		if opts.Stdin != nil {
+			io.Copy(ioutil.Discard, opts.Stdin)
+		}
+		return 0, nil
+	}
+	return f.ExecFunc(ctx, &snapshot, opts)
+}
+
+// endExec drops a finished exec from its container, like Docker does
+func (f *FakeEngine) endExec(id, execID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.lookup(id)
+	if err != nil {
+		return
+	}
+	for i, running := range c.Info.ExecIDs {
+		if running == execID {
+			c.Info.ExecIDs = append(c.Info.ExecIDs[:i:i], c.Info.ExecIDs[i+1:]...)
+			return
+		}
+	}
+}
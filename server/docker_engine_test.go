@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeDaemon is a minimal Docker Engine API serving one exec instance
+type fakeDaemon struct {
+	stdout   string
+	running  bool // the exec still runs after closing its streams
+	exitCode int
+}
+
+func (d *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/containers/missing/exec"):
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message":"No such container: missing"}`)
+	case strings.HasSuffix(r.URL.Path, "/containers/stopped/exec"):
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, `{"message":"Container stopped is not running"}`)
+	case strings.HasSuffix(r.URL.Path, "/exec"):
+		fmt.Fprint(w, `{"Id":"exec1"}`)
+	case strings.HasSuffix(r.URL.Path, "/exec/exec1/start"):
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		header := make([]byte, 8)
+		header[0] = 1
+		binary.BigEndian.PutUint32(header[4:], uint32(len(d.stdout)))
+		buf.Write(header)
+		buf.WriteString(d.stdout)
+		buf.Flush()
+	case strings.HasSuffix(r.URL.Path, "/exec/exec1/json"):
+		fmt.Fprintf(w, `{"Running":%v,"ExitCode":%d}`, d.running, d.exitCode)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newTestEngineClient points a DockerEngineClient at daemon
+func newTestEngineClient(t *testing.T, daemon http.Handler) *DockerEngineClient {
+	t.Helper()
+	server := httptest.NewServer(daemon)
+	t.Cleanup(server.Close)
+	t.Setenv("DOCKER_HOST", "tcp://"+strings.TrimPrefix(server.URL, "http://"))
+	return NewDockerEngineClient()
+}
+
+func TestEngineExecExitCode(t *testing.T) {
+	client := newTestEngineClient(t, &fakeDaemon{stdout: "hello\n", exitCode: 3})
+
+	var stdout bytes.Buffer
+	code, err := client.Exec(context.Background(), "c1", ExecOptions{Cmd: []string{"false"}, Stdout: &stdout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 3 || stdout.String() != "hello\n" {
+		t.Errorf("Exec = %d, %q; want 3, %q", code, stdout.String(), "hello\n")
+	}
+}
+
+func TestEngineExecStillRunning(t *testing.T) {
+	client := newTestEngineClient(t, &fakeDaemon{running: true})
+
+	code, err := client.Exec(context.Background(), "c1", ExecOptions{Cmd: []string{"daemon"}})
+	if !errors.Is(err, ErrExecStillRunning) {
+		t.Fatalf("Exec = %d, %v; want ErrExecStillRunning", code, err)
+	}
+	if processExitCode(err) != nil {
+		t.Error("a still running exec must not report an exit code")
+	}
+}
+
+func TestEngineErrorMapping(t *testing.T) {
+	client := newTestEngineClient(t, &fakeDaemon{})
+
+	if _, err := client.Exec(context.Background(), "missing", ExecOptions{Cmd: []string{"ls"}}); !errors.Is(err, ErrContainerNotFound) {
+		t.Errorf("missing container: %v, want ErrContainerNotFound", err)
+	}
+	if _, err := client.Exec(context.Background(), "stopped", ExecOptions{Cmd: []string{"ls"}}); !errors.Is(err, ErrContainerConflict) {
+		t.Errorf("stopped container: %v, want ErrContainerConflict", err)
+	}
+}
+
+func TestEngineUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	t.Setenv("DOCKER_HOST", "tcp://"+address)
+
+	client := NewDockerEngineClient()
+	if err := client.Ping(context.Background()); !errors.Is(err, ErrDaemonUnavailable) {
+		t.Errorf("Ping = %v, want ErrDaemonUnavailable", err)
+	}
+	if _, err := client.Exec(context.Background(), "c1", ExecOptions{Cmd: []string{"ls"}}); !errors.Is(err, ErrDaemonUnavailable) {
+		t.Errorf("Exec = %v, want ErrDaemonUnavailable", err)
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitBranchInfo describes the checked out branch in a status report
+type GitBranchInfo struct {
+	Head     string `json:"head"`
+	OID      string `json:"oid,omitempty"`
+	Upstream string `json:"upstream,omitempty"`
+	Ahead    int    `json:"ahead"`
+	Behind   int    `json:"behind"`
+}
+
+// GitFileStatus is one changed path from `git status --porcelain=v2`
+type GitFileStatus struct {
+	Path     string `json:"path"`
+	OrigPath string `json:"orig_path,omitempty"` // source of a rename or copy
+	Index    string `json:"index"`               // X column: staged change
+	Worktree string `json:"worktree"`            // Y column: unstaged change
+	State    string `json:"state"`               // modified, added, deleted, renamed, copied, typechange, untracked, conflicted
+	Staged   bool   `json:"staged"`
+	Unstaged bool   `json:"unstaged"`
+}
+
+// GitStatus is the parsed result of git:status
+type GitStatus struct {
+	Branch GitBranchInfo   `json:"branch"`
+	Files  []GitFileStatus `json:"files"`
+	Clean  bool            `json:"clean"`
+}
+
+// GitCommit is one entry of git:log
+type GitCommit struct {
+	Hash        string    `json:"hash"`
+	ShortHash   string    `json:"short_hash"`
+	AuthorName  string    `json:"author_name"`
+	AuthorEmail string    `json:"author_email"`
+	Date        time.Time `json:"date"`
+	Subject     string    `json:"subject"`
+}
+
+// GitDiff is the parsed result of git:diff
+type GitDiff struct {
+	Staged bool       `json:"staged"`
+	Files  []DiffFile `json:"files"`
+}
+
+// GitBranch is a local or remote-tracking branch
+type GitBranch struct {
+	Name     string `json:"name"`
+	Commit   string `json:"commit"`
+	Upstream string `json:"upstream,omitempty"`
+	Current  bool   `json:"current,omitempty"`
+}
+
+// GitBranches is the parsed result of git:branch
+type GitBranches struct {
+	Current string      `json:"current"`
+	Local   []GitBranch `json:"local"`
+	Remote  []GitBranch `json:"remote"`
+}
+
+// runGit runs git with argv inside /workspace and returns stdout, turning a
+// non-zero exit into an error carrying git's message
+func (dm *DockerManager) runGit(projectID string, args ...string) (string, error) {
+	result, err := dm.ExecArgs(projectID, append([]string{"git"}, args...), nil)
+	if err != nil {
+		return "", err
+	}
+	if result.ExitCode != 0 {
+		msg := strings.TrimSpace(string(result.Stderr))
+		if msg == "" {
+			msg = fmt.Sprintf("exit status %d", result.ExitCode)
+		}
+		return "", fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	return string(result.Stdout), nil
+}
+
+// gitPathspecs validates paths against /workspace and returns them relative to it
+func gitPathspecs(paths []string) ([]string, error) {
+	specs := make([]string, 0, len(paths))
+	for _, p := range paths {
+		resolved, err := resolveWorkspacePath(p)
+		if err != nil {
+			return nil, err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(resolved, workspaceRoot), "/")
+		if rel == "" {
+			rel = "."
+		}
+		specs = append(specs, rel)
+	}
+	return specs, nil
+}
+
+// GitStatus returns the working tree status of the project repository
+func (dm *DockerManager) GitStatus(projectID string) (*GitStatus, error) {
+	out, err := dm.runGit(projectID, "status", "--porcelain=v2", "--branch", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+	return parseGitStatusV2(out), nil
+}
+
+// parseGitStatusV2 parses `git status --porcelain=v2 --branch -z` output
+func parseGitStatusV2(out string) *GitStatus {
+	status := &GitStatus{Files: []GitFileStatus{}}
+	records := strings.Split(out, "\x00")
+
+	for i := 0; i < len(records); i++ {
+		record := records[i]
+		if record == "" {
+			continue
+		}
+
+		switch record[0] {
+		case '#':
+			fields := strings.Fields(record)
+			if len(fields) < 3 {
+				continue
+			}
+			switch fields[1] {
+			case "branch.oid":
+				status.Branch.OID = fields[2]
+			case "branch.head":
+				status.Branch.Head = fields[2]
+			case "branch.upstream":
+				status.Branch.Upstream = fields[2]
+			case "branch.ab":
+				if len(fields) >= 4 {
+					status.Branch.Ahead, _ = strconv.Atoi(strings.TrimPrefix(fields[2], "+"))
+					status.Branch.Behind, _ = strconv.Atoi(strings.TrimPrefix(fields[3], "-"))
+				}
+			}
+
+		case '1':
+			// 1 XY sub mH mI mW hH hI path
+			if parts := strings.SplitN(record, " ", 9); len(parts) == 9 {
+				status.Files = append(status.Files, newGitFileStatus(parts[1], parts[8], ""))
+			}
+
+		case '2':
+			// 2 XY sub mH mI mW hH hI Xscore path, followed by origPath
+			if parts := strings.SplitN(record, " ", 10); len(parts) == 10 {
+				orig := ""
+				if i+1 < len(records) {
+					i++
+					orig = records[i]
+				}
+				status.Files = append(status.Files, newGitFileStatus(parts[1], parts[9], orig))
+			}
+
+		case 'u':
+			// u XY sub m1 m2 m3 mW h1 h2 h3 path
+			if parts := strings.SplitN(record, " ", 11); len(parts) == 11 {
+				file := newGitFileStatus(parts[1], parts[10], "")
+				file.State = "conflicted"
+				status.Files = append(status.Files, file)
+			}
+
+		case '?':
+			status.Files = append(status.Files, GitFileStatus{
+				Path:     strings.TrimPrefix(record, "? "),
+				Index:    "?",
+				Worktree: "?",
+				State:    "untracked",
+				Unstaged: true,
+			})
+		}
+	}
+
+	status.Clean = len(status.Files) == 0
+	return status
+}
+
+// newGitFileStatus builds a file entry from a porcelain v2 XY code
+func newGitFileStatus(xy, path, orig string) GitFileStatus {
+	file := GitFileStatus{
+		Path:     path,
+		OrigPath: orig,
+		Index:    xy[:1],
+		Worktree: xy[1:2],
+		Staged:   xy[0] != '.',
+		Unstaged: xy[1] != '.',
+	}
+
+	code := xy[0]
+	if code == '.' {
+		code = xy[1]
+	}
+	switch code {
+	case 'M':
+		file.State = "modified"
+	case 'A':
+		file.State = "added"
+	case 'D':
+		file.State = "deleted"
+	case 'R':
+		file.State = "renamed"
+	case 'C':
+		file.State = "copied"
+	case 'T':
+		file.State = "typechange"
+	case 'U':
+		file.State = "conflicted"
+	default:
+		file.State = "modified"
+	}
+	return file
+}
+
+// GitLog returns up to limit commits, optionally restricted to paths
+func (dm *DockerManager) GitLog(projectID string, limit int, paths []string) ([]GitCommit, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	specs, err := gitPathspecs(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"log", "-n", strconv.Itoa(limit), "--format=%H%x1f%h%x1f%an%x1f%ae%x1f%aI%x1f%s%x1e"}
+	if len(specs) > 0 {
+		args = append(append(args, "--"), specs...)
+	}
+	out, err := dm.runGit(projectID, args...)
+	if err != nil {
+		// A repository without commits has no log yet
+		if strings.Contains(err.Error(), "does not have any commits") {
+			return []GitCommit{}, nil
+		}
+		return nil, err
+	}
+
+	commits := []GitCommit{}
+	for _, record := range strings.Split(out, "\x1e") {
+		fields := strings.Split(strings.TrimSpace(record), "\x1f")
+		if len(fields) != 6 {
+			continue
+		}
+		date, _ := time.Parse(time.RFC3339, fields[4])
+		commits = append(commits, GitCommit{
+			Hash:        fields[0],
+			ShortHash:   fields[1],
+			AuthorName:  fields[2],
+			AuthorEmail: fields[3],
+			Date:        date,
+			Subject:     fields[5],
+		})
+	}
+	return commits, nil
+}
+
+// GitDiff returns the unstaged (or staged) changes split into files and hunks
+func (dm *DockerManager) GitDiff(projectID string, staged bool, paths []string) (*GitDiff, error) {
+	specs, err := gitPathspecs(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	// Force a/ b/ prefixes so diff.noprefix in the user's config can't change paths
+	args := []string{"diff", "--no-color", "--no-ext-diff", "--src-prefix=a/", "--dst-prefix=b/"}
+	if staged {
+		args = append(args, "--cached")
+	}
+	if len(specs) > 0 {
+		args = append(append(args, "--"), specs...)
+	}
+	out, err := dm.runGit(projectID, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &GitDiff{Staged: staged, Files: []DiffFile{}}
+	if strings.TrimSpace(out) == "" {
+		return diff, nil
+	}
+	files, err := ParseUnifiedDiff(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse git diff: %v", err)
+	}
+	for _, f := range files {
+		f.OldPath = stripDiffPrefix(f.OldPath)
+		f.NewPath = stripDiffPrefix(f.NewPath)
+		diff.Files = append(diff.Files, f)
+	}
+	return diff, nil
+}
+
+// GitBranches lists local and remote-tracking branches
+func (dm *DockerManager) GitBranches(projectID string) (*GitBranches, error) {
+	out, err := dm.runGit(projectID, "for-each-ref",
+		"--format=%(refname)%1f%(refname:short)%1f%(objectname:short)%1f%(upstream:short)%1f%(HEAD)",
+		"refs/heads", "refs/remotes")
+	if err != nil {
+		return nil, err
+	}
+
+	branches := &GitBranches{Local: []GitBranch{}, Remote: []GitBranch{}}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, "\x1f")
+		if len(fields) != 5 {
+			continue
+		}
+		branch := GitBranch{
+			Name:     fields[1],
+			Commit:   fields[2],
+			Upstream: fields[3],
+			Current:  fields[4] == "*",
+		}
+		switch {
+		case strings.HasPrefix(fields[0], "refs/heads/"):
+			branches.Local = append(branches.Local, branch)
+			if branch.Current {
+				branches.Current = branch.Name
+			}
+		case strings.HasSuffix(fields[0], "/HEAD"):
+			// Skip symbolic origin/HEAD
+		default:
+			branches.Remote = append(branches.Remote, branch)
+		}
+	}
+
+	// Unborn branch: no refs yet but HEAD still names a branch
+	if branches.Current == "" {
+		if head, err := dm.runGit(projectID, "symbolic-ref", "--short", "-q", "HEAD"); err == nil {
+			branches.Current = strings.TrimSpace(head)
+		}
+	}
+	return branches, nil
+}
+
+// GitStage adds paths to the index
+func (dm *DockerManager) GitStage(projectID string, paths []string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("no paths to stage")
+	}
+	specs, err := gitPathspecs(paths)
+	if err != nil {
+		return err
+	}
+	_, err = dm.runGit(projectID, append([]string{"add", "--"}, specs...)...)
+	return err
+}
+
+// GitUnstage removes paths from the index, keeping working tree changes
+func (dm *DockerManager) GitUnstage(projectID string, paths []string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("no paths to unstage")
+	}
+	specs, err := gitPathspecs(paths)
+	if err != nil {
+		return err
+	}
+
+	// git restore needs a commit to restore from; before the first commit
+	// the only way to unstage is dropping the paths from the index
+	if _, err := dm.runGit(projectID, "rev-parse", "--verify", "-q", "HEAD"); err != nil {
+		_, err = dm.runGit(projectID, append([]string{"rm", "--cached", "-r", "-q", "--"}, specs...)...)
+		return err
+	}
+	_, err = dm.runGit(projectID, append([]string{"restore", "--staged", "--"}, specs...)...)
+	return err
+}
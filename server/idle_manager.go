@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProjectStatusHibernated marks a project whose container was stopped for
+// being idle. The next command starts it again.
+const ProjectStatusHibernated = "hibernated"
+
+// DefaultIdleTimeout is how long a project may sit idle before it is
+// hibernated, unless its registry record says otherwise
+const DefaultIdleTimeout = 30 * time.Minute
+
+// idleTimeout is the hibernation threshold of the project; negative never
+// hibernates
+func (rec ProjectRecord) idleTimeout() time.Duration {
+	switch {
+	case rec.IdleTimeoutMinutes < 0:
+		return -1
+	case rec.IdleTimeoutMinutes == 0:
+		return DefaultIdleTimeout
+	}
+	return time.Duration(rec.IdleTimeoutMinutes) * time.Minute
+}
+
+// idleTimeoutMinutes reports an idle timeout to clients; -1 means never
+func idleTimeoutMinutes(timeout time.Duration) int {
+	if timeout < 0 {
+		return -1
+	}
+	return int(timeout / time.Minute)
+}
+
+// hibernated reports whether the idle manager stopped the container of
+// projectID
+func (dm *DockerManager) hibernated(projectID string) bool {
+	record, _ := dm.registry.Get(projectID)
+	return !record.HibernatedAt.IsZero()
+}
+
+// markHibernated records when a project was hibernated; the zero time
+// clears it
+func (dm *DockerManager) markHibernated(projectID string, at time.Time) {
+	if record, ok := dm.registry.Get(projectID); !ok || record.HibernatedAt.Equal(at) {
+		return
+	}
+	dm.registry.Update(projectID, func(record *ProjectRecord) {
+		record.HibernatedAt = at
+	})
+}
+
+// backgroundProcessScript prints the processes of a container other than
+// its init process and the script itself. It uses shell builtins only, so
+// it forks nothing that would show up in the list.
+const backgroundProcessScript = `for p in /proc/[0-9]*; do p=${p#/proc/}; [ "$p" = 1 ] || [ "$p" = $$ ] || echo "$p"; done`
+
+// IdleManager stops project containers nobody has used for longer than
+// their idle timeout. Activity is the last command from the registry, the
+// last container start and anything reported through Touch, such as
+// conversation messages. Containers with exec sessions or background
+// processes still running, such as a dev server started with nohup, are
+// never stopped.
+type IdleManager struct {
+	dm       *DockerManager
+	mu       sync.Mutex
+	activity map[string]time.Time
+}
+
+// NewIdleManager creates an idle manager for the projects of dm
+func NewIdleManager(dm *DockerManager) *IdleManager {
+	return &IdleManager{
+		dm:       dm,
+		activity: make(map[string]time.Time),
+	}
+}
+
+// Touch records activity in projectID
+func (im *IdleManager) Touch(projectID string) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.activity[projectID] = time.Now()
+}
+
+// LastActivity returns when project was last used, given when its
+// container last started
+func (im *IdleManager) LastActivity(project *Project, startedAt time.Time) time.Time {
+	last := project.LastAccess
+	if startedAt.After(last) {
+		last = startedAt
+	}
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if touched := im.activity[project.ID]; touched.After(last) {
+		last = touched
+	}
+	return last
+}
+
+// HibernateIdle stops every running project idle past its timeout and
+// returns their IDs
+func (im *IdleManager) HibernateIdle() ([]string, error) {
+	projects, err := im.dm.ListProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	var hibernated []string
+	for _, project := range projects {
+		if project.Status != "running" || project.IdleTimeout < 0 {
+			continue
+		}
+		ok, err := im.hibernateIfIdle(project)
+		if err != nil {
+			log.Printf("⚠️ Failed to hibernate project %s: %v", project.ID, err)
+			continue
+		}
+		if ok {
+			hibernated = append(hibernated, project.ID)
+		}
+	}
+	return hibernated, nil
+}
+
+// hibernateIfIdle stops the container of project if it is idle
+func (im *IdleManager) hibernateIfIdle(project *Project) (bool, error) {
+	ctx, cancel := dockerContext()
+	defer cancel()
+
+	info, err := im.dm.engine.ContainerInspect(ctx, project.ContainerID)
+	if err != nil {
+		return false, err
+	}
+	if !info.Running || len(info.ExecIDs) > 0 {
+		return false, nil
+	}
+	idle := time.Since(im.LastActivity(project, info.StartedAt))
+	if idle < project.IdleTimeout {
+		return false, nil
+	}
+	if busy, err := im.hasBackgroundProcesses(ctx, project.ContainerID); err != nil || busy {
+		return false, err
+	}
+
+	// A command may have started since the inspect above
+	info, err = im.dm.engine.ContainerInspect(ctx, project.ContainerID)
+	if err != nil {
+		return false, err
+	}
+	if !info.Running || len(info.ExecIDs) > 0 {
+		return false, nil
+	}
+	if err := im.dm.engine.ContainerStop(ctx, project.ContainerID, 10*time.Second); err != nil {
+		return false, err
+	}
+	im.dm.markHibernated(project.ID, time.Now().UTC())
+	log.Printf("💤 Project %s hibernated after %v idle", project.ID, idle.Round(time.Second))
+	return true, nil
+}
+
+// hasBackgroundProcesses reports whether anything besides the container's
+// init process is running
+func (im *IdleManager) hasBackgroundProcesses(ctx context.Context, containerID string) (bool, error) {
+	result, err := im.dm.execContainer(ctx, containerID, []string{"/bin/sh", "-c", backgroundProcessScript}, nil, nil)
+	if err = execFailure(result, err); err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(result.Stdout)) != "", nil
+}
+
+// Start checks for idle projects every interval until the returned stop
+// function is called
+func (im *IdleManager) Start(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := im.HibernateIdle(); err != nil {
+					log.Printf("⚠️ Idle manager: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	log.Printf("💤 Idle manager running every %v", interval)
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// idleTestProject returns a running project that is past its idle timeout
+func idleTestProject(t *testing.T, dm *DockerManager, name string) *Project {
+	t.Helper()
+	project, err := dm.GetProject(startTestProject(t, dm, name).ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	project.IdleTimeout = time.Nanosecond
+	return project
+}
+
+func TestHibernateIdleProject(t *testing.T) {
+	dm, engine := newTestDockerManager(t)
+	im := NewIdleManager(dm)
+	project := idleTestProject(t, dm, "idle")
+
+	ok, err := im.hibernateIfIdle(project)
+	if err != nil || !ok {
+		t.Fatalf("hibernateIfIdle = %v, %v", ok, err)
+	}
+	if c, _ := engine.Container(project.ContainerID); c.Info.Running {
+		t.Error("idle container is still running")
+	}
+
+	got, err := dm.GetProject(project.ID)
+	if err != nil || got.Status != ProjectStatusHibernated || got.HibernatedAt.IsZero() {
+		t.Fatalf("GetProject = %+v, %v", got, err)
+	}
+	projects, _ := dm.ListProjects()
+	if len(projects) != 1 || projects[0].Status != ProjectStatusHibernated {
+		t.Errorf("ListProjects = %+v", projects)
+	}
+
+	// The next command wakes it
+	if _, err := dm.ExecuteCommand(project.ID, "ls"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := dm.GetProject(project.ID); got.Status != "running" || !got.HibernatedAt.IsZero() {
+		t.Errorf("after waking: %+v", got)
+	}
+}
+
+func TestHibernateSkipsBusyProjects(t *testing.T) {
+	dm, engine := newTestDockerManager(t)
+	im := NewIdleManager(dm)
+	project := idleTestProject(t, dm, "busy")
+
+	// A dev server left running in the background
+	engine.ExecFunc = func(ctx context.Context, c *FakeContainer, opts ExecOptions) (int, error) {
+		fmt.Fprintln(opts.Stdout, "42")
+		return 0, nil
+	}
+	if ok, err := im.hibernateIfIdle(project); err != nil || ok {
+		t.Errorf("with a background process: hibernateIfIdle = %v, %v", ok, err)
+	}
+
+	// A command starting while the processes are checked
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	engine.ExecFunc = func(ctx context.Context, c *FakeContainer, opts ExecOptions) (int, error) {
+		if opts.Cmd[len(opts.Cmd)-1] != backgroundProcessScript {
+			close(started)
+			<-release
+			return 0, nil
+		}
+		go engine.Exec(context.Background(), c.Info.ID, ExecOptions{Cmd: []string{"git", "status"}})
+		<-started
+		return 0, nil
+	}
+	if ok, err := im.hibernateIfIdle(project); err != nil || ok {
+		t.Errorf("with a new exec: hibernateIfIdle = %v, %v", ok, err)
+	}
+	if c, _ := engine.Container(project.ContainerID); !c.Info.Running {
+		t.Error("busy container was stopped")
+	}
+}
+
+func TestParseContainerStatus(t *testing.T) {
+	tests := []struct {
+		state      string
+		hibernated bool
+		want       string
+	}{
+		{"running", false, "running"},
+		{"running", true, "running"},
+		{"exited", false, "stopped"},
+		{"exited", true, ProjectStatusHibernated},
+		{"created", true, "ready"},
+	}
+	for _, tt := range tests {
+		if got := parseContainerStatus(tt.state, tt.hibernated); got != tt.want {
+			t.Errorf("parseContainerStatus(%q, %v) = %q, want %q", tt.state, tt.hibernated, got, tt.want)
+		}
+	}
+}
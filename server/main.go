@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -13,6 +15,7 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -30,21 +33,23 @@ const (
 
 // ConversationSession stores conversation context for each project
 type ConversationSession struct {
-	ProjectID     string            `json:"project_id"`
-	MessageHistory []ConversationMessage `json:"message_history"`
-	CreatedAt     time.Time         `json:"created_at"`
-	LastActivity  time.Time         `json:"last_activity"`
-	Context       map[string]string `json:"context"`
-	Language      string            `json:"language"` // detected language preference
+	ProjectID       string                `json:"project_id"`
+	MessageHistory  []ConversationMessage `json:"message_history"`
+	CreatedAt       time.Time             `json:"created_at"`
+	LastActivity    time.Time             `json:"last_activity"`
+	Context         map[string]string     `json:"context"`
+	Language        string                `json:"language"`                    // detected language preference
+	ClaudeSessionID string                `json:"claude_session_id,omitempty"` // CLI session resumed by the next request
 }
 
 // ConversationMessage represents a single message in the conversation
 type ConversationMessage struct {
-	Role      string    `json:"role"`      // "user" or "assistant"
-	Content   string    `json:"content"`   
-	Timestamp time.Time `json:"timestamp"`
-	Command   string    `json:"command,omitempty"`   // original command if different from content
-	Output    string    `json:"output,omitempty"`    // command execution output
+	Role      string       `json:"role"` // "user" or "assistant"
+	Content   string       `json:"content"`
+	Timestamp time.Time    `json:"timestamp"`
+	Command   string       `json:"command,omitempty"` // original command if different from content
+	Output    string       `json:"output,omitempty"`  // command execution output
+	Usage     *UsageRecord `json:"usage,omitempty"`   // tokens and cost of the Claude run that produced it
 }
 
 type Server struct {
@@ -54,12 +59,34 @@ type Server struct {
 	upgrader      websocket.Upgrader
 	dockerManager *DockerManager
 	configManager *ConfigManager
+	commandRouter *CommandRouter
 	// Session management
 	sessions      map[string]*ConversationSession
 	sessionsMutex sync.RWMutex
 	// Web-Mobile synchronization
-	webClients    map[string]chan map[string]interface{}
-	webMutex      sync.RWMutex
+	webClients map[string]chan map[string]interface{}
+	webMutex   sync.RWMutex
+	// Phone connections and the projects each one has worked on
+	clients      map[*websocket.Conn]map[string]bool
+	clientsMutex sync.RWMutex
+	// In-flight Claude runs by request ID, for claude_cancel
+	runs      map[string]*activeRun
+	runsMutex sync.Mutex
+	// Per-connection write locks; gorilla allows one concurrent writer
+	writeLocks sync.Map // *websocket.Conn -> *sync.Mutex
+	// AI backends by project
+	backends      map[string]*backendEntry
+	backendsMutex sync.Mutex
+	// Claude token usage and cost per project and user
+	usageTracker *UsageTracker
+	// Tool-level permission prompts for Claude runs
+	permissionGate *ToolPermissionGate
+	// Allow/deny rules consulted before prompting
+	permissionPolicy *PermissionPolicy
+	// Hash-chained record of executed commands and permission decisions
+	auditLog *AuditLog
+	// Hibernation of idle project containers
+	idleManager *IdleManager
 }
 
 func NewServer(port string) *Server {
@@ -70,17 +97,37 @@ func NewServer(port string) *Server {
 
 	// Initialize Docker manager
 	dockerManager := NewDockerManager("./projects")
+	pingCtx, cancelPing := dockerContext()
+	if err := dockerManager.engine.Ping(pingCtx); err != nil {
+		log.Printf("⚠️ Docker daemon not reachable: %v", err)
+	}
+	cancelPing()
 
 	// Initialize Configuration manager
 	configManager := NewConfigManager()
 
-	return &Server{
-		Port:          port,
-		SecretKey:     secretKey,
-		dockerManager: dockerManager,
-		configManager: configManager,
-		sessions:      make(map[string]*ConversationSession),
-		webClients:    make(map[string]chan map[string]interface{}),
+	// Audit log shared with the Docker manager
+	auditLog := NewAuditLog()
+	dockerManager.audit = auditLog
+
+	// Stops containers of idle projects; the next command wakes them
+	idleManager := NewIdleManager(dockerManager)
+
+	server := &Server{
+		Port:             port,
+		SecretKey:        secretKey,
+		dockerManager:    dockerManager,
+		configManager:    configManager,
+		commandRouter:    NewCommandRouter(),
+		sessions:         make(map[string]*ConversationSession),
+		webClients:       make(map[string]chan map[string]interface{}),
+		clients:          make(map[*websocket.Conn]map[string]bool),
+		runs:             make(map[string]*activeRun),
+		backends:         make(map[string]*backendEntry),
+		usageTracker:     NewUsageTracker(),
+		permissionPolicy: NewPermissionPolicy(configManager),
+		auditLog:         auditLog,
+		idleManager:      idleManager,
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true // Allow all origins for mobile app connection
@@ -89,6 +136,9 @@ func NewServer(port string) *Server {
 			HandshakeTimeout:  30 * time.Second,
 		},
 	}
+	server.permissionGate = NewToolPermissionGate(permissionManager, server.permissionPolicy, auditLog, dockerManager, port, server.sendPermissionRequest)
+	dockerManager.onColdStart = server.notifyColdStart
+	return server
 }
 
 func (s *Server) getLocalIP() string {
@@ -98,41 +148,41 @@ func (s *Server) getLocalIP() string {
 		defer conn.Close()
 		localAddr := conn.LocalAddr().(*net.UDPAddr)
 		ip := localAddr.IP.String()
-		
+
 		// Validate that we got a proper IP (not localhost)
 		if ip != "127.0.0.1" && ip != "::1" && ip != "" {
 			log.Printf("🌐 Detected IP via external connection: %s", ip)
 			return ip
 		}
 	}
-	
+
 	// Method 2: Fallback - scan network interfaces for best IP
 	log.Printf("⚠️ External connection method failed, scanning interfaces...")
-	
+
 	interfaces, err := net.Interfaces()
 	if err != nil {
 		log.Printf("❌ Failed to get network interfaces: %v", err)
 		return "localhost"
 	}
-	
+
 	var candidateIPs []string
-	
+
 	for _, iface := range interfaces {
 		// Skip loopback and down interfaces
 		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
 			continue
 		}
-		
+
 		addrs, err := iface.Addrs()
 		if err != nil {
 			continue
 		}
-		
+
 		for _, addr := range addrs {
 			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
 				if ipnet.IP.To4() != nil { // IPv4 only
 					ip := ipnet.IP.String()
-					
+
 					// Prioritize different IP ranges
 					if isPrivateIP(ip) {
 						candidateIPs = append(candidateIPs, ip)
@@ -142,7 +192,7 @@ func (s *Server) getLocalIP() string {
 			}
 		}
 	}
-	
+
 	// Select best IP based on priority
 	bestIP := selectBestIP(candidateIPs)
 	log.Printf("🎯 Selected best IP: %s", bestIP)
@@ -155,22 +205,22 @@ func isPrivateIP(ip string) bool {
 	if parsedIP == nil {
 		return false
 	}
-	
+
 	// Common private ranges
 	privateRanges := []string{
 		"10.0.0.0/8",     // Class A private
-		"172.16.0.0/12",  // Class B private  
+		"172.16.0.0/12",  // Class B private
 		"192.168.0.0/16", // Class C private
 		"169.254.0.0/16", // Link-local
 	}
-	
+
 	for _, cidr := range privateRanges {
 		_, network, _ := net.ParseCIDR(cidr)
 		if network != nil && network.Contains(parsedIP) {
 			return true
 		}
 	}
-	
+
 	return false
 }
 
@@ -179,7 +229,7 @@ func selectBestIP(candidates []string) string {
 	if len(candidates) == 0 {
 		return "localhost"
 	}
-	
+
 	// Priority order for different network types
 	priorities := []string{
 		"192.168.", // Home/office WiFi (highest priority)
@@ -187,7 +237,7 @@ func selectBestIP(candidates []string) string {
 		"172.",     // Docker/corporate networks
 		"169.254.", // Link-local (lowest priority)
 	}
-	
+
 	// Check each priority level
 	for _, prefix := range priorities {
 		for _, ip := range candidates {
@@ -197,7 +247,7 @@ func selectBestIP(candidates []string) string {
 			}
 		}
 	}
-	
+
 	// If no priority match, return first candidate
 	log.Printf("✅ No priority match, using first candidate: %s", candidates[0])
 	return candidates[0]
@@ -214,13 +264,13 @@ func (s *Server) isWireGuardActive() bool {
 			return true
 		}
 	}
-	
+
 	// Method 2: Check using wg command (no sudo needed for status check)
 	wgCmd := exec.Command("wg", "show")
 	if err := wgCmd.Run(); err == nil {
 		return true
 	}
-	
+
 	return false
 }
 
@@ -233,17 +283,17 @@ func (s *Server) generateQRCode() string {
 		s.Host = s.getLocalIP()
 		fmt.Printf("🏠 Local Mode: Server binding to local interface\n")
 	}
-	
+
 	connectionURL := fmt.Sprintf("ws://%s:%s/ws?key=%s", s.Host, s.Port, s.SecretKey)
-	
+
 	fmt.Printf("🚀 ClaudeOps Remote Server Started!\n")
 	fmt.Printf("Connection URL: %s\n", connectionURL)
 	fmt.Printf("🔑 Session Key: %s\n", s.SecretKey)
-	
+
 	// Always show both URLs for reference
 	localURL := fmt.Sprintf("ws://%s:%s/ws?key=%s", s.getLocalIP(), s.Port, s.SecretKey)
 	vpnURL := fmt.Sprintf("ws://10.0.0.1:%s/ws?key=%s", s.Port, s.SecretKey)
-	
+
 	if s.isWireGuardActive() {
 		fmt.Printf("✅ WireGuard VPN is active\n")
 		fmt.Printf("🔒 Primary (VPN): %s\n", vpnURL)
@@ -256,13 +306,13 @@ func (s *Server) generateQRCode() string {
 		fmt.Printf("📱 Start VPN with: sudo wg-quick up ~/.remoteclaude/wireguard/wg0.conf\n")
 	}
 	fmt.Printf("\n")
-	
+
 	// Generate QR code with the primary connection URL
 	s.printRealQRCode(connectionURL)
-	
+
 	// Also save QR code as image file
 	s.saveQRCodeImage(connectionURL)
-	
+
 	return connectionURL
 }
 
@@ -278,10 +328,10 @@ func (s *Server) printRealQRCode(url string) {
 
 	// Get QR code as string (terminal friendly)
 	qrString := qr.ToSmallString(false)
-	
+
 	fmt.Printf("QR Code (scan with iPhone app):\n")
 	fmt.Printf("┌────────────────────────────────────────────────────────────────┐\n")
-	
+
 	// Split QR string into lines and format
 	lines := strings.Split(qrString, "\n")
 	for _, line := range lines {
@@ -292,24 +342,24 @@ func (s *Server) printRealQRCode(url string) {
 				line = line[:62]
 				lineLen = 62
 			}
-			
+
 			padding := (62 - lineLen) / 2
 			if padding < 0 {
 				padding = 0
 			}
-			
+
 			rightPadding := 62 - lineLen - padding
 			if rightPadding < 0 {
 				rightPadding = 0
 			}
-			
-			fmt.Printf("│%s%s%s│\n", 
+
+			fmt.Printf("│%s%s%s│\n",
 				strings.Repeat(" ", padding),
 				line,
 				strings.Repeat(" ", rightPadding))
 		}
 	}
-	
+
 	fmt.Printf("│                                                                │\n")
 	fmt.Printf("│  🔗 Connection URL:                                            │\n")
 	fmt.Printf("│  %s  │\n", formatURLForDisplay(url))
@@ -356,7 +406,7 @@ func (s *Server) saveQRCodeImage(url string) {
 
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	log.Printf("🔗 WebSocket connection attempt from: %s", r.RemoteAddr)
-	
+
 	// Validate secret key
 	key := r.URL.Query().Get("key")
 	if key == "" {
@@ -364,7 +414,7 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing authentication key", http.StatusUnauthorized)
 		return
 	}
-	
+
 	if key != s.SecretKey {
 		log.Printf("❌ Invalid secret key provided: %s", key)
 		http.Error(w, "Invalid authentication key", http.StatusUnauthorized)
@@ -381,6 +431,9 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer conn.Close()
+	defer s.writeLocks.Delete(conn)
+	s.addClient(conn)
+	defer s.removeClient(conn)
 
 	log.Printf("✅ Mobile app connected from: %s", conn.RemoteAddr())
 
@@ -389,11 +442,11 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		"type": "connection_established",
 		"data": map[string]interface{}{
 			"server_version": "3.6.0",
-			"api_version":    "3.5",  // Compatible with v3.5.0 apps
-			"capabilities":   []string{"project_management", "claude_execution", "git_integration", "docker_support", "web_management"},
+			"api_version":    "3.5", // Compatible with v3.5.0 apps
+			"capabilities":   []string{"project_management", "claude_execution", "git_integration", "docker_support", "web_management", "file_transfer", "permission_prompts", "workspace_snapshots"},
 		},
 	}
-	conn.WriteJSON(welcome)
+	s.writeJSON(conn, welcome)
 
 	// Handle messages
 	for {
@@ -420,15 +473,47 @@ func (s *Server) handleMessage(conn *websocket.Conn, msg map[string]interface{})
 		return
 	}
 
+	// Remember which projects the connection works on, so project events
+	// such as permission requests reach it. Answering a permission request
+	// does not count: only a client already on the project may answer.
+	if data, ok := msg["data"].(map[string]interface{}); ok && msgType != "permission_response" {
+		if projectID, _ := data["project_id"].(string); projectID != "" {
+			s.watchProject(conn, projectID)
+		}
+	}
+
 	switch msgType {
 	case "ping":
 		s.sendMessage(conn, "pong", map[string]interface{}{"timestamp": msg["data"]})
 
 	case "project_list_request":
-		s.handleDockerProjectList(conn)
+		s.handleDockerProjectList(conn, msg)
+
+	case "project_metadata_update_request":
+		s.handleProjectMetadataUpdate(conn, msg)
+
+	case "project_templates_list":
+		s.handleProjectTemplatesList(conn)
+
+	case "snapshot_create_request":
+		// Archiving a workspace can take a while
+		go s.handleSnapshotCreate(conn, msg)
+
+	case "snapshot_list_request":
+		s.handleSnapshotList(conn, msg)
+
+	case "snapshot_restore_request":
+		go s.handleSnapshotRestore(conn, msg)
+
+	case "snapshot_clone_request":
+		go s.handleSnapshotClone(conn, msg)
+
+	case "snapshot_delete_request":
+		s.handleSnapshotDelete(conn, msg)
 
 	case "project_create_request":
-		s.handleProjectCreate(conn, msg)
+		// Cloning a source can take a while; progress is streamed meanwhile
+		go s.handleProjectCreate(conn, msg)
 
 	case "project_start_request":
 		s.handleProjectStart(conn, msg)
@@ -439,12 +524,31 @@ func (s *Server) handleMessage(conn *websocket.Conn, msg map[string]interface{})
 	case "project_remove_request":
 		s.handleProjectRemove(conn, msg)
 
+	case "project_restore_request":
+		s.handleProjectRestore(conn, msg)
+
 	case "claude_execute":
-		s.handleDockerClaudeExecute(conn, msg)
+		// Runs in the background so claude_cancel can be read meanwhile
+		go s.handleDockerClaudeExecute(conn, msg)
 
 	case "claude_execute_stream":
 		s.handleDockerClaudeExecuteStream(conn, msg)
 
+	case "claude_cancel":
+		s.handleClaudeCancel(conn, msg)
+
+	case "file_upload":
+		s.handleFileUpload(conn, msg)
+
+	case "file_download":
+		s.handleFileDownload(conn, msg)
+
+	case "workspace_tree":
+		s.handleWorkspaceTree(conn, msg)
+
+	case "workspace_search":
+		s.handleWorkspaceSearch(conn, msg)
+
 	case "settings_update":
 		s.handleSettingsUpdate(conn, msg)
 
@@ -458,7 +562,7 @@ func (s *Server) handleMessage(conn *websocket.Conn, msg map[string]interface{})
 		s.handleConversationClear(conn, msg)
 
 	case "conversation_continue":
-		s.handleConversationContinue(conn, msg)
+		go s.handleConversationContinue(conn, msg)
 
 	// Configuration management
 	case "config_save":
@@ -476,34 +580,83 @@ func (s *Server) handleMessage(conn *websocket.Conn, msg map[string]interface{})
 	case "quick_command_execute":
 		s.handleQuickCommandExecute(conn, msg)
 
+	case "usage_report":
+		s.handleUsageReport(conn, msg)
+
+	// Tool permission prompts
+	case "permission_response":
+		s.handlePermissionResponse(conn, msg)
+
+	case "permission_pending_list":
+		s.handlePermissionPendingList(conn, msg)
+
+	case "permission_rules_list":
+		s.handlePermissionRulesList(conn, msg)
+
+	case "permission_rule_add":
+		s.handlePermissionRuleAdd(conn, msg)
+
+	case "permission_rule_remove":
+		s.handlePermissionRuleRemove(conn, msg)
+
 	default:
 		s.sendError(conn, fmt.Sprintf("Unknown message type: %s", msgType))
 	}
 }
 
 // Docker-based project management handlers
-func (s *Server) handleDockerProjectList(conn *websocket.Conn) {
+func (s *Server) handleDockerProjectList(conn *websocket.Conn, msg map[string]interface{}) {
 	log.Printf("🐳 Handling Docker project list request")
-	
+
+	// Sorting and filtering are optional
+	data, _ := msg["data"].(map[string]interface{})
+	opts, err := projectListOptionsFromMap(data)
+	if err != nil {
+		s.sendError(conn, err.Error())
+		return
+	}
+
 	projects, err := s.dockerManager.ListProjects()
 	if err != nil {
 		s.sendError(conn, fmt.Sprintf("Failed to list Docker projects: %v", err))
 		return
 	}
-	
+	projects = FilterProjects(projects, opts)
+
 	// Convert to response format
 	projectsResponse := make([]map[string]interface{}, len(projects))
 	for i, project := range projects {
 		projectsResponse[i] = map[string]interface{}{
-			"id":            project.ID,
-			"name":          project.Name,
-			"type":          project.Type,
-			"status":        project.Status,
-			"container_id":  project.ContainerID[:12], // Short ID for display
-			"image":         project.Image,
-			"created_at":    project.CreatedAt.Format("2006-01-02T15:04:05Z"),
-			"last_access":   project.LastAccess.Format("2006-01-02T15:04:05Z"),
-			"resources":     project.Resources,
+			"id":           project.ID,
+			"name":         project.Name,
+			"type":         project.Type,
+			"template":     project.Template,
+			"status":       project.Status,
+			"container_id": shortContainerID(project.ContainerID), // Short ID for display
+			"image":        project.Image,
+			"created_at":   project.CreatedAt.Format("2006-01-02T15:04:05Z"),
+			"last_access":  project.LastAccess.Format("2006-01-02T15:04:05Z"),
+			"resources":    project.Resources,
+			"config":       project.Config,
+			"creator":      project.Creator,
+			"owner":        project.Owner,
+			"tags":         project.Tags,
+			"notes":        project.Notes,
+			"missing":      project.Status == ProjectStatusMissing,
+			"error":        project.Error,
+		}
+		if !project.LastCommandAt.IsZero() {
+			projectsResponse[i]["last_command_at"] = project.LastCommandAt.Format("2006-01-02T15:04:05Z")
+		}
+		if !project.HibernatedAt.IsZero() {
+			projectsResponse[i]["hibernated_at"] = project.HibernatedAt.Format("2006-01-02T15:04:05Z")
+		}
+		projectsResponse[i]["idle_timeout_minutes"] = idleTimeoutMinutes(project.IdleTimeout)
+		if !project.TrashedAt.IsZero() {
+			projectsResponse[i]["trashed_at"] = project.TrashedAt.Format("2006-01-02T15:04:05Z")
+			if retention := s.trashRetention(); retention >= 0 {
+				projectsResponse[i]["purge_at"] = project.TrashedAt.Add(retention).Format("2006-01-02T15:04:05Z")
+			}
 		}
 	}
 
@@ -511,31 +664,31 @@ func (s *Server) handleDockerProjectList(conn *websocket.Conn) {
 		"projects": projectsResponse,
 		"total":    len(projects),
 	})
-	
+
 	log.Printf("✅ Sent %d Docker projects to client", len(projects))
 }
 
 func (s *Server) handleProjectCreate(conn *websocket.Conn, msg map[string]interface{}) {
 	log.Printf("🐳 Handling project creation request")
-	
+
 	data, ok := msg["data"].(map[string]interface{})
 	if !ok {
 		s.sendError(conn, "Invalid project creation message format")
 		return
 	}
-	
+
 	// Parse project creation request
 	projectName, ok := data["name"].(string)
 	if !ok || projectName == "" {
 		s.sendError(conn, "Missing or invalid project name")
 		return
 	}
-	
+
 	projectType, ok := data["type"].(string)
 	if !ok || projectType == "" {
 		projectType = "general" // Default project type
 	}
-	
+
 	// Parse optional configuration
 	config := make(map[string]string)
 	if configData, exists := data["config"].(map[string]interface{}); exists {
@@ -545,7 +698,7 @@ func (s *Server) handleProjectCreate(conn *websocket.Conn, msg map[string]interf
 			}
 		}
 	}
-	
+
 	// Parse optional resource limits
 	var resources *ResourceLimits
 	if resourceData, exists := data["resources"].(map[string]interface{}); exists {
@@ -557,45 +710,86 @@ func (s *Server) handleProjectCreate(conn *websocket.Conn, msg map[string]interf
 			resources.CPUs = cpus
 		}
 	}
-	
+
+	// Record who created the project
+	creator, _ := data["user_id"].(string)
+	if creator == "" {
+		creator = DefaultUserID
+	}
+
 	// Create project request
 	createReq := ProjectCreateRequest{
 		Name:      projectName,
 		Type:      projectType,
 		Config:    config,
 		Resources: resources,
+		Creator:   creator,
+	}
+
+	// Optional source to populate the workspace from
+	if sourceData, exists := data["source"].(map[string]interface{}); exists {
+		source, err := projectSourceFromMap(sourceData)
+		if err != nil {
+			s.sendError(conn, fmt.Sprintf("Invalid project source: %v", err))
+			return
+		}
+		createReq.Source = source
+	}
+	createReq.Progress = func(stage, message string) {
+		s.sendMessage(conn, "project_create_status", map[string]interface{}{
+			"status":  "progress",
+			"stage":   stage,
+			"message": message,
+			"name":    projectName,
+		})
 	}
-	
+
 	// Send status update
 	s.sendMessage(conn, "project_create_status", map[string]interface{}{
-		"status": "creating",
+		"status":  "creating",
 		"message": fmt.Sprintf("Creating Docker project: %s", projectName),
 	})
-	
+
 	// Create the project
 	project, err := s.dockerManager.CreateProject(createReq)
 	if err != nil {
+		status := map[string]interface{}{
+			"status":  "failed",
+			"error":   err.Error(),
+			"message": fmt.Sprintf("❌ Failed to create project '%s': %v", projectName, err),
+			"name":    projectName,
+		}
+		var createErr *ProjectCreateError
+		if errors.As(err, &createErr) {
+			status["project_id"] = createErr.ProjectID
+		}
+		s.sendMessage(conn, "project_create_status", status)
 		s.sendError(conn, fmt.Sprintf("Failed to create project: %v", err))
 		return
 	}
-	
+
 	// Send success response
 	s.sendMessage(conn, "project_create_response", map[string]interface{}{
 		"project": map[string]interface{}{
-			"id":            project.ID,
-			"name":          project.Name,
-			"type":          project.Type,
-			"status":        project.Status,
-			"container_id":  project.ContainerID[:12],
-			"image":         project.Image,
-			"created_at":    project.CreatedAt.Format("2006-01-02T15:04:05Z"),
-			"resources":     project.Resources,
+			"id":           project.ID,
+			"name":         project.Name,
+			"type":         project.Type,
+			"status":       project.Status,
+			"container_id": shortContainerID(project.ContainerID),
+			"image":        project.Image,
+			"template":     project.Template,
+			"created_at":   project.CreatedAt.Format("2006-01-02T15:04:05Z"),
+			"resources":    project.Resources,
+			"config":       project.Config,
+			"creator":      project.Creator,
 		},
 		"message": fmt.Sprintf("✅ Project '%s' created successfully!", projectName),
 	})
-	
+
 	log.Printf("✅ Created Docker project: %s (ID: %s)", projectName, project.ID)
 
+	s.saveTemplateQuickCommands(project)
+
 	// Auto-apply configuration to new container
 	go func() {
 		time.Sleep(5 * time.Second) // Wait for container to be fully ready
@@ -609,7 +803,7 @@ func (s *Server) handleProjectCreate(conn *websocket.Conn, msg map[string]interf
 			"name":         project.Name,
 			"type":         project.Type,
 			"status":       project.Status,
-			"container_id": project.ContainerID[:12],
+			"container_id": shortContainerID(project.ContainerID),
 			"created_at":   project.CreatedAt.Format("2006-01-02T15:04:05Z"),
 		},
 	})
@@ -617,297 +811,643 @@ func (s *Server) handleProjectCreate(conn *websocket.Conn, msg map[string]interf
 
 func (s *Server) handleProjectStart(conn *websocket.Conn, msg map[string]interface{}) {
 	log.Printf("🐳 Handling project start request")
-	
+
 	data, ok := msg["data"].(map[string]interface{})
 	if !ok {
 		s.sendError(conn, "Invalid project start message format")
 		return
 	}
-	
+
 	projectID, ok := data["project_id"].(string)
 	if !ok || projectID == "" {
 		s.sendError(conn, "Missing or invalid project ID")
 		return
 	}
-	
+
 	// Start the project
 	err := s.dockerManager.StartProject(projectID)
 	if err != nil {
 		s.sendError(conn, fmt.Sprintf("Failed to start project: %v", err))
 		return
 	}
-	
+
 	s.sendMessage(conn, "project_start_response", map[string]interface{}{
 		"project_id": projectID,
 		"status":     "running",
 		"message":    fmt.Sprintf("✅ Project '%s' started successfully!", projectID),
 	})
-	
+
 	log.Printf("✅ Started Docker project: %s", projectID)
 }
 
 func (s *Server) handleProjectStop(conn *websocket.Conn, msg map[string]interface{}) {
 	log.Printf("🐳 Handling project stop request")
-	
+
 	data, ok := msg["data"].(map[string]interface{})
 	if !ok {
 		s.sendError(conn, "Invalid project stop message format")
 		return
 	}
-	
+
 	projectID, ok := data["project_id"].(string)
 	if !ok || projectID == "" {
 		s.sendError(conn, "Missing or invalid project ID")
 		return
 	}
-	
+
 	// Stop the project
+	// Stop any AI run still working in the container
+	s.cancelBackendRuns(projectID)
+
 	err := s.dockerManager.StopProject(projectID)
 	if err != nil {
 		s.sendError(conn, fmt.Sprintf("Failed to stop project: %v", err))
 		return
 	}
-	
+
 	s.sendMessage(conn, "project_stop_response", map[string]interface{}{
 		"project_id": projectID,
 		"status":     "stopped",
 		"message":    fmt.Sprintf("✅ Project '%s' stopped successfully!", projectID),
 	})
-	
+
 	log.Printf("✅ Stopped Docker project: %s", projectID)
 }
 
 func (s *Server) handleProjectRemove(conn *websocket.Conn, msg map[string]interface{}) {
 	log.Printf("🐳 Handling project remove request")
-	
+
 	data, ok := msg["data"].(map[string]interface{})
 	if !ok {
 		s.sendError(conn, "Invalid project remove message format")
 		return
 	}
-	
+
 	projectID, ok := data["project_id"].(string)
 	if !ok || projectID == "" {
 		s.sendError(conn, "Missing or invalid project ID")
 		return
 	}
-	
-	// Remove the project
-	err := s.dockerManager.RemoveProject(projectID)
+
+	// Move the project to the trash, or delete it for good when asked to
+	permanent, _ := data["permanent"].(bool)
+	s.cancelBackendRuns(projectID)
+
+	if permanent {
+		if err := s.dockerManager.PurgeProject(projectID); err != nil {
+			s.sendError(conn, fmt.Sprintf("Failed to remove project: %v", err))
+			return
+		}
+		s.sendMessage(conn, "project_remove_response", map[string]interface{}{
+			"project_id": projectID,
+			"trashed":    false,
+			"message":    fmt.Sprintf("✅ Project '%s' removed permanently", projectID),
+		})
+		log.Printf("✅ Purged Docker project: %s", projectID)
+		return
+	}
+
+	project, err := s.dockerManager.RemoveProject(projectID)
 	if err != nil {
 		s.sendError(conn, fmt.Sprintf("Failed to remove project: %v", err))
 		return
 	}
-	
-	s.sendMessage(conn, "project_remove_response", map[string]interface{}{
+
+	// Projects without a container are purged right away
+	response := map[string]interface{}{
 		"project_id": projectID,
+		"trashed":    project != nil,
 		"message":    fmt.Sprintf("✅ Project '%s' removed successfully!", projectID),
-	})
-	
+	}
+	if project != nil {
+		response["trashed_at"] = project.TrashedAt.Format("2006-01-02T15:04:05Z")
+		if retention := s.trashRetention(); retention >= 0 {
+			response["purge_at"] = project.TrashedAt.Add(retention).Format("2006-01-02T15:04:05Z")
+		}
+		response["message"] = fmt.Sprintf("🗑️ Project '%s' moved to the trash", projectID)
+	}
+	s.sendMessage(conn, "project_remove_response", response)
+
 	log.Printf("✅ Removed Docker project: %s", projectID)
 }
 
+// handleProjectRestore takes a project out of the trash
+func (s *Server) handleProjectRestore(conn *websocket.Conn, msg map[string]interface{}) {
+	log.Printf("🐳 Handling project restore request")
+
+	data, ok := msg["data"].(map[string]interface{})
+	if !ok {
+		s.sendError(conn, "Invalid project restore message format")
+		return
+	}
+
+	projectID, ok := data["project_id"].(string)
+	if !ok || projectID == "" {
+		s.sendError(conn, "Missing or invalid project ID")
+		return
+	}
+
+	project, err := s.dockerManager.RestoreProject(projectID)
+	if err != nil {
+		s.sendError(conn, fmt.Sprintf("Failed to restore project: %v", err))
+		return
+	}
+
+	s.sendMessage(conn, "project_restore_response", map[string]interface{}{
+		"project_id":   projectID,
+		"status":       project.Status,
+		"container_id": shortContainerID(project.ContainerID),
+		"message":      fmt.Sprintf("♻️ Project '%s' restored from the trash", projectID),
+	})
+
+	log.Printf("✅ Restored Docker project: %s", projectID)
+}
+
+// snapshotRequestIDs reads project_id and, if required, snapshot_id from a
+// snapshot message
+func (s *Server) snapshotRequestIDs(conn *websocket.Conn, msg map[string]interface{}, needSnapshot bool) (map[string]interface{}, string, string, bool) {
+	data, ok := msg["data"].(map[string]interface{})
+	if !ok {
+		s.sendError(conn, "Invalid snapshot message format")
+		return nil, "", "", false
+	}
+	projectID, ok := data["project_id"].(string)
+	if !ok || projectID == "" {
+		s.sendError(conn, "Missing or invalid project ID")
+		return nil, "", "", false
+	}
+	snapshotID, _ := data["snapshot_id"].(string)
+	if needSnapshot && snapshotID == "" {
+		s.sendError(conn, "Missing snapshot ID")
+		return nil, "", "", false
+	}
+	return data, projectID, snapshotID, true
+}
+
+// handleSnapshotCreate archives a project's workspace
+func (s *Server) handleSnapshotCreate(conn *websocket.Conn, msg map[string]interface{}) {
+	data, projectID, _, ok := s.snapshotRequestIDs(conn, msg, false)
+	if !ok {
+		return
+	}
+	label, _ := data["label"].(string)
+
+	snapshot, err := s.dockerManager.CreateSnapshot(projectID, label, clientName(conn))
+	if err != nil {
+		s.sendError(conn, fmt.Sprintf("Failed to create snapshot: %v", err))
+		return
+	}
+
+	s.sendMessage(conn, "snapshot_create_response", map[string]interface{}{
+		"project_id": projectID,
+		"snapshot":   snapshot,
+		"status":     "success",
+	})
+}
+
+// handleSnapshotList lists the snapshots of one project, or of all
+func (s *Server) handleSnapshotList(conn *websocket.Conn, msg map[string]interface{}) {
+	data, _ := msg["data"].(map[string]interface{})
+	projectID, _ := data["project_id"].(string)
+
+	snapshots, err := s.dockerManager.snapshots.List(projectID)
+	if err != nil {
+		s.sendError(conn, fmt.Sprintf("Failed to list snapshots: %v", err))
+		return
+	}
+
+	s.sendMessage(conn, "snapshot_list_response", map[string]interface{}{
+		"project_id": projectID,
+		"snapshots":  snapshots,
+		"total":      len(snapshots),
+		"status":     "success",
+	})
+}
+
+// handleSnapshotRestore rolls a project back to a snapshot. Running Claude
+// sessions are stopped first so they do not write into the restored tree.
+func (s *Server) handleSnapshotRestore(conn *websocket.Conn, msg map[string]interface{}) {
+	_, projectID, snapshotID, ok := s.snapshotRequestIDs(conn, msg, true)
+	if !ok {
+		return
+	}
+
+	s.cancelBackendRuns(projectID)
+	backup, err := s.dockerManager.RestoreSnapshot(projectID, snapshotID, clientName(conn))
+	if err != nil {
+		response := map[string]interface{}{
+			"project_id":  projectID,
+			"snapshot_id": snapshotID,
+			"status":      "error",
+			"error":       err.Error(),
+		}
+		if backup != nil {
+			response["backup_snapshot"] = backup
+		}
+		s.sendMessage(conn, "snapshot_restore_response", response)
+		return
+	}
+
+	s.sendMessage(conn, "snapshot_restore_response", map[string]interface{}{
+		"project_id":      projectID,
+		"snapshot_id":     snapshotID,
+		"backup_snapshot": backup,
+		"status":          "success",
+		"message":         fmt.Sprintf("⏪ Restored %s; the previous state is snapshot %s", projectID, backup.ID),
+	})
+	s.notifyProject(projectID, "workspace_restored", map[string]interface{}{
+		"project_id":  projectID,
+		"snapshot_id": snapshotID,
+	})
+}
+
+// handleSnapshotClone forks a snapshot into a new project. Without a
+// snapshot_id the project's current state is snapshotted and cloned.
+func (s *Server) handleSnapshotClone(conn *websocket.Conn, msg map[string]interface{}) {
+	data, projectID, snapshotID, ok := s.snapshotRequestIDs(conn, msg, false)
+	if !ok {
+		return
+	}
+	name, _ := data["name"].(string)
+
+	progress := func(stage, message string) {
+		s.sendMessage(conn, "project_create_status", map[string]interface{}{
+			"status":  "progress",
+			"stage":   stage,
+			"message": message,
+			"name":    name,
+		})
+	}
+	project, snapshot, err := s.dockerManager.CloneSnapshot(projectID, snapshotID, name, DefaultUserID, progress)
+	if err != nil {
+		s.sendError(conn, fmt.Sprintf("Failed to clone snapshot: %v", err))
+		return
+	}
+
+	s.sendMessage(conn, "snapshot_clone_response", map[string]interface{}{
+		"source_project_id": projectID,
+		"snapshot_id":       snapshot.ID,
+		"project": map[string]interface{}{
+			"id":           project.ID,
+			"name":         project.Name,
+			"type":         project.Type,
+			"template":     project.Template,
+			"status":       project.Status,
+			"container_id": shortContainerID(project.ContainerID),
+		},
+		"status": "success",
+	})
+	s.saveTemplateQuickCommands(project)
+}
+
+// handleSnapshotDelete removes one snapshot
+func (s *Server) handleSnapshotDelete(conn *websocket.Conn, msg map[string]interface{}) {
+	_, projectID, snapshotID, ok := s.snapshotRequestIDs(conn, msg, true)
+	if !ok {
+		return
+	}
+
+	if err := s.dockerManager.snapshots.Delete(projectID, snapshotID); err != nil {
+		s.sendError(conn, fmt.Sprintf("Failed to delete snapshot: %v", err))
+		return
+	}
+
+	s.sendMessage(conn, "snapshot_delete_response", map[string]interface{}{
+		"project_id":  projectID,
+		"snapshot_id": snapshotID,
+		"status":      "success",
+	})
+}
+
+// handleProjectTemplatesList describes the templates projects can be
+// created from
+func (s *Server) handleProjectTemplatesList(conn *websocket.Conn) {
+	templates := s.dockerManager.templates.List()
+
+	s.sendMessage(conn, "project_templates_list_response", map[string]interface{}{
+		"templates": templates,
+		"default":   DefaultTemplate,
+		"total":     len(templates),
+		"status":    "success",
+	})
+}
+
+// saveTemplateQuickCommands adds the quick commands of the project's
+// template to its container configuration
+func (s *Server) saveTemplateQuickCommands(project *Project) {
+	tmpl, err := s.dockerManager.templates.Get(project.Template)
+	if err != nil || len(tmpl.QuickCommands) == 0 {
+		return
+	}
+
+	config, err := s.configManager.LoadContainerConfig(project.ID)
+	if err != nil {
+		log.Printf("⚠️ Failed to load config of %s for template commands: %v", project.ID, err)
+		return
+	}
+	config.ContainerID = project.ContainerID
+	for _, command := range tmpl.QuickCommands {
+		exists := false
+		for _, existing := range config.Commands {
+			if existing.ID == command.ID {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			config.Commands = append(config.Commands, command)
+		}
+	}
+	config.UpdatedAt = time.Now()
+	if err := s.configManager.SaveContainerConfig(config); err != nil {
+		log.Printf("⚠️ Failed to save template commands of %s: %v", project.ID, err)
+	}
+}
+
+// handleProjectMetadataUpdate sets the owner, tags or notes of a project;
+// fields left out of the request are kept
+func (s *Server) handleProjectMetadataUpdate(conn *websocket.Conn, msg map[string]interface{}) {
+	data, ok := msg["data"].(map[string]interface{})
+	if !ok {
+		s.sendError(conn, "Invalid project metadata message format")
+		return
+	}
+
+	projectID, ok := data["project_id"].(string)
+	if !ok || projectID == "" {
+		s.sendError(conn, "Missing or invalid project ID")
+		return
+	}
+
+	var tags []string
+	rawTags, hasTags := data["tags"].([]interface{})
+	for _, tag := range rawTags {
+		if tagStr, ok := tag.(string); ok {
+			tags = append(tags, tagStr)
+		}
+	}
+	notes, hasNotes := data["notes"].(string)
+	owner, hasOwner := data["owner"].(string)
+	idleMinutes, hasIdle := data["idle_timeout_minutes"].(float64)
+
+	record, err := s.dockerManager.registry.Update(projectID, func(record *ProjectRecord) {
+		if hasTags {
+			record.Tags = normalizeTags(tags)
+		}
+		if hasNotes {
+			record.Notes = notes
+		}
+		if hasOwner && owner != "" {
+			record.Owner = owner
+		}
+		if hasIdle {
+			record.IdleTimeoutMinutes = int(idleMinutes)
+		}
+	})
+	if err != nil {
+		s.sendError(conn, fmt.Sprintf("Failed to update project: %v", err))
+		return
+	}
+
+	s.sendMessage(conn, "project_metadata_update_response", map[string]interface{}{
+		"project_id":           projectID,
+		"owner":                record.Owner,
+		"tags":                 record.Tags,
+		"notes":                record.Notes,
+		"idle_timeout_minutes": idleTimeoutMinutes(record.idleTimeout()),
+		"status":               "success",
+	})
+
+	log.Printf("🏷️ Updated metadata of project %s", projectID)
+}
+
 func (s *Server) handleDockerClaudeExecute(conn *websocket.Conn, msg map[string]interface{}) {
 	log.Printf("🐳 Handling Docker Claude execution request")
-	
+
 	data, ok := msg["data"].(map[string]interface{})
 	if !ok {
 		s.sendError(conn, "Invalid Docker execute message format")
 		return
 	}
-	
+
 	projectID, ok := data["project_id"].(string)
 	if !ok || projectID == "" {
 		s.sendError(conn, "Missing or invalid project ID")
 		return
 	}
-	
+
 	command, ok := data["command"].(string)
 	if !ok || command == "" {
 		s.sendError(conn, "Missing command")
 		return
 	}
-	
+
+	requestID, _ := data["request_id"].(string)
+	ctx, requestID, done := s.startRun(requestID)
+	defer done()
+
 	log.Printf("🤖 Executing in Docker container %s: %s", projectID, command)
-	
+
 	// Get or create conversation session
 	session := s.getOrCreateSession(projectID)
-	
+
 	// Detect and update language preference
 	detectedLang := s.detectLanguage(command)
 	if detectedLang != "auto" && session.Language == "auto" {
 		session.Language = detectedLang
 		log.Printf("🌐 Detected language for session %s: %s", projectID, detectedLang)
 	}
-	
+
 	// Add user message to session
 	s.addMessageToSession(projectID, "user", command, command, "")
-	
+
 	// Get conversation context
 	sessionContext := s.getSessionContext(projectID)
-	
+
 	// Use the enhanced command router for unified command processing
-	output, err := s.processEnhancedCommand(projectID, command, sessionContext)
-	if err != nil {
+	result, err := s.processEnhancedCommand(ctx, s.auditContext(conn, AuditSourceCommand), projectID, command, sessionContext)
+	output := result.Output()
+	if err != nil || !result.Success() {
+		errMsg := fmt.Sprintf("exit status %d", result.ExitCode)
+		if err != nil {
+			errMsg = err.Error()
+		}
+
 		// Add error to session
-		s.addMessageToSession(projectID, "assistant", "", command, fmt.Sprintf("Error: %s", err.Error()))
-		
+		s.addMessageToSession(projectID, "assistant", "", command, fmt.Sprintf("Error: %s", errMsg))
+
 		s.sendMessage(conn, "claude_error", map[string]interface{}{
-			"project_id": projectID,
-			"error":      err.Error(),
-			"command":    command,
-			"output":     output,
+			"project_id":      projectID,
+			"request_id":      requestID,
+			"error":           errMsg,
+			"timed_out":       errors.Is(err, ErrRunTimeout),
+			"canceled":        errors.Is(err, ErrRunCanceled),
+			"budget_exceeded": errors.Is(err, ErrBudgetExceeded),
+			"command":         command,
+			"output":          output,
+			"result":          result,
 		})
 		return
 	}
-	
+
 	// Add successful output to session
-	s.addMessageToSession(projectID, "assistant", "", command, output)
-	
+	s.addMessageWithUsage(projectID, "assistant", "", command, output, result.Usage)
+	if result.Usage != nil {
+		s.sendBudgetWarnings(conn, projectID)
+	}
+
 	log.Printf("📤 Sending claude_output to iOS app. Output length: %d", len(output))
 	previewLen := 200
 	if len(output) < previewLen {
 		previewLen = len(output)
 	}
 	log.Printf("📤 Output preview: %s", output[:previewLen])
-	
+
 	s.sendMessage(conn, "claude_output", map[string]interface{}{
-		"project_id":      projectID,
-		"session_id":      fmt.Sprintf("session_%s", projectID),
-		"language":        session.Language,
-		"message_count":   len(session.MessageHistory),
-		"output":     output,
-		"command":    command,
-		"status":     "completed",
+		"project_id":        projectID,
+		"session_id":        fmt.Sprintf("session_%s", projectID),
+		"language":          session.Language,
+		"message_count":     len(session.MessageHistory),
+		"claude_session_id": s.claudeSessionID(projectID),
+		"request_id":        requestID,
+		"output":            output,
+		"command":           command,
+		"status":            "completed",
+		"result":            result,
 	})
-	
+
 	log.Printf("✅ Docker command executed in %s: %s", projectID, command)
 }
 
 func (s *Server) handleDockerClaudeExecuteStream(conn *websocket.Conn, msg map[string]interface{}) {
 	log.Printf("🐳 Handling Docker Claude streaming execution request")
-	
+
 	data, ok := msg["data"].(map[string]interface{})
 	if !ok {
 		s.sendError(conn, "Invalid Docker stream execute message format")
 		return
 	}
-	
+
 	projectID, ok := data["project_id"].(string)
 	if !ok || projectID == "" {
 		s.sendError(conn, "Missing or invalid project ID")
 		return
 	}
-	
+
 	command, ok := data["command"].(string)
 	if !ok || command == "" {
 		s.sendError(conn, "Missing command")
 		return
 	}
-	
+
+	requestID, _ := data["request_id"].(string)
+	ctx, requestID, done := s.startRun(requestID)
+
 	log.Printf("🚀 Streaming execution in Docker container %s: %s", projectID, command)
-	
+
 	// Get or create conversation session
 	session := s.getOrCreateSession(projectID)
-	
+
 	// Detect and update language preference
 	detectedLang := s.detectLanguage(command)
 	if detectedLang != "auto" && session.Language == "auto" {
 		session.Language = detectedLang
 		log.Printf("🌐 Detected language for streaming session %s: %s", projectID, detectedLang)
 	}
-	
+
 	// Add user message to session
 	s.addMessageToSession(projectID, "user", command, command, "")
-	
+
 	// Get conversation context
 	sessionContext := s.getSessionContext(projectID)
-	
-	// Build enhanced command with context for streaming
-	var actualCommand string
+
+	// Natural language goes to the project's AI backend as a stream of events
 	if isNaturalLanguageCommand(command) {
-		var claudeCommand string
-		if sessionContext != "" {
-			claudeCommand = fmt.Sprintf("Context from previous conversation:\n%s\nCurrent request: %s", sessionContext, command)
-		} else {
-			claudeCommand = command
-		}
-		
-		// For streaming, add --stream flag for better real-time experience
-		if containsNonASCII(claudeCommand) {
-			// Use safe file-based processing for streaming
-			tempFile := fmt.Sprintf("/tmp/claude_stream_input_%d.txt", time.Now().UnixNano())
-			actualCommand = fmt.Sprintf(`cat > %s << 'CLAUDE_EOF'
-%s
-CLAUDE_EOF
-claude --stream "$(cat %s)" && rm %s`, tempFile, claudeCommand, tempFile, tempFile)
-		} else {
-			actualCommand = fmt.Sprintf("claude --stream \"%s\"", escapeQuotes(claudeCommand))
-		}
-		log.Printf("🌊 Converting to streaming Claude CLI command with context")
-	} else {
-		actualCommand = command
+		s.streamClaudePrompt(ctx, done, conn, projectID, requestID, command, sessionContext)
+		return
 	}
-	
+	actualCommand := command
+
 	// Send stream start notification
 	s.sendMessage(conn, "claude_stream_start", map[string]interface{}{
 		"session_id":    fmt.Sprintf("session_%s", projectID),
 		"language":      session.Language,
 		"message_count": len(session.MessageHistory),
-		"project_id": projectID,
-		"command":    command,
+		"project_id":    projectID,
+		"request_id":    requestID,
+		"command":       command,
 	})
-	
-	// Start streaming command execution
-	ctx := context.TODO() // In production, use proper context with timeout
+
+	// Start streaming command execution; claude_cancel stops it via ctx
+	started := time.Now()
 	outputChan, errorChan := s.dockerManager.StreamCommand(ctx, projectID, actualCommand)
-	
+
 	// Stream output in separate goroutine
 	go func() {
 		var streamedOutput strings.Builder
 		var streamError error
-		
+
+		defer done()
 		defer func() {
+			s.auditLog.Record(s.auditContext(conn, AuditSourceStream), projectID, actualCommand, processExitCode(streamError), time.Since(started), streamError)
+
 			// Add streamed result to session
 			if streamError != nil {
 				s.addMessageToSession(projectID, "assistant", "", actualCommand, fmt.Sprintf("Error: %s", streamError.Error()))
 			} else {
 				s.addMessageToSession(projectID, "assistant", "", actualCommand, streamedOutput.String())
 			}
-			
+
 			s.sendMessage(conn, "claude_stream_end", map[string]interface{}{
 				"project_id": projectID,
+				"request_id": requestID,
 				"command":    command,
 			})
 		}()
-		
+
 		for {
 			select {
 			case output, ok := <-outputChan:
 				if !ok {
+					// Output done; pick up an error sent just before the close
+					if err, ok := <-errorChan; ok && err != nil {
+						streamError = err
+						s.sendMessage(conn, "claude_stream_error", map[string]interface{}{
+							"project_id": projectID,
+							"request_id": requestID,
+							"error":      err.Error(),
+							"timed_out":  errors.Is(err, ErrRunTimeout),
+							"canceled":   errors.Is(err, ErrRunCanceled),
+							"command":    command,
+						})
+					}
 					return // Channel closed
 				}
-				
+
 				// Accumulate output for session
 				streamedOutput.WriteString(output)
-				
+
 				// Send streamed output
 				s.sendMessage(conn, "claude_stream_output", map[string]interface{}{
 					"project_id": projectID,
+					"request_id": requestID,
 					"output":     output,
 					"command":    command,
 				})
-				
+
 			case err, ok := <-errorChan:
 				if !ok {
 					return // Channel closed
 				}
-				
+
 				if err != nil {
 					streamError = err
 					s.sendMessage(conn, "claude_stream_error", map[string]interface{}{
 						"project_id": projectID,
+						"request_id": requestID,
 						"error":      err.Error(),
+						"timed_out":  errors.Is(err, ErrRunTimeout),
+						"canceled":   errors.Is(err, ErrRunCanceled),
 						"command":    command,
 					})
 					return
@@ -915,10 +1455,323 @@ claude --stream "$(cat %s)" && rm %s`, tempFile, claudeCommand, tempFile, tempFi
 			}
 		}
 	}()
-	
+
 	log.Printf("✅ Started streaming Docker command in %s: %s", projectID, command)
 }
 
+// streamClaudePrompt runs a prompt through the project's AI backend and
+// forwards text deltas, tool activity and the final result as they arrive.
+// The project's Claude session is resumed when there is one; sessionContext
+// is only used when starting a new session. done is called once the run has
+// finished.
+func (s *Server) streamClaudePrompt(ctx context.Context, done func(), conn *websocket.Conn, projectID, requestID, command, sessionContext string) {
+	session := s.getOrCreateSession(projectID)
+	started := time.Now()
+	audit := s.auditContext(conn, AuditSourceClaude)
+
+	err := s.checkBudget(projectID)
+	var backend AIBackend
+	if err == nil {
+		backend, err = s.backendFor(projectID)
+	}
+	var events <-chan ClaudeEvent
+	if err == nil {
+		log.Printf("🌊 Streaming Claude events for %s (request %s)", projectID, requestID)
+		events, err = backend.Stream(ctx, AIRequest{Prompt: command, SessionContext: sessionContext, ResumeID: s.claudeSessionID(projectID)})
+	}
+	if err != nil {
+		done()
+		s.auditLog.Record(audit, projectID, command, nil, time.Since(started), err)
+		s.addMessageToSession(projectID, "assistant", "", command, fmt.Sprintf("Error: %s", err.Error()))
+		s.sendMessage(conn, "claude_stream_error", map[string]interface{}{
+			"project_id":      projectID,
+			"request_id":      requestID,
+			"error":           err.Error(),
+			"budget_exceeded": errors.Is(err, ErrBudgetExceeded),
+			"command":         command,
+		})
+		return
+	}
+
+	s.sendMessage(conn, "claude_stream_start", map[string]interface{}{
+		"session_id":    fmt.Sprintf("session_%s", projectID),
+		"language":      session.Language,
+		"message_count": len(session.MessageHistory),
+		"project_id":    projectID,
+		"request_id":    requestID,
+		"command":       command,
+	})
+
+	go func() {
+		defer done()
+		var streamedOutput strings.Builder
+		var streamError string
+		var usage *UsageRecord
+
+		for event := range events {
+			switch event.Type {
+			case ClaudeEventInit:
+				s.setClaudeSessionID(projectID, event.SessionID)
+
+			case ClaudeEventText:
+				streamedOutput.WriteString(event.Text)
+				s.sendMessage(conn, "claude_stream_output", map[string]interface{}{
+					"project_id": projectID,
+					"request_id": requestID,
+					"output":     event.Text,
+					"command":    command,
+				})
+
+			case ClaudeEventToolUse:
+				s.sendMessage(conn, "claude_stream_tool_use", map[string]interface{}{
+					"project_id":  projectID,
+					"request_id":  requestID,
+					"command":     command,
+					"tool_use_id": event.ToolUseID,
+					"tool_name":   event.ToolName,
+					"tool_input":  event.ToolInput,
+				})
+
+			case ClaudeEventToolResult:
+				s.sendMessage(conn, "claude_stream_tool_result", map[string]interface{}{
+					"project_id":  projectID,
+					"request_id":  requestID,
+					"command":     command,
+					"tool_use_id": event.ToolUseID,
+					"output":      event.ToolOutput,
+					"is_error":    event.IsError,
+				})
+
+			case ClaudeEventResult:
+				s.setClaudeSessionID(projectID, event.Result.SessionID)
+				usage = newUsageRecord(event.Result)
+				s.recordUsage(projectID, usage)
+				if event.Result.Text != "" {
+					streamedOutput.Reset()
+					streamedOutput.WriteString(event.Result.Text)
+				}
+				if event.Result.IsError {
+					streamError = event.Result.Text
+				}
+				s.sendMessage(conn, "claude_stream_result", map[string]interface{}{
+					"project_id": projectID,
+					"request_id": requestID,
+					"command":    command,
+					"result":     event.Result,
+				})
+
+			case ClaudeEventError:
+				streamError = event.Error
+				s.sendMessage(conn, "claude_stream_error", map[string]interface{}{
+					"project_id": projectID,
+					"request_id": requestID,
+					"error":      event.Error,
+					"timed_out":  errors.Is(event.Err, ErrRunTimeout),
+					"canceled":   errors.Is(event.Err, ErrRunCanceled),
+					"command":    command,
+				})
+			}
+		}
+
+		if streamError != "" {
+			s.auditLog.Record(audit, projectID, command, exitCodeOf(1), time.Since(started), errors.New(streamError))
+			s.addMessageWithUsage(projectID, "assistant", "", command, fmt.Sprintf("Error: %s", streamError), usage)
+		} else {
+			s.auditLog.Record(audit, projectID, command, exitCodeOf(0), time.Since(started), nil)
+			s.addMessageWithUsage(projectID, "assistant", "", command, streamedOutput.String(), usage)
+		}
+		if usage != nil {
+			s.sendBudgetWarnings(conn, projectID)
+		}
+
+		s.sendMessage(conn, "claude_stream_end", map[string]interface{}{
+			"project_id": projectID,
+			"request_id": requestID,
+			"command":    command,
+		})
+	}()
+}
+
+// maxFileTransferBytes caps a single file_upload / file_download payload
+const maxFileTransferBytes = 16 << 20
+
+func (s *Server) handleFileUpload(conn *websocket.Conn, msg map[string]interface{}) {
+	log.Printf("📤 Handling file upload request")
+
+	data, ok := msg["data"].(map[string]interface{})
+	if !ok {
+		s.sendError(conn, "Invalid file upload message format")
+		return
+	}
+
+	projectID, ok := data["project_id"].(string)
+	if !ok || projectID == "" {
+		s.sendError(conn, "Missing or invalid project ID")
+		return
+	}
+
+	filePath, ok := data["path"].(string)
+	if !ok || filePath == "" {
+		s.sendError(conn, "Missing file path")
+		return
+	}
+
+	encoded, _ := data["content_base64"].(string)
+	content, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		s.sendError(conn, fmt.Sprintf("Invalid base64 content: %v", err))
+		return
+	}
+	if len(content) > maxFileTransferBytes {
+		s.sendError(conn, fmt.Sprintf("File too large: %d bytes (max %d)", len(content), maxFileTransferBytes))
+		return
+	}
+
+	var target string
+	if mode, _ := data["mode"].(string); mode == "append" {
+		target, err = s.dockerManager.AppendFile(projectID, filePath, content)
+	} else {
+		target, err = s.dockerManager.WriteFile(projectID, filePath, content)
+	}
+	if err != nil {
+		s.sendError(conn, fmt.Sprintf("Failed to upload file: %v", err))
+		return
+	}
+
+	s.sendMessage(conn, "file_upload_response", map[string]interface{}{
+		"project_id": projectID,
+		"path":       target,
+		"size":       len(content),
+		"status":     "success",
+	})
+}
+
+func (s *Server) handleFileDownload(conn *websocket.Conn, msg map[string]interface{}) {
+	log.Printf("📥 Handling file download request")
+
+	data, ok := msg["data"].(map[string]interface{})
+	if !ok {
+		s.sendError(conn, "Invalid file download message format")
+		return
+	}
+
+	projectID, ok := data["project_id"].(string)
+	if !ok || projectID == "" {
+		s.sendError(conn, "Missing or invalid project ID")
+		return
+	}
+
+	filePath, ok := data["path"].(string)
+	if !ok || filePath == "" {
+		s.sendError(conn, "Missing file path")
+		return
+	}
+
+	// Optional byte range; JSON numbers arrive as float64
+	var offset, length int64
+	if v, ok := data["offset"].(float64); ok {
+		offset = int64(v)
+	}
+	if v, ok := data["length"].(float64); ok {
+		length = int64(v)
+	}
+	if length <= 0 || length > maxFileTransferBytes {
+		length = maxFileTransferBytes
+	}
+
+	content, err := s.dockerManager.ReadFile(projectID, filePath, offset, length)
+	if err != nil {
+		s.sendError(conn, fmt.Sprintf("Failed to download file: %v", err))
+		return
+	}
+
+	s.sendMessage(conn, "file_download_response", map[string]interface{}{
+		"project_id":     projectID,
+		"path":           content.Path,
+		"offset":         content.Offset,
+		"length":         content.Length,
+		"size":           content.Size,
+		"eof":            content.Offset+content.Length >= content.Size,
+		"content_base64": base64.StdEncoding.EncodeToString(content.Data),
+		"status":         "success",
+	})
+}
+
+func (s *Server) handleWorkspaceTree(conn *websocket.Conn, msg map[string]interface{}) {
+	log.Printf("🌳 Handling workspace tree request")
+
+	data, ok := msg["data"].(map[string]interface{})
+	if !ok {
+		s.sendError(conn, "Invalid workspace tree message format")
+		return
+	}
+
+	projectID, ok := data["project_id"].(string)
+	if !ok || projectID == "" {
+		s.sendError(conn, "Missing or invalid project ID")
+		return
+	}
+
+	dir, _ := data["path"].(string)
+	if dir == "" {
+		dir = "."
+	}
+	depth := 0
+	if v, ok := data["depth"].(float64); ok {
+		depth = int(v)
+	}
+
+	tree, err := s.dockerManager.WorkspaceTree(projectID, dir, depth)
+	if err != nil {
+		s.sendError(conn, fmt.Sprintf("Failed to list workspace: %v", err))
+		return
+	}
+
+	s.sendMessage(conn, "workspace_tree_response", map[string]interface{}{
+		"project_id": projectID,
+		"tree":       tree,
+		"status":     "success",
+	})
+}
+
+func (s *Server) handleWorkspaceSearch(conn *websocket.Conn, msg map[string]interface{}) {
+	log.Printf("🔍 Handling workspace search request")
+
+	data, ok := msg["data"].(map[string]interface{})
+	if !ok {
+		s.sendError(conn, "Invalid workspace search message format")
+		return
+	}
+
+	projectID, ok := data["project_id"].(string)
+	if !ok || projectID == "" {
+		s.sendError(conn, "Missing or invalid project ID")
+		return
+	}
+
+	// Parse search request
+	searchData, _ := json.Marshal(data)
+	var searchReq WorkspaceSearchRequest
+	if err := json.Unmarshal(searchData, &searchReq); err != nil {
+		s.sendError(conn, fmt.Sprintf("Failed to parse search request: %v", err))
+		return
+	}
+
+	result, err := s.dockerManager.SearchWorkspace(projectID, searchReq)
+	if err != nil {
+		s.sendError(conn, fmt.Sprintf("Failed to search workspace: %v", err))
+		return
+	}
+
+	s.sendMessage(conn, "workspace_search_response", map[string]interface{}{
+		"project_id": projectID,
+		"query":      searchReq.Query,
+		"glob":       searchReq.Glob,
+		"result":     result,
+		"status":     "success",
+	})
+}
+
 func (s *Server) handleClaudeExecute(conn *websocket.Conn, msg map[string]interface{}) {
 	data, ok := msg["data"].(map[string]interface{})
 	if !ok {
@@ -950,12 +1803,12 @@ func (s *Server) handleClaudeExecute(conn *websocket.Conn, msg map[string]interf
 			cmd = exec.Command("claude", claudeArgs[1:]...)
 		}
 		log.Printf("🤖 Executing Claude CLI: %v", cmd.Args)
-	} else if strings.HasPrefix(command, "/") || 
-			  strings.HasPrefix(command, "ls") || 
-			  strings.HasPrefix(command, "pwd") || 
-			  strings.HasPrefix(command, "cat") || 
-			  strings.HasPrefix(command, "echo") ||
-			  strings.HasPrefix(command, "git") {
+	} else if strings.HasPrefix(command, "/") ||
+		strings.HasPrefix(command, "ls") ||
+		strings.HasPrefix(command, "pwd") ||
+		strings.HasPrefix(command, "cat") ||
+		strings.HasPrefix(command, "echo") ||
+		strings.HasPrefix(command, "git") {
 		// Execute shell command
 		cmd = exec.Command("sh", "-c", command)
 		log.Printf("🔧 Executing shell command: %s", command)
@@ -1008,20 +1861,100 @@ func (s *Server) sendMessage(conn *websocket.Conn, msgType string, data interfac
 		"data": data,
 	}
 	log.Printf("📤 Attempting to send message type: %s", msgType)
-	
+
 	// Log the actual JSON content for debugging
 	jsonBytes, _ := json.Marshal(msg)
 	previewLen := 300
 	if len(jsonBytes) < previewLen {
 		previewLen = len(jsonBytes)
 	}
-	log.Printf("📤 JSON content preview: %s", string(jsonBytes)[:previewLen])
-	
-	if err := conn.WriteJSON(msg); err != nil {
-		log.Printf("❌ Failed to send WebSocket message: %v", err)
-	} else {
-		log.Printf("✅ Successfully sent WebSocket message type: %s", msgType)
+	log.Printf("📤 JSON content preview: %s", string(jsonBytes)[:previewLen])
+
+	if err := s.writeJSON(conn, msg); err != nil {
+		log.Printf("❌ Failed to send WebSocket message: %v", err)
+	} else {
+		log.Printf("✅ Successfully sent WebSocket message type: %s", msgType)
+	}
+}
+
+// writeJSON serialises writes to conn; handlers may reply from several goroutines
+func (s *Server) writeJSON(conn *websocket.Conn, v interface{}) error {
+	lock, _ := s.writeLocks.LoadOrStore(conn, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	mu.Lock()
+	defer mu.Unlock()
+	return conn.WriteJSON(v)
+}
+
+// activeRun is an in-flight request that claude_cancel can abort
+type activeRun struct {
+	cancel context.CancelFunc
+}
+
+// startRun registers a cancellable run under requestID, generating an ID when
+// the client did not send one. done must be called when the run ends.
+func (s *Server) startRun(requestID string) (context.Context, string, func()) {
+	if requestID == "" {
+		requestID = "req_" + newRunID()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &activeRun{cancel: cancel}
+
+	s.runsMutex.Lock()
+	if previous, exists := s.runs[requestID]; exists {
+		// A reused ID takes over; the older run can no longer be addressed
+		previous.cancel()
+	}
+	s.runs[requestID] = run
+	s.runsMutex.Unlock()
+
+	done := func() {
+		s.runsMutex.Lock()
+		// Only remove our own entry; the ID may have been reused
+		if s.runs[requestID] == run {
+			delete(s.runs, requestID)
+		}
+		s.runsMutex.Unlock()
+		cancel()
+	}
+	return ctx, requestID, done
+}
+
+// cancelRun cancels an in-flight run and reports whether it existed
+func (s *Server) cancelRun(requestID string) bool {
+	s.runsMutex.Lock()
+	run, exists := s.runs[requestID]
+	delete(s.runs, requestID)
+	s.runsMutex.Unlock()
+
+	if exists {
+		run.cancel()
+	}
+	return exists
+}
+
+// handleClaudeCancel aborts the run started with the given request_id
+func (s *Server) handleClaudeCancel(conn *websocket.Conn, msg map[string]interface{}) {
+	data, ok := msg["data"].(map[string]interface{})
+	if !ok {
+		s.sendError(conn, "Invalid cancel message format")
+		return
+	}
+
+	requestID, ok := data["request_id"].(string)
+	if !ok || requestID == "" {
+		s.sendError(conn, "Missing request ID")
+		return
+	}
+
+	canceled := s.cancelRun(requestID)
+	if canceled {
+		log.Printf("🛑 Cancelled Claude request %s", requestID)
 	}
+	s.sendMessage(conn, "claude_cancel_response", map[string]interface{}{
+		"request_id": requestID,
+		"canceled":   canceled,
+	})
 }
 
 func (s *Server) sendError(conn *websocket.Conn, errMsg string) {
@@ -1056,17 +1989,17 @@ func (s *Server) openBrowser(url string) {
 func isNaturalLanguageCommand(command string) bool {
 	command = strings.TrimSpace(command)
 	commandLower := strings.ToLower(command)
-	
+
 	// Empty command
 	if command == "" {
 		return false
 	}
-	
+
 	// First check for Japanese characters - if found, it's definitely natural language
 	if containsJapanese(command) {
 		return true
 	}
-	
+
 	// Check if it starts with clear Linux/shell commands (priority check)
 	shellCommands := []string{
 		// Basic Unix commands
@@ -1074,23 +2007,23 @@ func isNaturalLanguageCommand(command string) bool {
 		"mkdir", "rmdir", "rm", "cp", "mv", "chmod", "chown", "chgrp", "ln", "touch", "file", "which", "whereis",
 		"ps", "top", "htop", "kill", "killall", "jobs", "bg", "fg", "nohup", "screen", "tmux",
 		"tar", "gzip", "gunzip", "zip", "unzip", "curl", "wget", "ssh", "scp", "rsync",
-		
-		// Programming language executables  
+
+		// Programming language executables
 		"python", "python3", "node", "npm", "npx", "yarn", "go", "cargo", "rustc", "gcc", "g++", "clang",
 		"java", "javac", "ruby", "php", "perl", "bash", "zsh", "sh", "csh", "tcsh",
-		
+
 		// Development tools
 		"git", "docker", "docker-compose", "kubectl", "helm", "terraform", "ansible",
 		"make", "cmake", "ninja", "bazel", "gradle", "maven", "ant",
-		
+
 		// System commands
 		"sudo", "su", "systemctl", "service", "crontab", "mount", "umount", "df", "du", "free", "uname",
 		"env", "export", "alias", "history", "man", "info", "help",
-		
+
 		// Text editors and viewers
 		"vim", "vi", "nano", "emacs", "less", "more", "pager",
 	}
-	
+
 	// Check path-like commands
 	pathPrefixes := []string{"./", "../", "/", "~/", "\\", ".\\"}
 	for _, prefix := range pathPrefixes {
@@ -1098,7 +2031,7 @@ func isNaturalLanguageCommand(command string) bool {
 			return false
 		}
 	}
-	
+
 	// Check for shell command prefixes
 	words := strings.Fields(commandLower)
 	if len(words) == 0 {
@@ -1110,7 +2043,7 @@ func isNaturalLanguageCommand(command string) bool {
 			return false
 		}
 	}
-	
+
 	// Check for shell-specific syntax patterns
 	shellPatterns := []string{
 		"|", "&&", "||", ";", ">", ">>", "<", "<<", "`", "$(", "${", "$(",
@@ -1121,12 +2054,12 @@ func isNaturalLanguageCommand(command string) bool {
 			return false
 		}
 	}
-	
+
 	// Check for variable assignments
 	if strings.Contains(command, "=") && !strings.Contains(command, " == ") && !strings.Contains(command, " != ") {
 		return false
 	}
-	
+
 	// Check for common natural language patterns (English)
 	englishPatterns := []string{
 		"create", "write", "generate", "make a", "build a", "help me", "can you", "please",
@@ -1142,7 +2075,7 @@ func isNaturalLanguageCommand(command string) bool {
 		"i want", "i need", "i would like", "could you", "would you", "should i",
 		"how do i", "how can i", "is it possible", "can i", "may i",
 	}
-	
+
 	// Check for Japanese natural language patterns
 	japanesePatterns := []string{
 		"つくって", "作って", "書いて", "かいて", "生成して", "せいせいして",
@@ -1156,37 +2089,37 @@ func isNaturalLanguageCommand(command string) bool {
 		"エラー", "えらー", "問題", "もんだい", "バグ", "ばぐ", "修正", "しゅうせい",
 		"どうやって", "どのように", "なぜ", "いつ", "どこで", "だれが", "どれが",
 	}
-	
+
 	// Check English patterns
 	for _, pattern := range englishPatterns {
 		if strings.Contains(commandLower, pattern) {
 			return true
 		}
 	}
-	
+
 	// Check Japanese patterns
 	for _, pattern := range japanesePatterns {
 		if strings.Contains(command, pattern) || strings.Contains(commandLower, pattern) {
 			return true
 		}
 	}
-	
+
 	// Check for question patterns
 	questionStarters := []string{"what", "how", "why", "when", "where", "who", "which", "can", "could", "would", "should", "is", "are", "do", "does", "did"}
 	questionEnders := []string{"?"}
-	
+
 	for _, starter := range questionStarters {
 		if strings.HasPrefix(commandLower, starter+" ") {
 			return true
 		}
 	}
-	
+
 	for _, ender := range questionEnders {
 		if strings.HasSuffix(command, ender) {
 			return true
 		}
 	}
-	
+
 	// Default behavior: if it contains spaces and doesn't match shell patterns, treat as natural language
 	if strings.Contains(command, " ") {
 		// Additional shell command patterns to exclude
@@ -1199,7 +2132,7 @@ func isNaturalLanguageCommand(command string) bool {
 				"docker run", "docker build", "docker exec", "docker ps", "docker images",
 				"python -m", "node -e", "go run", "cargo run", "cargo build",
 			}
-			
+
 			firstTwoWords := strings.Join(words[:2], " ")
 			for _, cmd := range combinedCommands {
 				if strings.HasPrefix(firstTwoWords, cmd) {
@@ -1209,7 +2142,7 @@ func isNaturalLanguageCommand(command string) bool {
 		}
 		return true
 	}
-	
+
 	// Single word commands - default to shell command unless it's clearly conversational
 	conversationalWords := []string{"hello", "hi", "hey", "thanks", "thank", "yes", "no", "ok", "okay"}
 	for _, word := range conversationalWords {
@@ -1217,7 +2150,7 @@ func isNaturalLanguageCommand(command string) bool {
 			return true
 		}
 	}
-	
+
 	return false
 }
 
@@ -1225,7 +2158,7 @@ func isNaturalLanguageCommand(command string) bool {
 func escapeQuotes(command string) string {
 	// Replace double quotes with escaped quotes
 	command = strings.ReplaceAll(command, "\"", "\\\"")
-	// Replace single quotes with escaped quotes  
+	// Replace single quotes with escaped quotes
 	command = strings.ReplaceAll(command, "'", "\\'")
 	return command
 }
@@ -1244,8 +2177,8 @@ func containsNonASCII(s string) bool {
 func containsJapanese(s string) bool {
 	for _, r := range s {
 		if (r >= 0x3040 && r <= 0x309F) || // Hiragana
-		   (r >= 0x30A0 && r <= 0x30FF) || // Katakana
-		   (r >= 0x4E00 && r <= 0x9FAF) {  // CJK Unified Ideographs (Kanji)
+			(r >= 0x30A0 && r <= 0x30FF) || // Katakana
+			(r >= 0x4E00 && r <= 0x9FAF) { // CJK Unified Ideographs (Kanji)
 			return true
 		}
 	}
@@ -1256,7 +2189,7 @@ func containsJapanese(s string) bool {
 func (s *Server) getOrCreateSession(projectID string) *ConversationSession {
 	s.sessionsMutex.Lock()
 	defer s.sessionsMutex.Unlock()
-	
+
 	session, exists := s.sessions[projectID]
 	if !exists {
 		session = &ConversationSession{
@@ -1272,54 +2205,91 @@ func (s *Server) getOrCreateSession(projectID string) *ConversationSession {
 	} else {
 		session.LastActivity = time.Now()
 	}
-	
+	s.idleManager.Touch(projectID)
+
 	return session
 }
 
 func (s *Server) addMessageToSession(projectID, role, content, command, output string) {
+	s.addMessageWithUsage(projectID, role, content, command, output, nil)
+}
+
+// addMessageWithUsage adds a message carrying the usage of the run behind it
+func (s *Server) addMessageWithUsage(projectID, role, content, command, output string, usage *UsageRecord) {
 	s.sessionsMutex.Lock()
 	defer s.sessionsMutex.Unlock()
-	
+
 	session := s.sessions[projectID]
 	if session == nil {
 		return
 	}
-	
+
 	message := ConversationMessage{
 		Role:      role,
 		Content:   content,
 		Timestamp: time.Now(),
 		Command:   command,
 		Output:    output,
+		Usage:     usage,
 	}
-	
+
 	session.MessageHistory = append(session.MessageHistory, message)
 	session.LastActivity = time.Now()
-	
+
 	// Keep only last 20 messages to avoid memory issues
 	if len(session.MessageHistory) > 20 {
 		session.MessageHistory = session.MessageHistory[len(session.MessageHistory)-20:]
 	}
-	
+
 	log.Printf("💬 Added %s message to session %s (total: %d messages)", role, projectID, len(session.MessageHistory))
 }
 
+// claudeSessionID returns the Claude CLI session to resume for a project, if any
+func (s *Server) claudeSessionID(projectID string) string {
+	s.sessionsMutex.RLock()
+	defer s.sessionsMutex.RUnlock()
+
+	if session := s.sessions[projectID]; session != nil {
+		return session.ClaudeSessionID
+	}
+	return ""
+}
+
+// setClaudeSessionID records the CLI session a run used so the next one resumes it
+func (s *Server) setClaudeSessionID(projectID, sessionID string) {
+	if sessionID == "" {
+		return
+	}
+
+	s.sessionsMutex.Lock()
+	defer s.sessionsMutex.Unlock()
+
+	session := s.sessions[projectID]
+	if session == nil || session.ClaudeSessionID == sessionID {
+		return
+	}
+	session.ClaudeSessionID = sessionID
+	log.Printf("💬 Project %s now resumes Claude session %s", projectID, sessionID)
+}
+
+// getSessionContext summarises recent messages as text. It is only pasted into
+// prompts when there is no Claude CLI session to resume.
 func (s *Server) getSessionContext(projectID string) string {
 	s.sessionsMutex.RLock()
 	defer s.sessionsMutex.RUnlock()
-	
+
 	session := s.sessions[projectID]
 	if session == nil || len(session.MessageHistory) == 0 {
 		return ""
 	}
-	
+
 	// Build context from recent messages
 	sessionContext := ""
 	recentMessages := session.MessageHistory
 	if len(recentMessages) > 5 {
 		recentMessages = recentMessages[len(recentMessages)-5:]
 	}
-	
+
 	for _, msg := range recentMessages {
 		if msg.Role == "user" {
 			sessionContext += fmt.Sprintf("Previous request: %s\n", msg.Content)
@@ -1328,7 +2298,7 @@ func (s *Server) getSessionContext(projectID string) string {
 			sessionContext += fmt.Sprintf("Previous result: %s\n", msg.Output)
 		}
 	}
-	
+
 	return sessionContext
 }
 
@@ -1336,18 +2306,18 @@ func (s *Server) detectLanguage(text string) string {
 	// Simple language detection based on character sets
 	hasJapanese := false
 	hasEnglish := false
-	
+
 	for _, r := range text {
 		if (r >= 0x3040 && r <= 0x309F) || // Hiragana
-		   (r >= 0x30A0 && r <= 0x30FF) || // Katakana
-		   (r >= 0x4E00 && r <= 0x9FAF) {  // CJK Unified Ideographs
+			(r >= 0x30A0 && r <= 0x30FF) || // Katakana
+			(r >= 0x4E00 && r <= 0x9FAF) { // CJK Unified Ideographs
 			hasJapanese = true
 		}
 		if (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') {
 			hasEnglish = true
 		}
 	}
-	
+
 	if hasJapanese {
 		return "ja"
 	}
@@ -1401,20 +2371,109 @@ func (s *Server) unregisterWebClient(clientID string) {
 	}
 }
 
+// addClient registers a phone connection
+func (s *Server) addClient(conn *websocket.Conn) {
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	s.clients[conn] = make(map[string]bool)
+}
+
+// removeClient forgets a closed phone connection
+func (s *Server) removeClient(conn *websocket.Conn) {
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	delete(s.clients, conn)
+}
+
+// watchProject records that conn works on projectID
+func (s *Server) watchProject(conn *websocket.Conn, projectID string) {
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+	if projects, ok := s.clients[conn]; ok {
+		projects[projectID] = true
+	}
+}
+
+// clientName identifies a phone connection in the audit log
+func clientName(conn *websocket.Conn) string {
+	return "ws:" + conn.RemoteAddr().String()
+}
+
+// auditContext describes a command the user sent over conn
+func (s *Server) auditContext(conn *websocket.Conn, source string) AuditContext {
+	return AuditContext{
+		UserID:   DefaultUserID,
+		Client:   clientName(conn),
+		Source:   source,
+		Decision: AuditDecisionNotRequired,
+	}
+}
+
+// isWatching reports whether conn works on projectID
+func (s *Server) isWatching(conn *websocket.Conn, projectID string) bool {
+	s.clientsMutex.RLock()
+	defer s.clientsMutex.RUnlock()
+	return s.clients[conn][projectID]
+}
+
+// projectClients returns the phone connections working on projectID. A
+// phone that reconnects finds what it missed through the pending lists.
+func (s *Server) projectClients(projectID string) []*websocket.Conn {
+	s.clientsMutex.RLock()
+	defer s.clientsMutex.RUnlock()
+
+	var watching []*websocket.Conn
+	for conn, projects := range s.clients {
+		if projects[projectID] {
+			watching = append(watching, conn)
+		}
+	}
+	return watching
+}
+
+// notifyProject sends an event to the phones working on projectID and
+// returns how many it reached. Web clients are not told: project events
+// carry diffs and commands of projects they never opened.
+func (s *Server) notifyProject(projectID, eventType string, data map[string]interface{}) int {
+	conns := s.projectClients(projectID)
+	for _, conn := range conns {
+		s.sendMessage(conn, eventType, data)
+	}
+	return len(conns)
+}
+
+// notifyColdStart tells the clients of a project that its container is
+// being started before their command runs
+func (s *Server) notifyColdStart(projectID, stage string, hibernated bool) {
+	message := "🚀 Starting project container..."
+	if hibernated {
+		message = "💤 Waking up hibernated project, this takes a few seconds..."
+	}
+	if stage == "ready" {
+		message = "✅ Project container is ready"
+	}
+	s.notifyProject(projectID, "project_cold_start", map[string]interface{}{
+		"project_id": projectID,
+		"stage":      stage,
+		"hibernated": hibernated,
+		"message":    message,
+	})
+}
+
 // Settings handler functions (placeholder implementations)
 func (s *Server) handleSettingsUpdate(conn *websocket.Conn, msg map[string]interface{}) {
 	log.Printf("🔧 Handling settings update request")
-	
+
 	data, ok := msg["data"].(map[string]interface{})
 	if !ok {
 		s.sendError(conn, "Invalid settings update message format")
 		return
 	}
-	
+
 	// For now, just acknowledge the settings update
 	// In a full implementation, you would persist these settings
 	log.Printf("📝 Settings data received: %+v", data)
-	
+
 	s.sendMessage(conn, "settings_update_response", map[string]interface{}{
 		"status":  "success",
 		"message": "Settings updated successfully",
@@ -1423,18 +2482,18 @@ func (s *Server) handleSettingsUpdate(conn *websocket.Conn, msg map[string]inter
 
 func (s *Server) handleSettingsGet(conn *websocket.Conn, msg map[string]interface{}) {
 	log.Printf("🔧 Handling settings get request")
-	
+
 	// Return default settings
 	// In a full implementation, you would load these from storage
 	defaultSettings := map[string]interface{}{
-		"claudeApiKey": "",
-		"gitUsername":  "RemoteClaude User",
-		"gitEmail":     "user@remoteclaude.dev",
-		"projectType":  "general",
-		"autoCommit":   true,
+		"claudeApiKey":  "",
+		"gitUsername":   "RemoteClaude User",
+		"gitEmail":      "user@remoteclaude.dev",
+		"projectType":   "general",
+		"autoCommit":    true,
 		"notifications": true,
 	}
-	
+
 	s.sendMessage(conn, "settings_get_response", map[string]interface{}{
 		"status":   "success",
 		"settings": defaultSettings,
@@ -1444,23 +2503,23 @@ func (s *Server) handleSettingsGet(conn *websocket.Conn, msg map[string]interfac
 // Conversation management handlers
 func (s *Server) handleConversationHistory(conn *websocket.Conn, msg map[string]interface{}) {
 	log.Printf("💬 Handling conversation history request")
-	
+
 	data, ok := msg["data"].(map[string]interface{})
 	if !ok {
 		s.sendError(conn, "Invalid conversation history message format")
 		return
 	}
-	
+
 	projectID, ok := data["project_id"].(string)
 	if !ok || projectID == "" {
 		s.sendError(conn, "Missing or invalid project ID")
 		return
 	}
-	
+
 	s.sessionsMutex.RLock()
 	session := s.sessions[projectID]
 	s.sessionsMutex.RUnlock()
-	
+
 	if session == nil {
 		s.sendMessage(conn, "conversation_history_response", map[string]interface{}{
 			"project_id": projectID,
@@ -1470,40 +2529,41 @@ func (s *Server) handleConversationHistory(conn *websocket.Conn, msg map[string]
 		})
 		return
 	}
-	
+
 	s.sendMessage(conn, "conversation_history_response", map[string]interface{}{
-		"project_id":     projectID,
-		"session_id":     fmt.Sprintf("session_%s", projectID),
-		"messages":       session.MessageHistory,
-		"language":       session.Language,
-		"created_at":     session.CreatedAt,
-		"last_activity":  session.LastActivity,
-		"message_count":  len(session.MessageHistory),
-		"status":         "success",
+		"project_id":        projectID,
+		"session_id":        fmt.Sprintf("session_%s", projectID),
+		"messages":          session.MessageHistory,
+		"language":          session.Language,
+		"created_at":        session.CreatedAt,
+		"last_activity":     session.LastActivity,
+		"message_count":     len(session.MessageHistory),
+		"claude_session_id": session.ClaudeSessionID,
+		"status":            "success",
 	})
 }
 
 func (s *Server) handleConversationClear(conn *websocket.Conn, msg map[string]interface{}) {
 	log.Printf("🧹 Handling conversation clear request")
-	
+
 	data, ok := msg["data"].(map[string]interface{})
 	if !ok {
 		s.sendError(conn, "Invalid conversation clear message format")
 		return
 	}
-	
+
 	projectID, ok := data["project_id"].(string)
 	if !ok || projectID == "" {
 		s.sendError(conn, "Missing or invalid project ID")
 		return
 	}
-	
+
 	s.sessionsMutex.Lock()
 	delete(s.sessions, projectID)
 	s.sessionsMutex.Unlock()
-	
+
 	log.Printf("🧹 Cleared conversation session for project: %s", projectID)
-	
+
 	s.sendMessage(conn, "conversation_clear_response", map[string]interface{}{
 		"project_id": projectID,
 		"status":     "success",
@@ -1513,49 +2573,54 @@ func (s *Server) handleConversationClear(conn *websocket.Conn, msg map[string]in
 
 func (s *Server) handleConversationContinue(conn *websocket.Conn, msg map[string]interface{}) {
 	log.Printf("🔄 Handling conversation continue request")
-	
+
 	data, ok := msg["data"].(map[string]interface{})
 	if !ok {
 		s.sendError(conn, "Invalid conversation continue message format")
 		return
 	}
-	
+
 	projectID, ok := data["project_id"].(string)
 	if !ok || projectID == "" {
 		s.sendError(conn, "Missing or invalid project ID")
 		return
 	}
-	
+
 	followUp, ok := data["follow_up"].(string)
 	if !ok || followUp == "" {
 		s.sendError(conn, "Missing follow-up message")
 		return
 	}
-	
-	// Use existing claude_execute flow but with enhanced context
+
+	// Use existing claude_execute flow; it resumes the project's Claude session
+	requestID, _ := data["request_id"].(string)
 	s.handleDockerClaudeExecute(conn, map[string]interface{}{
 		"type": "claude_execute",
 		"data": map[string]interface{}{
 			"project_id": projectID,
 			"command":    followUp,
+			"request_id": requestID,
 		},
 	})
 }
 
+// verifyAudit checks the audit log and exits instead of starting the server
+var verifyAudit = flag.Bool("verify-audit", false, "Verify the audit log's hash chain and exit")
+
 func getPortFromArgs() string {
 	// Command line flag
 	portFlag := flag.String("port", "", "Port to run server on (default: 8090)")
 	flag.Parse()
-	
+
 	// Priority: command line > environment variable > default
 	if *portFlag != "" {
 		return *portFlag
 	}
-	
+
 	if envPort := os.Getenv("REMOTECLAUDE_PORT"); envPort != "" {
 		return envPort
 	}
-	
+
 	return DefaultPort
 }
 
@@ -1563,8 +2628,8 @@ func getPortFromArgs() string {
 func (s *Server) autoApplyConfiguration(projectID, containerID string) {
 	log.Printf("🔧 Auto-applying configuration to project %s", projectID)
 
-	// Try to load default user configuration (using "default" as user ID for now)
-	userConfig, err := s.configManager.LoadUserConfig("default")
+	// Try to load default user configuration
+	userConfig, err := s.configManager.LoadUserConfig(DefaultUserID)
 	if err != nil {
 		log.Printf("⚠️ Failed to load user config for auto-apply: %v", err)
 		return
@@ -1572,7 +2637,7 @@ func (s *Server) autoApplyConfiguration(projectID, containerID string) {
 
 	// Create sync request
 	syncRequest := &ConfigSyncRequest{
-		UserID:          "default",
+		UserID:          DefaultUserID,
 		UserConfig:      userConfig,
 		TargetContainer: containerID,
 		SyncType:        "update",
@@ -1588,13 +2653,259 @@ func (s *Server) autoApplyConfiguration(projectID, containerID string) {
 	log.Printf("✅ Auto-applied configuration to %s: %v", projectID, response.Applied)
 }
 
-// sendPermissionRequest sends a permission request to the client
-func (s *Server) sendPermissionRequest(projectID string, permReq interface{}) error {
-	// TODO: Implement actual permission request sending
-	// For now, just return nil to allow compilation
+// sendPermissionRequest pushes a permission request to the phones working
+// on the project. Web clients are not told, as they cannot be tied to a
+// project. With nobody connected the request stays pending, so a phone that
+// reconnects can still find it via permission_pending_list.
+func (s *Server) sendPermissionRequest(projectID string, permReq *PermissionRequest) error {
+	reached := s.notifyProject(projectID, "permission_request", map[string]interface{}{
+		"project_id": projectID,
+		"request":    permReq,
+	})
+	if reached == 0 {
+		log.Printf("⚠️ No client working on %s; permission request %s stays pending", projectID, permReq.RequestID)
+	} else {
+		log.Printf("🔐 Sent permission request %s to %d client(s)", permReq.RequestID, reached)
+	}
+	return nil
+}
+
+// answerPermission applies a user's decision and tells the other clients of
+// the project that the prompt is gone. Late answers get ErrPermissionExpired.
+func (s *Server) answerPermission(resp *PermissionResponse) error {
+	if resp.Remember != "" && resp.Remember != PolicyScopeProject && resp.Remember != PolicyScopeUser {
+		return fmt.Errorf("invalid remember scope %q (want %q or %q)", resp.Remember, PolicyScopeProject, PolicyScopeUser)
+	}
+
+	req, exists := permissionManager.GetPendingRequests()[resp.RequestID]
+	if err := permissionManager.HandleResponse(resp); err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	if resp.Remember != "" {
+		rule, err := s.permissionPolicy.AddRule(DefaultUserID, req.ProjectID, resp.Remember, rememberedRule(req, resp))
+		if err != nil {
+			log.Printf("⚠️ Failed to remember permission decision %s: %v", resp.RequestID, err)
+		} else {
+			log.Printf("📜 Remembered %s rule %s for %s", rule.Effect, rule.ID, req.ProjectID)
+		}
+	}
+
+	if resp.Approved {
+		log.Printf("✅ Permission %s approved", resp.RequestID)
+	} else {
+		log.Printf("🚫 Permission %s denied", resp.RequestID)
+	}
+	s.notifyProject(req.ProjectID, "permission_resolved", map[string]interface{}{
+		"project_id": req.ProjectID,
+		"request_id": resp.RequestID,
+		"approved":   resp.Approved,
+	})
 	return nil
 }
 
+// pendingPermissions lists the unanswered requests, oldest first, optionally
+// for one project only
+func pendingPermissions(projectID string) []*PermissionRequest {
+	requests := []*PermissionRequest{}
+	for _, req := range permissionManager.GetPendingRequests() {
+		if projectID == "" || req.ProjectID == projectID {
+			requests = append(requests, req)
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Timestamp < requests[j].Timestamp
+	})
+	return requests
+}
+
+// handlePermissionResponse routes the user's answer to a permission request
+func (s *Server) handlePermissionResponse(conn *websocket.Conn, msg map[string]interface{}) {
+	data, ok := msg["data"].(map[string]interface{})
+	if !ok {
+		s.sendError(conn, "Invalid permission response format")
+		return
+	}
+
+	requestID, ok := data["request_id"].(string)
+	if !ok || requestID == "" {
+		s.sendError(conn, "Missing request ID")
+		return
+	}
+	approved, ok := data["approved"].(bool)
+	if !ok {
+		s.sendError(conn, "Missing approval decision")
+		return
+	}
+	comment, _ := data["user_comment"].(string)
+	remember, _ := data["remember"].(string)
+	rememberPattern, _ := data["remember_pattern"].(string)
+
+	// Only a phone working on the project may answer for it
+	if req, exists := permissionManager.GetPendingRequests()[requestID]; exists && !s.isWatching(conn, req.ProjectID) {
+		log.Printf("🚫 Permission answer for %s from %s, which is not working on %s", requestID, clientName(conn), req.ProjectID)
+		s.sendMessage(conn, "permission_response_ack", map[string]interface{}{
+			"request_id": requestID,
+			"accepted":   false,
+			"status":     "forbidden",
+			"message":    fmt.Sprintf("This connection is not working on project %s", req.ProjectID),
+		})
+		return
+	}
+
+	err := s.answerPermission(&PermissionResponse{
+		RequestID:       requestID,
+		Approved:        approved,
+		UserComment:     comment,
+		Remember:        remember,
+		RememberPattern: rememberPattern,
+	})
+
+	ack := map[string]interface{}{
+		"request_id": requestID,
+		"accepted":   err == nil,
+		"status":     "success",
+	}
+	switch {
+	case errors.Is(err, ErrPermissionExpired):
+		ack["status"] = "expired"
+		ack["message"] = "The request expired before the answer arrived; the operation was denied"
+	case errors.Is(err, ErrPermissionNotFound):
+		ack["status"] = "not_found"
+		ack["message"] = err.Error()
+	case err != nil:
+		ack["status"] = "error"
+		ack["message"] = err.Error()
+	}
+	s.sendMessage(conn, "permission_response_ack", ack)
+}
+
+// handlePermissionPendingList returns the unanswered permission requests,
+// for a client that reconnects while Claude is waiting on one
+func (s *Server) handlePermissionPendingList(conn *websocket.Conn, msg map[string]interface{}) {
+	data, _ := msg["data"].(map[string]interface{})
+	projectID, _ := data["project_id"].(string)
+
+	// Without a project, list the requests of every project conn works on
+	requests := []*PermissionRequest{}
+	for _, req := range pendingPermissions(projectID) {
+		if s.isWatching(conn, req.ProjectID) {
+			requests = append(requests, req)
+		}
+	}
+	s.sendMessage(conn, "permission_pending_list_response", map[string]interface{}{
+		"project_id": projectID,
+		"requests":   requests,
+		"count":      len(requests),
+		"status":     "success",
+	})
+}
+
+// handlePermissionRulesList returns the rules that apply to a project
+func (s *Server) handlePermissionRulesList(conn *websocket.Conn, msg map[string]interface{}) {
+	data, _ := msg["data"].(map[string]interface{})
+	projectID, _ := data["project_id"].(string)
+
+	project, user, err := s.permissionPolicy.Rules(DefaultUserID, projectID)
+	if err != nil {
+		s.sendError(conn, fmt.Sprintf("Failed to load permission rules: %v", err))
+		return
+	}
+	s.sendMessage(conn, "permission_rules_list_response", map[string]interface{}{
+		"project_id": projectID,
+		"project":    project,
+		"user":       user,
+		"status":     "success",
+	})
+}
+
+// handlePermissionRuleAdd stores a rule sent by the client
+func (s *Server) handlePermissionRuleAdd(conn *websocket.Conn, msg map[string]interface{}) {
+	data, ok := msg["data"].(map[string]interface{})
+	if !ok {
+		s.sendError(conn, "Invalid permission rule message format")
+		return
+	}
+	projectID, _ := data["project_id"].(string)
+	scope, _ := data["scope"].(string)
+
+	ruleData, _ := json.Marshal(data["rule"])
+	var rule PermissionRule
+	if err := json.Unmarshal(ruleData, &rule); err != nil {
+		s.sendError(conn, fmt.Sprintf("Invalid permission rule: %v", err))
+		return
+	}
+
+	added, err := s.permissionPolicy.AddRule(DefaultUserID, projectID, scope, rule)
+	if err != nil {
+		s.sendError(conn, fmt.Sprintf("Failed to add permission rule: %v", err))
+		return
+	}
+	s.sendMessage(conn, "permission_rule_add_response", map[string]interface{}{
+		"project_id": projectID,
+		"scope":      scope,
+		"rule":       added,
+		"status":     "success",
+	})
+}
+
+// handlePermissionRuleRemove deletes a rule
+func (s *Server) handlePermissionRuleRemove(conn *websocket.Conn, msg map[string]interface{}) {
+	data, ok := msg["data"].(map[string]interface{})
+	if !ok {
+		s.sendError(conn, "Invalid permission rule message format")
+		return
+	}
+	projectID, _ := data["project_id"].(string)
+	scope, _ := data["scope"].(string)
+	ruleID, _ := data["rule_id"].(string)
+
+	if err := s.permissionPolicy.RemoveRule(DefaultUserID, projectID, scope, ruleID); err != nil {
+		s.sendError(conn, fmt.Sprintf("Failed to remove permission rule: %v", err))
+		return
+	}
+	s.sendMessage(conn, "permission_rule_remove_response", map[string]interface{}{
+		"project_id": projectID,
+		"scope":      scope,
+		"rule_id":    ruleID,
+		"status":     "success",
+	})
+}
+
+// sendBudgetWarnings tells the client when a project's usage nears or passes its budget
+func (s *Server) sendBudgetWarnings(conn *websocket.Conn, projectID string) {
+	status := s.budgetStatus(projectID)
+	if len(status.Warnings) == 0 {
+		return
+	}
+	s.sendMessage(conn, "usage_budget_warning", map[string]interface{}{
+		"project_id": projectID,
+		"budget":     status,
+	})
+}
+
+// handleUsageReport returns usage totals and the current budget status
+func (s *Server) handleUsageReport(conn *websocket.Conn, msg map[string]interface{}) {
+	data, _ := msg["data"].(map[string]interface{})
+	q, err := usageQueryFromMap(func(key string) string {
+		value, _ := data[key].(string)
+		return value
+	})
+	if err != nil {
+		s.sendError(conn, err.Error())
+		return
+	}
+
+	s.sendMessage(conn, "usage_report_response", map[string]interface{}{
+		"report": s.usageTracker.Report(q),
+		"budget": s.budgetStatus(q.ProjectID),
+		"status": "success",
+	})
+}
+
 // Configuration management handlers
 
 func (s *Server) handleConfigSave(conn *websocket.Conn, msg map[string]interface{}) {
@@ -1628,8 +2939,8 @@ func (s *Server) handleConfigSave(conn *websocket.Conn, msg map[string]interface
 	}
 
 	s.sendMessage(conn, "config_save_response", map[string]interface{}{
-		"status":  "success",
-		"message": "Configuration saved successfully",
+		"status":    "success",
+		"message":   "Configuration saved successfully",
 		"config_id": userConfig.ID,
 	})
 }
@@ -1700,14 +3011,14 @@ func (s *Server) handleConfigSync(conn *websocket.Conn, msg map[string]interface
 				responses = append(responses, map[string]interface{}{
 					"container_id": project.ContainerID[:12],
 					"project_name": project.Name,
-					"response": response,
+					"response":     response,
 				})
 			}
 		}
 
 		s.sendMessage(conn, "config_sync_response", map[string]interface{}{
-			"status": "success",
-			"message": "Configuration sync completed",
+			"status":       "success",
+			"message":      "Configuration sync completed",
 			"sync_results": responses,
 		})
 	} else {
@@ -1719,7 +3030,7 @@ func (s *Server) handleConfigSync(conn *websocket.Conn, msg map[string]interface
 		}
 
 		s.sendMessage(conn, "config_sync_response", map[string]interface{}{
-			"status": "success",
+			"status":   "success",
 			"response": response,
 		})
 	}
@@ -1742,12 +3053,17 @@ func (s *Server) handleConfigQuickCommands(conn *websocket.Conn, msg map[string]
 
 	switch action {
 	case "get_defaults":
-		// Return default quick commands
+		// Return default quick commands, plus the project's own if asked
 		defaultCommands := GetDefaultQuickCommands()
+		if projectID, ok := data["project_id"].(string); ok && projectID != "" {
+			if containerConfig, err := s.configManager.LoadContainerConfig(projectID); err == nil {
+				defaultCommands = append(defaultCommands, containerConfig.Commands...)
+			}
+		}
 		s.sendMessage(conn, "config_quick_commands_response", map[string]interface{}{
-			"status": "success",
+			"status":   "success",
 			"commands": defaultCommands,
-			"message": "Default quick commands retrieved",
+			"message":  "Default quick commands retrieved",
 		})
 
 	case "save_custom":
@@ -1769,8 +3085,8 @@ func (s *Server) handleConfigQuickCommands(conn *websocket.Conn, msg map[string]
 		}
 
 		s.sendMessage(conn, "config_quick_commands_response", map[string]interface{}{
-			"status": "success",
-			"message": "Custom quick commands saved",
+			"status":   "success",
+			"message":  "Custom quick commands saved",
 			"commands": commands,
 		})
 
@@ -1801,13 +3117,13 @@ func (s *Server) handleQuickCommandExecute(conn *websocket.Conn, msg map[string]
 	}
 
 	// Load user configuration to get custom commands
-	userConfig, err := s.configManager.LoadUserConfig("default")
+	userConfig, err := s.configManager.LoadUserConfig(DefaultUserID)
 	if err != nil {
 		log.Printf("⚠️ Failed to load user config for quick commands: %v", err)
-		userConfig = s.configManager.getDefaultUserConfig("default")
+		userConfig = s.configManager.getDefaultUserConfig(DefaultUserID)
 	}
 
-	// Find the command
+	// Find the command, then fall back to the project's own commands
 	var targetCommand *QuickCommand
 	for _, cmd := range userConfig.QuickCommands {
 		if cmd.ID == commandID {
@@ -1815,6 +3131,16 @@ func (s *Server) handleQuickCommandExecute(conn *websocket.Conn, msg map[string]
 			break
 		}
 	}
+	if targetCommand == nil {
+		if containerConfig, err := s.configManager.LoadContainerConfig(projectID); err == nil {
+			for _, cmd := range containerConfig.Commands {
+				if cmd.ID == commandID {
+					targetCommand = &cmd
+					break
+				}
+			}
+		}
+	}
 
 	if targetCommand == nil {
 		s.sendError(conn, fmt.Sprintf("Quick command not found: %s", commandID))
@@ -1824,9 +3150,9 @@ func (s *Server) handleQuickCommandExecute(conn *websocket.Conn, msg map[string]
 	// Send confirmation request if required
 	if targetCommand.RequiresConfirmation {
 		s.sendMessage(conn, "quick_command_confirmation", map[string]interface{}{
-			"command": targetCommand,
+			"command":    targetCommand,
 			"project_id": projectID,
-			"message": fmt.Sprintf("Execute '%s'?", targetCommand.Name),
+			"message":    fmt.Sprintf("Execute '%s'?", targetCommand.Name),
 		})
 		return
 	}
@@ -1835,27 +3161,33 @@ func (s *Server) handleQuickCommandExecute(conn *websocket.Conn, msg map[string]
 	s.executeQuickCommand(conn, projectID, targetCommand)
 }
 
+// executeQuickCommand runs a quick command; commands that require
+// confirmation only get here once the user has confirmed them
 func (s *Server) executeQuickCommand(conn *websocket.Conn, projectID string, command *QuickCommand) {
 	log.Printf("🔧 Executing quick command '%s' in project %s", command.Name, projectID)
 
 	// Notify about command start
 	s.sendMessage(conn, "quick_command_started", map[string]interface{}{
-		"command_id": command.ID,
+		"command_id":   command.ID,
 		"command_name": command.Name,
-		"project_id": projectID,
+		"project_id":   projectID,
 	})
 
 	// Process special commands with enhanced features
 	processedCommand := s.processSpecialCommand(command.Command, projectID)
 
 	// Execute command in container
-	output, err := s.dockerManager.ExecuteCommand(projectID, processedCommand)
+	audit := s.auditContext(conn, AuditSourceQuickCommand)
+	if command.RequiresConfirmation {
+		audit.Decision, audit.DecidedBy = AuditDecisionConfirmed, "user"
+	}
+	output, err := s.dockerManager.ExecuteCommandAs(audit, projectID, processedCommand)
 
 	if err != nil {
 		s.sendMessage(conn, "quick_command_error", map[string]interface{}{
 			"command_id": command.ID,
-			"error": err.Error(),
-			"output": output,
+			"error":      err.Error(),
+			"output":     output,
 			"project_id": projectID,
 		})
 		return
@@ -1863,19 +3195,19 @@ func (s *Server) executeQuickCommand(conn *websocket.Conn, projectID string, com
 
 	// Send success response
 	s.sendMessage(conn, "quick_command_response", map[string]interface{}{
-		"command_id": command.ID,
+		"command_id":   command.ID,
 		"command_name": command.Name,
-		"output": output,
-		"project_id": projectID,
-		"status": "success",
-		"message": fmt.Sprintf("✅ Command '%s' executed successfully", command.Name),
+		"output":       output,
+		"project_id":   projectID,
+		"status":       "success",
+		"message":      fmt.Sprintf("✅ Command '%s' executed successfully", command.Name),
 	})
 
 	// Notify web interface about command execution
 	s.notifyWebClients("quick_command_executed", map[string]interface{}{
 		"project_id": projectID,
-		"command": command.Name,
-		"success": true,
+		"command":    command.Name,
+		"success":    true,
 	})
 
 	log.Printf("✅ Quick command '%s' completed in project %s", command.Name, projectID)
@@ -1911,19 +3243,39 @@ func (s *Server) processSpecialCommand(command, projectID string) string {
 	return processed
 }
 
-
 func main() {
 	// Get port from command line or environment
 	port := getPortFromArgs()
-	
+
+	if *verifyAudit {
+		result := NewAuditLog().Verify()
+		for _, problem := range result.Problems {
+			log.Printf("❌ %s", problem)
+		}
+		if !result.Valid {
+			log.Fatalf("❌ Audit log verification failed (%d entries checked)", result.Entries)
+		}
+		log.Printf("✅ Audit log intact: %d entries, last hash %s", result.Entries, result.LastHash)
+		return
+	}
+
 	log.Printf("🚀 Starting ClaudeOps Remote Server on port %s", port)
 	log.Printf("💡 Port options:")
 	log.Printf("   Command line: --port=9000")
 	log.Printf("   Environment:  REMOTECLAUDE_PORT=9000")
 	log.Printf("   Default:      %s", DefaultPort)
-	
+
 	server := NewServer(port)
 
+	// Expire permission requests nobody is waiting on any more
+	permissionManager.StartJanitor(time.Minute)
+
+	// Purge projects that stayed in the trash past their retention
+	server.dockerManager.StartTrashReaper(time.Hour, server.trashRetention)
+
+	// Hibernate projects nobody has used for a while
+	server.idleManager.Start(time.Minute)
+
 	// Generate and display QR code
 	connectionURL := server.generateQRCode()
 
@@ -1944,14 +3296,17 @@ func main() {
 		}
 		server.handleWebSocket(w, r)
 	})
-	
+
+	// PreToolUse hook calls from Claude runs, authenticated by per-run tokens
+	http.HandleFunc(permissionHookPath, server.handlePermissionHook)
+
 	// Serve QR code image
 	http.HandleFunc("/qr", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "./qr-code.png")
 	})
-	
+
 	// Note: static files are now served by the web interface on port 8080
-	
+
 	// Legacy web interface (fallback)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		html := fmt.Sprintf(`
@@ -2006,7 +3361,7 @@ func main() {
 		log.Printf("🏠 Local Mode: Binding to all interfaces (0.0.0.0:%s)", server.Port)
 		log.Printf("🌐 Local Access: ws://%s:%s/ws", server.getLocalIP(), server.Port)
 	}
-	
+
 	// Start server
 	log.Printf("🌐 Web interface: http://%s:8080", server.getLocalIP())
 	log.Printf("🎯 Ready for connections on %s...", bindAddr)
@@ -2014,4 +3369,4 @@ func main() {
 	if err := http.ListenAndServe(bindAddr, nil); err != nil {
 		log.Fatal("Server failed to start:", err)
 	}
-}
\ No newline at end of file
+}
@@ -1,21 +1,40 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
-	"regexp"
-	"time"
+	"log"
 	"sync"
+	"time"
 )
 
-// PermissionRequest represents a request for user permission
+// Errors for answers that cannot be delivered
+var (
+	ErrPermissionNotFound = errors.New("permission request not found")
+	ErrPermissionExpired  = errors.New("permission request expired")
+)
+
+// expiredRetention is how long expired request IDs are remembered, so a late
+// answer is told it expired rather than that the request never existed
+const expiredRetention = 10 * time.Minute
+
+// PermissionRequest represents a request for user permission to perform a
+// single tool call
 type PermissionRequest struct {
-	RequestID   string `json:"request_id"`
-	Action      string `json:"action"`      // "create_file", "modify_file", "delete_file", "execute_command"
-	Target      string `json:"target"`      // file name or command
-	Description string `json:"description"` // human readable description
-	Preview     string `json:"preview"`     // content preview (for files)
-	Timestamp   int64  `json:"timestamp"`
+	RequestID   string          `json:"request_id"`
+	ProjectID   string          `json:"project_id"`
+	Action      string          `json:"action"`      // "create_file", "modify_file", "execute_command" or "use_tool"
+	Target      string          `json:"target"`      // file path or command
+	Description string          `json:"description"` // human readable description
+	Preview     string          `json:"preview"`     // unified diff for file changes, the command line for Bash
+	Tool        string          `json:"tool"`        // CLI tool name, e.g. "Edit" or "Bash"
+	ToolInput   json.RawMessage `json:"tool_input"`  // exact arguments of the call
+	ToolUseID   string          `json:"tool_use_id,omitempty"`
+	SessionID   string          `json:"session_id,omitempty"` // Claude session making the call
+	Timestamp   int64           `json:"timestamp"`
+	ExpiresAt   int64           `json:"expires_at"` // unix time after which the call is denied
 }
 
 // PermissionResponse represents user's response to permission request
@@ -23,260 +42,189 @@ type PermissionResponse struct {
 	RequestID   string `json:"request_id"`
 	Approved    bool   `json:"approved"`
 	UserComment string `json:"user_comment"`
+
+	// "project" or "user" turns the decision into a PermissionRule of that
+	// scope, so matching calls are no longer asked about
+	Remember string `json:"remember,omitempty"`
+	// Path glob or command pattern for the remembered rule; defaults to the
+	// request's exact target
+	RememberPattern string `json:"remember_pattern,omitempty"`
+}
+
+// pendingPermission is a request waiting for the user. HandleResponse
+// completes result exactly once; the buffer lets it do so without a waiter.
+type pendingPermission struct {
+	req      *PermissionRequest
+	result   chan *PermissionResponse
+	deadline time.Time
 }
 
-// PermissionManager handles permission requests and responses
+// PermissionManager handles permission requests and responses. Each pending
+// request owns a result channel, so waiters block without polling and time
+// out through their context.
 type PermissionManager struct {
-	pendingRequests map[string]*PermissionRequest
-	responses       map[string]*PermissionResponse
-	mu              sync.RWMutex
-	timeout         time.Duration
+	pending map[string]*pendingPermission
+	expired map[string]time.Time // request ID -> when it expired
+	mu      sync.Mutex
+	timeout time.Duration
 }
 
 // NewPermissionManager creates a new permission manager
 func NewPermissionManager() *PermissionManager {
 	return &PermissionManager{
-		pendingRequests: make(map[string]*PermissionRequest),
-		responses:       make(map[string]*PermissionResponse),
-		timeout:         30 * time.Second, // 30 second timeout
+		pending: make(map[string]*pendingPermission),
+		expired: make(map[string]time.Time),
+		timeout: 30 * time.Second, // 30 second timeout
 	}
 }
 
-// DetectPermissionNeeded analyzes Claude's response to detect if permission is needed
-func (pm *PermissionManager) DetectPermissionNeeded(response string) *PermissionRequest {
-	response = strings.TrimSpace(response)
-	
-	// Pattern 1: File creation requests
-	if pm.containsFileCreation(response) {
-		filename, content := pm.extractFileInfo(response)
-		if filename != "" {
-			return &PermissionRequest{
-				RequestID:   pm.generateRequestID(),
-				Action:      "create_file",
-				Target:      filename,
-				Description: fmt.Sprintf("Create file: %s", filename),
-				Preview:     content,
-				Timestamp:   time.Now().Unix(),
-			}
-		}
-	}
-	
-	// Pattern 2: File modification requests
-	if pm.containsFileModification(response) {
-		filename := pm.extractFilename(response)
-		if filename != "" {
-			return &PermissionRequest{
-				RequestID:   pm.generateRequestID(),
-				Action:      "modify_file", 
-				Target:      filename,
-				Description: fmt.Sprintf("Modify file: %s", filename),
-				Preview:     "",
-				Timestamp:   time.Now().Unix(),
-			}
-		}
-	}
-	
-	// Pattern 3: Permission-related phrases
-	permissionPhrases := []string{
-		"I need permission",
-		"Would you like me to create",
-		"Should I create",
-		"Can I create",
-		"May I create",
-		"permission to create",
-		"create this as",
-	}
-	
-	responseLower := strings.ToLower(response)
-	for _, phrase := range permissionPhrases {
-		if strings.Contains(responseLower, phrase) {
-			filename := pm.extractFilenameFromPhrase(response)
-			content := pm.extractCodeBlock(response)
-			return &PermissionRequest{
-				RequestID:   pm.generateRequestID(),
-				Action:      "create_file",
-				Target:      filename,
-				Description: "Claude is requesting permission to create a file",
-				Preview:     content,
-				Timestamp:   time.Now().Unix(),
-			}
-		}
-	}
-	
-	return nil
+// generateRequestID generates a unique request ID
+func (pm *PermissionManager) generateRequestID() string {
+	return fmt.Sprintf("req_%d", time.Now().UnixNano())
 }
 
-// containsFileCreation checks if response contains file creation intent
-func (pm *PermissionManager) containsFileCreation(response string) bool {
-	patterns := []string{
-		`create.*\.py`,
-		`create.*\.js`, 
-		`create.*\.go`,
-		`create.*\.html`,
-		`save.*as.*\.`,
-		`write.*to.*\.`,
-	}
-	
-	responseLower := strings.ToLower(response)
-	for _, pattern := range patterns {
-		if matched, _ := regexp.MatchString(pattern, responseLower); matched {
-			return true
-		}
-	}
-	return false
-}
+// AddPendingRequest adds a permission request to pending list. The request
+// expires after the manager's timeout.
+func (pm *PermissionManager) AddPendingRequest(req *PermissionRequest) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
 
-// containsFileModification checks if response contains file modification intent
-func (pm *PermissionManager) containsFileModification(response string) bool {
-	patterns := []string{
-		`modify.*file`,
-		`update.*file`,
-		`edit.*file`,
-		`change.*in.*file`,
+	now := time.Now()
+	if req.Timestamp == 0 {
+		req.Timestamp = now.Unix()
 	}
-	
-	responseLower := strings.ToLower(response)
-	for _, pattern := range patterns {
-		if matched, _ := regexp.MatchString(pattern, responseLower); matched {
-			return true
-		}
+	deadline := now.Add(pm.timeout)
+	req.ExpiresAt = deadline.Unix()
+	pm.pending[req.RequestID] = &pendingPermission{
+		req:      req,
+		result:   make(chan *PermissionResponse, 1),
+		deadline: deadline,
 	}
-	return false
 }
 
-// extractFileInfo extracts filename and content from response
-func (pm *PermissionManager) extractFileInfo(response string) (filename, content string) {
-	// Extract filename
-	filename = pm.extractFilename(response)
-	
-	// Extract code content
-	content = pm.extractCodeBlock(response)
-	
-	return filename, content
+// RemovePendingRequest drops a request that can no longer be answered
+func (pm *PermissionManager) RemovePendingRequest(requestID string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	delete(pm.pending, requestID)
 }
 
-// extractFilename extracts filename from response
-func (pm *PermissionManager) extractFilename(response string) string {
-	// Common filename patterns
-	patterns := []string{
-		`([a-zA-Z0-9_-]+\.(py|js|go|html|css|txt|md))`,
-		`create.*?([a-zA-Z0-9_-]+\.[a-zA-Z0-9]+)`,
-		`save.*?as.*?([a-zA-Z0-9_-]+\.[a-zA-Z0-9]+)`,
-	}
-	
-	for _, pattern := range patterns {
-		re := regexp.MustCompile(pattern)
-		matches := re.FindStringSubmatch(response)
-		if len(matches) > 1 {
-			return matches[1]
-		}
-	}
-	
-	return ""
-}
+// HandleResponse delivers the user's answer to the waiting request. Answers
+// to requests that already timed out get ErrPermissionExpired.
+func (pm *PermissionManager) HandleResponse(resp *PermissionResponse) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
 
-// extractFilenameFromPhrase extracts filename from permission phrases
-func (pm *PermissionManager) extractFilenameFromPhrase(response string) string {
-	// Look for quoted filenames
-	re := regexp.MustCompile(`['"\x60]([a-zA-Z0-9_-]+\.[a-zA-Z0-9]+)['"\x60]`)
-	matches := re.FindStringSubmatch(response)
-	if len(matches) > 1 {
-		return matches[1]
+	p, exists := pm.pending[resp.RequestID]
+	if !exists {
+		return pm.missingLocked(resp.RequestID)
 	}
-	
-	// Fallback to common filename pattern
-	return pm.extractFilename(response)
+	delete(pm.pending, resp.RequestID)
+	p.result <- resp
+	return nil
 }
 
-// extractCodeBlock extracts code content from markdown code blocks
-func (pm *PermissionManager) extractCodeBlock(response string) string {
-	// Extract content between ```
-	re := regexp.MustCompile("```(?:[a-z]+)?\n?(.*?)\n?```")
-	matches := re.FindStringSubmatch(response)
-	if len(matches) > 1 {
-		return strings.TrimSpace(matches[1])
+// WaitForResponse blocks until the user answers, the request expires or ctx
+// is done. An expired request returns ErrPermissionExpired.
+func (pm *PermissionManager) WaitForResponse(ctx context.Context, requestID string) (*PermissionResponse, error) {
+	pm.mu.Lock()
+	p, exists := pm.pending[requestID]
+	if !exists {
+		err := pm.missingLocked(requestID)
+		pm.mu.Unlock()
+		return nil, err
 	}
-	
-	return ""
-}
+	pm.mu.Unlock()
 
-// generateRequestID generates a unique request ID
-func (pm *PermissionManager) generateRequestID() string {
-	return fmt.Sprintf("req_%d", time.Now().UnixNano())
-}
+	ctx, cancel := context.WithDeadline(ctx, p.deadline)
+	defer cancel()
 
-// AddPendingRequest adds a permission request to pending list
-func (pm *PermissionManager) AddPendingRequest(req *PermissionRequest) {
-	pm.mu.Lock()
-	defer pm.mu.Unlock()
-	pm.pendingRequests[req.RequestID] = req
+	select {
+	case resp := <-p.result:
+		return resp, nil
+	case <-ctx.Done():
+	}
+
+	pm.expire(requestID)
+	// The answer may have arrived just before the request was expired
+	select {
+	case resp := <-p.result:
+		return resp, nil
+	default:
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, ErrPermissionExpired
+	}
+	return nil, ctx.Err()
 }
 
-// HandleResponse handles user's permission response
-func (pm *PermissionManager) HandleResponse(resp *PermissionResponse) bool {
+// expire moves a request from pending to expired
+func (pm *PermissionManager) expire(requestID string) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
-	
-	if _, exists := pm.pendingRequests[resp.RequestID]; exists {
-		pm.responses[resp.RequestID] = resp
-		delete(pm.pendingRequests, resp.RequestID)
-		return true
+	if _, exists := pm.pending[requestID]; exists {
+		delete(pm.pending, requestID)
+		pm.expired[requestID] = time.Now()
 	}
-	
-	return false
 }
 
-// WaitForResponse waits for user response with timeout
-func (pm *PermissionManager) WaitForResponse(requestID string) (*PermissionResponse, bool) {
-	timeout := time.After(pm.timeout)
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
-	
-	for {
-		select {
-		case <-timeout:
-			// Remove pending request on timeout
-			pm.mu.Lock()
-			delete(pm.pendingRequests, requestID)
-			pm.mu.Unlock()
-			return nil, false
-			
-		case <-ticker.C:
-			pm.mu.RLock()
-			if resp, exists := pm.responses[requestID]; exists {
-				pm.mu.RUnlock()
-				// Clean up response after reading
-				pm.mu.Lock()
-				delete(pm.responses, requestID)
-				pm.mu.Unlock()
-				return resp, true
-			}
-			pm.mu.RUnlock()
-		}
+// missingLocked tells why requestID is not pending; pm.mu must be held
+func (pm *PermissionManager) missingLocked(requestID string) error {
+	if _, gone := pm.expired[requestID]; gone {
+		return ErrPermissionExpired
 	}
+	return ErrPermissionNotFound
 }
 
 // GetPendingRequests returns all pending permission requests
 func (pm *PermissionManager) GetPendingRequests() map[string]*PermissionRequest {
-	pm.mu.RLock()
-	defer pm.mu.RUnlock()
-	
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
 	result := make(map[string]*PermissionRequest)
-	for k, v := range pm.pendingRequests {
-		result[k] = v
+	for k, p := range pm.pending {
+		result[k] = p.req
 	}
 	return result
 }
 
-// CleanupExpiredRequests removes expired permission requests
+// CleanupExpiredRequests expires pending requests past their deadline that
+// nobody waits on any more, and forgets old expired request IDs
 func (pm *PermissionManager) CleanupExpiredRequests() {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
-	
-	now := time.Now().Unix()
-	for id, req := range pm.pendingRequests {
-		if now-req.Timestamp > int64(pm.timeout.Seconds()) {
-			delete(pm.pendingRequests, id)
+
+	now := time.Now()
+	for id, p := range pm.pending {
+		if now.After(p.deadline) {
+			delete(pm.pending, id)
+			pm.expired[id] = now
 		}
 	}
-}
\ No newline at end of file
+	for id, at := range pm.expired {
+		if now.Sub(at) > expiredRetention {
+			delete(pm.expired, id)
+		}
+	}
+}
+
+// StartJanitor runs CleanupExpiredRequests every interval until stop is called
+func (pm *PermissionManager) StartJanitor(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				pm.CleanupExpiredRequests()
+			case <-done:
+				return
+			}
+		}
+	}()
+	log.Printf("🧹 Permission janitor running every %v", interval)
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule effects
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+)
+
+// Rule scopes
+const (
+	PolicyScopeProject = "project" // stored in the project's ContainerConfiguration
+	PolicyScopeUser    = "user"    // stored in the UserConfiguration, applies to every project
+)
+
+// PermissionRule allows or denies matching tool calls without asking the
+// user. Every non-empty condition must match; within a condition any entry
+// may match. Paths only match file actions and Commands only match commands.
+// Allow rules with Commands never match a command line holding shell
+// control operators, so "git *" does not allow "git status && curl … | sh".
+type PermissionRule struct {
+	ID       string   `json:"id"`
+	Effect   string   `json:"effect"`             // "allow" or "deny"
+	Actions  []string `json:"actions,omitempty"`  // e.g. "modify_file"; empty matches every action
+	Paths    []string `json:"paths,omitempty"`    // globs relative to the workspace; "**" spans directories, a trailing "/" means everything below
+	Commands []string `json:"commands,omitempty"` // "*" wildcards over the whole command line, or "re:" followed by a regexp
+
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// Set on rules created from an "approve and remember" answer
+	FromRequest string `json:"from_request,omitempty"`
+}
+
+// Validate checks the rule's effect and patterns
+func (r *PermissionRule) Validate() error {
+	if r.Effect != PolicyAllow && r.Effect != PolicyDeny {
+		return fmt.Errorf("invalid rule effect %q (want %q or %q)", r.Effect, PolicyAllow, PolicyDeny)
+	}
+	for _, p := range r.Paths {
+		if _, err := path.Match(strings.Replace(p, "**", "*", -1), ""); err != nil {
+			return fmt.Errorf("invalid path glob %q: %v", p, err)
+		}
+	}
+	for _, c := range r.Commands {
+		if _, err := commandPattern(c); err != nil {
+			return fmt.Errorf("invalid command pattern %q: %v", c, err)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the rule covers req. root is the workspace the
+// call runs in; path globs match the target relative to it.
+func (r *PermissionRule) Matches(req *PermissionRequest, root string) bool {
+	if len(r.Actions) > 0 && !containsString(r.Actions, req.Action) {
+		return false
+	}
+
+	if len(r.Paths) > 0 {
+		if req.Action != "create_file" && req.Action != "modify_file" {
+			return false
+		}
+		if !matchAnyPath(r.Paths, req.Target, root) {
+			return false
+		}
+	}
+
+	if len(r.Commands) > 0 {
+		if req.Action != "execute_command" {
+			return false
+		}
+		if r.Effect == PolicyAllow && hasShellControl(req.Target) {
+			return false
+		}
+		matched := false
+		for _, c := range r.Commands {
+			if re, err := commandPattern(c); err == nil && re.MatchString(req.Target) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// PolicyDecision is the outcome of evaluating the rules for a request
+type PolicyDecision struct {
+	Effect string          // "allow", "deny", or empty when the user has to be asked
+	Rule   *PermissionRule // the deciding rule
+	Scope  string          // scope of the deciding rule
+}
+
+// PermissionPolicy evaluates the project and user rules stored by the
+// ConfigManager. A matching deny rule wins over any allow rule.
+type PermissionPolicy struct {
+	configManager *ConfigManager
+	mu            sync.Mutex // serialises rule updates
+}
+
+// NewPermissionPolicy creates a policy engine over the stored rules
+func NewPermissionPolicy(cm *ConfigManager) *PermissionPolicy {
+	return &PermissionPolicy{configManager: cm}
+}
+
+// Rules returns the project's and the user's rules
+func (p *PermissionPolicy) Rules(userID, projectID string) (project, user []PermissionRule, err error) {
+	if projectID != "" {
+		containerConfig, err := p.configManager.LoadContainerConfig(projectID)
+		if err != nil {
+			return nil, nil, err
+		}
+		project = containerConfig.PermissionRules
+	}
+	userConfig, err := p.configManager.LoadUserConfig(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return project, userConfig.PermissionRules, nil
+}
+
+// Evaluate decides req from the rules, if any of them covers it
+func (p *PermissionPolicy) Evaluate(userID string, req *PermissionRequest, root string) (PolicyDecision, error) {
+	project, user, err := p.Rules(userID, req.ProjectID)
+	if err != nil {
+		return PolicyDecision{}, err
+	}
+
+	var allow PolicyDecision
+	for _, scoped := range []struct {
+		scope string
+		rules []PermissionRule
+	}{{PolicyScopeProject, project}, {PolicyScopeUser, user}} {
+		for i := range scoped.rules {
+			rule := &scoped.rules[i]
+			if !rule.Matches(req, root) {
+				continue
+			}
+			if rule.Effect == PolicyDeny {
+				return PolicyDecision{Effect: PolicyDeny, Rule: rule, Scope: scoped.scope}, nil
+			}
+			if allow.Rule == nil {
+				allow = PolicyDecision{Effect: PolicyAllow, Rule: rule, Scope: scoped.scope}
+			}
+		}
+	}
+	return allow, nil
+}
+
+// AddRule validates rule and stores it in the given scope
+func (p *PermissionPolicy) AddRule(userID, projectID, scope string, rule PermissionRule) (*PermissionRule, error) {
+	if rule.ID == "" {
+		rule.ID = fmt.Sprintf("rule_%d", time.Now().UnixNano())
+	}
+	if rule.CreatedAt.IsZero() {
+		rule.CreatedAt = time.Now()
+	}
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+
+	err := p.update(userID, projectID, scope, func(rules []PermissionRule) []PermissionRule {
+		return append(rules, rule)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// RemoveRule deletes a rule from the given scope
+func (p *PermissionPolicy) RemoveRule(userID, projectID, scope, ruleID string) error {
+	found := false
+	err := p.update(userID, projectID, scope, func(rules []PermissionRule) []PermissionRule {
+		kept := rules[:0]
+		for _, r := range rules {
+			if r.ID == ruleID {
+				found = true
+				continue
+			}
+			kept = append(kept, r)
+		}
+		return kept
+	})
+	if err == nil && !found {
+		return fmt.Errorf("permission rule %s not found in %s scope", ruleID, scope)
+	}
+	return err
+}
+
+// update rewrites the rules of one scope
+func (p *PermissionPolicy) update(userID, projectID, scope string, change func([]PermissionRule) []PermissionRule) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch scope {
+	case PolicyScopeProject:
+		if projectID == "" {
+			return fmt.Errorf("project scope needs a project ID")
+		}
+		config, err := p.configManager.LoadContainerConfig(projectID)
+		if err != nil {
+			return err
+		}
+		config.PermissionRules = change(config.PermissionRules)
+		return p.configManager.SaveContainerConfig(config)
+
+	case PolicyScopeUser:
+		config, err := p.configManager.LoadUserConfig(userID)
+		if err != nil {
+			return err
+		}
+		config.PermissionRules = change(config.PermissionRules)
+		return p.configManager.SaveUserConfig(config)
+
+	default:
+		return fmt.Errorf("invalid rule scope %q (want %q or %q)", scope, PolicyScopeProject, PolicyScopeUser)
+	}
+}
+
+// rememberedRule turns an answered request into a rule covering the same
+// call, or the pattern the user picked instead
+func rememberedRule(req *PermissionRequest, resp *PermissionResponse) PermissionRule {
+	rule := PermissionRule{
+		Effect:      PolicyDeny,
+		Actions:     []string{req.Action},
+		Comment:     resp.UserComment,
+		FromRequest: req.RequestID,
+	}
+	if resp.Approved {
+		rule.Effect = PolicyAllow
+	}
+
+	pattern := resp.RememberPattern
+	if pattern == "" {
+		pattern = req.Target
+	}
+	switch req.Action {
+	case "create_file", "modify_file":
+		// A rule for a path covers creating and changing it alike
+		rule.Actions = []string{"create_file", "modify_file"}
+		rule.Paths = []string{pattern}
+	case "execute_command":
+		rule.Commands = []string{pattern}
+	}
+	return rule
+}
+
+// matchAnyPath matches the cleaned target, and the target relative to root,
+// against globs. Relative names that climb out of root match nothing, so
+// "src/**" does not cover "src/../../home/claude/.bashrc".
+func matchAnyPath(globs []string, target, root string) bool {
+	target = path.Clean(filepath.ToSlash(target))
+	var candidates []string
+	if path.IsAbs(target) {
+		candidates = append(candidates, target)
+		if root != "" {
+			if rel, err := filepath.Rel(root, filepath.FromSlash(target)); err == nil && !escapesRoot(filepath.ToSlash(rel)) {
+				candidates = append(candidates, filepath.ToSlash(rel))
+			}
+		}
+	} else if !escapesRoot(target) {
+		candidates = append(candidates, target)
+	}
+	for _, glob := range globs {
+		for _, candidate := range candidates {
+			if matchPathGlob(glob, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchPathGlob matches a slash-separated name against a glob where "**"
+// matches any number of path segments
+func matchPathGlob(glob, name string) bool {
+	if strings.HasSuffix(glob, "/") {
+		glob += "**"
+	}
+	return matchSegments(strings.Split(strings.Trim(glob, "/"), "/"), strings.Split(strings.Trim(name, "/"), "/"))
+}
+
+func matchSegments(glob, name []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(glob[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(glob[0], name[0]); !ok {
+			return false
+		}
+		glob, name = glob[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// escapesRoot reports whether a cleaned relative name leaves its root
+func escapesRoot(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, "../")
+}
+
+// shellControlChars chain or redirect commands in a shell command line
+const shellControlChars = ";&|`<>\n\r"
+
+// hasShellControl reports whether command does more than run one program:
+// lists (";", "&&", "||", "&"), pipes, command substitution ("`", "$("),
+// redirections or several lines. Quoting is not taken into account, so
+// such characters inside quotes count too.
+func hasShellControl(command string) bool {
+	return strings.ContainsAny(command, shellControlChars) || strings.Contains(command, "$(")
+}
+
+// commandPattern compiles a command pattern: "re:" introduces a regexp,
+// anything else matches the whole command line with "*" as a wildcard
+func commandPattern(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, "re:") {
+		return regexp.Compile(strings.TrimPrefix(pattern, "re:"))
+	}
+	parts := strings.Split(strings.TrimSpace(pattern), "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.Compile(`^\s*` + strings.Join(parts, ".*") + `\s*$`)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Distinct errors for runs stopped by their context
+var (
+	ErrRunTimeout  = errors.New("timed out")
+	ErrRunCanceled = errors.New("canceled")
+)
+
+// containerRunEnv marks every process of a run inside a container so the
+// whole tree can be killed; closing the exec stream alone leaves the
+// command running in the container
+const containerRunEnv = "REMOTECLAUDE_RUN"
+
+// processKillGrace bounds how long Wait keeps reading pipes after a kill
+const processKillGrace = 5 * time.Second
+
+var runCounter uint64
+
+// newRunID returns a process-unique identifier for a run
+func newRunID() string {
+	return fmt.Sprintf("%d-%d", time.Now().UnixNano(), atomic.AddUint64(&runCounter, 1))
+}
+
+// runContextError maps a finished context to ErrRunTimeout or ErrRunCanceled,
+// or returns nil while the context is still live
+func runContextError(ctx context.Context, timeout time.Duration) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		if timeout > 0 {
+			return fmt.Errorf("%w after %s", ErrRunTimeout, timeout)
+		}
+		return ErrRunTimeout
+	default:
+		return ErrRunCanceled
+	}
+}
+
+// killRunScript kills every process whose environment has $1=$2
+const killRunScript = `for p in /proc/[0-9]*; do ` +
+	`{ tr '\0' '\n' < "$p/environ"; } 2>/dev/null | grep -qx "$1=$2" && kill -9 "${p#/proc/}" 2>/dev/null; ` +
+	`done; true`
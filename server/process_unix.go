@@ -0,0 +1,22 @@
+//go:build !windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// configureProcessGroup starts cmd in its own process group so cancelling its
+// context kills every child, not just the direct process. onCancel, if set,
+// runs first.
+func configureProcessGroup(cmd *exec.Cmd, onCancel func()) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		if onCancel != nil {
+			onCancel()
+		}
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = processKillGrace
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package main
+
+import "os/exec"
+
+// configureProcessGroup kills the process when its context is cancelled.
+// Windows has no process groups to signal, so children may outlive it.
+func configureProcessGroup(cmd *exec.Cmd, onCancel func()) {
+	cmd.Cancel = func() error {
+		if onCancel != nil {
+			onCancel()
+		}
+		return cmd.Process.Kill()
+	}
+	cmd.WaitDelay = processKillGrace
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Labels stamped on every project container at creation. They are the
+// source of truth for project metadata, so a restarted server recovers
+// projects from Docker alone.
+const (
+	labelPrefix        = "com.remoteclaude."
+	labelSchemaVersion = labelPrefix + "schema-version"
+	labelProjectID     = labelPrefix + "project.id"
+	labelProjectName   = labelPrefix + "project.name"
+	labelProjectType   = labelPrefix + "project.type"
+	labelTemplate      = labelPrefix + "project.template"
+	labelCreator       = labelPrefix + "project.creator"
+	labelCreatedAt     = labelPrefix + "project.created-at"
+	labelConfig        = labelPrefix + "project.config" // JSON object
+	labelMemory        = labelPrefix + "resources.memory"
+	labelCPUs          = labelPrefix + "resources.cpus"
+)
+
+// projectLabelSchema is the version of the label layout written by
+// projectLabels
+const projectLabelSchema = 1
+
+// projectLabels returns the labels describing project
+func projectLabels(project *Project) map[string]string {
+	labels := map[string]string{
+		labelSchemaVersion: strconv.Itoa(projectLabelSchema),
+		labelProjectID:     project.ID,
+		labelProjectName:   project.Name,
+		labelProjectType:   project.Type,
+		labelTemplate:      project.Template,
+		labelCreator:       project.Creator,
+		labelCreatedAt:     project.CreatedAt.UTC().Format(time.RFC3339),
+		labelMemory:        project.Resources.Memory,
+		labelCPUs:          project.Resources.CPUs,
+	}
+	if len(project.Config) > 0 {
+		config, _ := json.Marshal(project.Config)
+		labels[labelConfig] = string(config)
+	}
+	return labels
+}
+
+// projectFromLabels rebuilds a project from its container. It returns false
+// for containers without a project ID label.
+func projectFromLabels(c ContainerSummary, hibernated bool) (*Project, bool) {
+	projectID := c.Labels[labelProjectID]
+	if projectID == "" {
+		return nil, false
+	}
+	if version, _ := strconv.Atoi(c.Labels[labelSchemaVersion]); version > projectLabelSchema {
+		log.Printf("⚠️ Project %s has label schema %d, newer than %d", projectID, version, projectLabelSchema)
+	}
+
+	project := &Project{
+		ID:          projectID,
+		Name:        c.Labels[labelProjectName],
+		Type:        c.Labels[labelProjectType],
+		Template:    c.Labels[labelTemplate],
+		Creator:     c.Labels[labelCreator],
+		Status:      parseContainerStatus(c.State, hibernated),
+		ContainerID: c.ID,
+		Image:       c.Image,
+		CreatedAt:   c.Created,
+		Resources: ResourceLimits{
+			Memory: c.Labels[labelMemory],
+			CPUs:   c.Labels[labelCPUs],
+		},
+	}
+	if created, err := time.Parse(time.RFC3339, c.Labels[labelCreatedAt]); err == nil {
+		project.CreatedAt = created
+	}
+	if config := c.Labels[labelConfig]; config != "" {
+		if err := json.Unmarshal([]byte(config), &project.Config); err != nil {
+			log.Printf("⚠️ Invalid config label on project %s: %v", projectID, err)
+		}
+	}
+	return project, true
+}
+
+// projectFromEnv rebuilds a project created before labels existed from the
+// PROJECT_* variables in its environment
+func projectFromEnv(c ContainerSummary, env []string, hibernated bool) *Project {
+	projectID := strings.TrimPrefix(c.Names[0], "remoteclaude-")
+	project := &Project{
+		ID:          projectID,
+		Name:        projectID,
+		Status:      parseContainerStatus(c.State, hibernated),
+		ContainerID: c.ID,
+		Image:       c.Image,
+		CreatedAt:   c.Created,
+	}
+	for _, envVar := range env {
+		parts := strings.SplitN(envVar, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "PROJECT_NAME":
+			project.Name = parts[1]
+		case "PROJECT_TYPE":
+			project.Type = parts[1]
+		}
+	}
+	return project
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProjectStatusMissing marks a registered project whose container was
+// deleted outside RemoteClaude
+const ProjectStatusMissing = "missing"
+
+// ProjectStatusFailed marks a project whose creation failed
+const ProjectStatusFailed = "failed"
+
+// ErrProjectNotRegistered is returned for project IDs the registry never saw
+var ErrProjectNotRegistered = errors.New("project not registered")
+
+// registryFlushDelay is how long command times may sit in memory before
+// they are written; every exec touches them
+const registryFlushDelay = 30 * time.Second
+
+// ProjectRecord is what the registry keeps about one project. Metadata is
+// refreshed from the container labels; owner, tags, notes and the trash and
+// hibernation state only live here.
+type ProjectRecord struct {
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	Type          string            `json:"type"`
+	Template      string            `json:"template,omitempty"`
+	Image         string            `json:"image"`
+	ContainerID   string            `json:"container_id"`
+	Creator       string            `json:"creator,omitempty"`
+	Owner         string            `json:"owner,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	LastCommandAt time.Time         `json:"last_command_at"`
+	Config        map[string]string `json:"config,omitempty"`
+	Resources     ResourceLimits    `json:"resources"`
+	Tags          []string          `json:"tags,omitempty"`
+	Notes         string            `json:"notes,omitempty"`
+	Error         string            `json:"error,omitempty"` // why creation failed
+
+	// Minutes without activity before the container is hibernated; 0 uses
+	// DefaultIdleTimeout and a negative value never hibernates
+	IdleTimeoutMinutes int       `json:"idle_timeout_minutes,omitempty"`
+	HibernatedAt       time.Time `json:"hibernated_at"` // zero unless stopped for being idle
+	TrashedAt          time.Time `json:"trashed_at"`    // zero unless in the trash
+}
+
+// ProjectRegistry is a JSON file of ProjectRecords under ~/.remoteclaude
+type ProjectRegistry struct {
+	path    string
+	mu      sync.Mutex
+	records map[string]*ProjectRecord
+	loaded  bool
+	dirty   bool // command times not written yet; a flush is scheduled
+}
+
+// NewProjectRegistry creates the registry at ~/.remoteclaude/projects/registry.json
+func NewProjectRegistry() *ProjectRegistry {
+	return &ProjectRegistry{
+		path:    filepath.Join(os.Getenv("HOME"), ".remoteclaude", "projects", "registry.json"),
+		records: make(map[string]*ProjectRecord),
+	}
+}
+
+// loadLocked reads the file once; a missing file starts an empty registry.
+// An unreadable file is moved aside rather than overwritten, so the owners,
+// tags and notes in it can still be recovered by hand.
+func (r *ProjectRegistry) loadLocked() {
+	if r.loaded {
+		return
+	}
+	r.loaded = true
+	data, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return
+	}
+	var records []*ProjectRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		aside := fmt.Sprintf("%s.unreadable-%d", r.path, time.Now().Unix())
+		log.Printf("⚠️ Project registry %s is unreadable (%v), moved to %s", r.path, err, aside)
+		os.Rename(r.path, aside)
+		return
+	}
+	for _, record := range records {
+		r.records[record.ID] = record
+	}
+}
+
+// saveLocked writes the registry through a temporary file, so a crash
+// leaves either the old or the new file behind
+func (r *ProjectRegistry) saveLocked() {
+	r.dirty = false
+	records := make([]*ProjectRecord, 0, len(r.records))
+	for _, record := range r.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		log.Printf("⚠️ Failed to save project registry: %v", err)
+		return
+	}
+	if err := writeFileAtomic(r.path, data); err != nil {
+		log.Printf("⚠️ Failed to save project registry: %v", err)
+	}
+}
+
+// flush writes command times touched since the last save
+func (r *ProjectRegistry) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.dirty {
+		r.saveLocked()
+	}
+}
+
+// writeFileAtomic replaces path with data, readable by the owner only
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Sync records the metadata of a project found in Docker and returns a copy
+// of its record. Owner, tags, notes and the last command time are kept.
+func (r *ProjectRegistry) Sync(project *Project) ProjectRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loadLocked()
+
+	record, ok := r.records[project.ID]
+	if !ok {
+		record = &ProjectRecord{ID: project.ID}
+		r.records[project.ID] = record
+	}
+	before := *record
+
+	record.Name = project.Name
+	record.Type = project.Type
+	record.Template = project.Template
+	record.Image = project.Image
+	record.ContainerID = project.ContainerID
+	record.CreatedAt = project.CreatedAt
+	record.Resources = project.Resources
+	record.Config = project.Config
+	record.Error = project.Error
+	if project.Creator != "" {
+		record.Creator = project.Creator
+	}
+	if record.Owner == "" {
+		record.Owner = record.Creator
+	}
+
+	if !ok || !reflect.DeepEqual(before, *record) {
+		r.saveLocked()
+	}
+	return *record
+}
+
+// TouchCommand records that a command just ran in projectID. It runs for
+// every exec, so the time is written within registryFlushDelay, or with the
+// next change, rather than right away.
+func (r *ProjectRegistry) TouchCommand(projectID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loadLocked()
+	record, ok := r.records[projectID]
+	if !ok {
+		record = &ProjectRecord{ID: projectID}
+		r.records[projectID] = record
+	}
+	record.LastCommandAt = time.Now().UTC()
+	if !r.dirty {
+		r.dirty = true
+		time.AfterFunc(registryFlushDelay, r.flush)
+	}
+}
+
+// Update changes the user-editable fields of a record
+func (r *ProjectRegistry) Update(projectID string, update func(*ProjectRecord)) (ProjectRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loadLocked()
+	record, ok := r.records[projectID]
+	if !ok {
+		return ProjectRecord{}, fmt.Errorf("%w: %s", ErrProjectNotRegistered, projectID)
+	}
+	update(record)
+	r.saveLocked()
+	return *record, nil
+}
+
+// Remove forgets a project and reports whether it was registered
+func (r *ProjectRegistry) Remove(projectID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loadLocked()
+	if _, ok := r.records[projectID]; !ok {
+		return false
+	}
+	delete(r.records, projectID)
+	r.saveLocked()
+	return true
+}
+
+// Get returns a copy of the record of projectID
+func (r *ProjectRegistry) Get(projectID string) (ProjectRecord, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loadLocked()
+	record, ok := r.records[projectID]
+	if !ok {
+		return ProjectRecord{}, false
+	}
+	return *record, true
+}
+
+// Records returns copies of all records
+func (r *ProjectRegistry) Records() []ProjectRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loadLocked()
+	records := make([]ProjectRecord, 0, len(r.records))
+	for _, record := range r.records {
+		records = append(records, *record)
+	}
+	return records
+}
+
+// apply copies the registry-only fields onto a project from Docker
+func (rec ProjectRecord) apply(project *Project) {
+	project.Owner = rec.Owner
+	project.Tags = rec.Tags
+	project.Notes = rec.Notes
+	project.LastCommandAt = rec.LastCommandAt
+	project.LastAccess = project.CreatedAt
+	if rec.LastCommandAt.After(project.LastAccess) {
+		project.LastAccess = rec.LastCommandAt
+	}
+	project.IdleTimeout = rec.idleTimeout()
+	if project.Status == ProjectStatusHibernated {
+		project.HibernatedAt = rec.HibernatedAt
+	}
+	if !rec.TrashedAt.IsZero() {
+		project.Status = ProjectStatusTrashed
+		project.TrashedAt = rec.TrashedAt
+	}
+}
+
+// missingProject describes a registered project without a container
+func (rec ProjectRecord) missingProject() *Project {
+	project := &Project{
+		ID:        rec.ID,
+		Name:      rec.Name,
+		Type:      rec.Type,
+		Template:  rec.Template,
+		Creator:   rec.Creator,
+		Status:    ProjectStatusMissing,
+		Error:     rec.Error,
+		Image:     rec.Image,
+		CreatedAt: rec.CreatedAt,
+		Config:    rec.Config,
+		Resources: rec.Resources,
+	}
+	if project.Name == "" {
+		project.Name = rec.ID
+	}
+	if rec.Error != "" {
+		project.Status = ProjectStatusFailed
+	}
+	rec.apply(project)
+	return project
+}
+
+// ProjectListOptions filters and orders a project listing
+type ProjectListOptions struct {
+	SortBy         string        // "recent" (default), "name" or "created"
+	ActiveWithin   time.Duration // only projects used this recently; 0 keeps all
+	Tag            string
+	Owner          string
+	IncludeMissing bool
+	IncludeTrash   bool // trashed projects are hidden unless set
+}
+
+// projectListOptionsFromMap reads list options from a request's data
+func projectListOptionsFromMap(data map[string]interface{}) (ProjectListOptions, error) {
+	opts := ProjectListOptions{IncludeMissing: true}
+	if data == nil {
+		return opts, nil
+	}
+	opts.SortBy, _ = data["sort"].(string)
+	opts.Tag, _ = data["tag"].(string)
+	opts.Owner, _ = data["owner"].(string)
+	if include, ok := data["include_missing"].(bool); ok {
+		opts.IncludeMissing = include
+	}
+	opts.IncludeTrash, _ = data["include_trash"].(bool)
+	if within, ok := data["active_within"].(string); ok && within != "" {
+		d, err := time.ParseDuration(within)
+		if err != nil || d < 0 {
+			return opts, fmt.Errorf("invalid active_within %q", within)
+		}
+		opts.ActiveWithin = d
+	}
+	switch opts.SortBy {
+	case "", "recent", "name", "created":
+	default:
+		return opts, fmt.Errorf("unknown sort %q", opts.SortBy)
+	}
+	return opts, nil
+}
+
+// FilterProjects applies opts to projects and returns them in order
+func FilterProjects(projects []*Project, opts ProjectListOptions) []*Project {
+	var filtered []*Project
+	for _, project := range projects {
+		if (project.Status == ProjectStatusMissing || project.Status == ProjectStatusFailed) && !opts.IncludeMissing {
+			continue
+		}
+		if project.Status == ProjectStatusTrashed && !opts.IncludeTrash {
+			continue
+		}
+		if opts.Owner != "" && project.Owner != opts.Owner {
+			continue
+		}
+		if opts.Tag != "" && !containsString(project.Tags, opts.Tag) {
+			continue
+		}
+		if opts.ActiveWithin > 0 && time.Since(project.LastAccess) > opts.ActiveWithin {
+			continue
+		}
+		filtered = append(filtered, project)
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		a, b := filtered[i], filtered[j]
+		switch opts.SortBy {
+		case "name":
+			return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+		case "created":
+			return a.CreatedAt.After(b.CreatedAt)
+		default:
+			return a.LastAccess.After(b.LastAccess)
+		}
+	})
+	return filtered
+}
+
+// normalizeTags trims, drops empty and duplicate tags
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !containsString(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
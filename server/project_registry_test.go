@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestRegistry returns a registry under a temporary HOME and its directory
+func newTestRegistry(t *testing.T) (*ProjectRegistry, string) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	r := NewProjectRegistry()
+	dir := filepath.Dir(r.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	return r, dir
+}
+
+func TestRegistryTouchCommandDefersWrite(t *testing.T) {
+	r, _ := newTestRegistry(t)
+	r.Sync(&Project{ID: "p1", Name: "one"})
+	written, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.TouchCommand("p1")
+	if data, _ := ioutil.ReadFile(r.path); string(data) != string(written) {
+		t.Fatal("TouchCommand wrote the registry right away")
+	}
+	if record, _ := r.Get("p1"); record.LastCommandAt.IsZero() {
+		t.Fatal("TouchCommand did not record the command time")
+	}
+
+	r.flush()
+	reloaded := NewProjectRegistry()
+	if record, _ := reloaded.Get("p1"); record.LastCommandAt.IsZero() {
+		t.Error("flush did not write the command time")
+	}
+	if matches, _ := filepath.Glob(r.path + ".tmp-*"); len(matches) > 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
+
+func TestRegistryKeepsUnreadableFile(t *testing.T) {
+	r, dir := newTestRegistry(t)
+	if err := ioutil.WriteFile(r.path, []byte("{truncated"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Sync(&Project{ID: "p1"})
+	matches, _ := filepath.Glob(filepath.Join(dir, "registry.json.unreadable-*"))
+	if len(matches) != 1 {
+		t.Fatalf("unreadable registry was not moved aside: %v", matches)
+	}
+	if data, _ := ioutil.ReadFile(matches[0]); string(data) != "{truncated" {
+		t.Errorf("moved file = %q", data)
+	}
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Project source types
+const (
+	ProjectSourceGit      = "git"
+	ProjectSourceArchive  = "archive"
+	ProjectSourceSnapshot = "snapshot" // server side only, for clones
+)
+
+// maxProjectArchiveBytes caps an uploaded project archive, compressed and
+// unpacked
+const maxProjectArchiveBytes = 256 << 20
+
+// gitCloneTimeout bounds cloning a project source
+const gitCloneTimeout = 10 * time.Minute
+
+// ProjectSource is what a new workspace is populated from instead of
+// project-init and the template scaffold
+type ProjectSource struct {
+	Type   string `json:"type"` // ProjectSourceGit or ProjectSourceArchive
+	URL    string `json:"url,omitempty"`
+	Branch string `json:"branch,omitempty"`
+
+	Filename string `json:"filename,omitempty"` // for messages only
+	Archive  []byte `json:"-"`                  // tar, tar.gz or zip
+
+	Path string `json:"-"` // snapshot archive on the host
+}
+
+// projectSourceFromMap reads a source from a project_create_request. An
+// archive comes base64 encoded in content_base64.
+func projectSourceFromMap(data map[string]interface{}) (*ProjectSource, error) {
+	src := &ProjectSource{}
+	src.Type, _ = data["type"].(string)
+	src.URL, _ = data["url"].(string)
+	src.Branch, _ = data["branch"].(string)
+	src.Filename, _ = data["filename"].(string)
+	if encoded, ok := data["content_base64"].(string); ok {
+		archive, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 content: %v", err)
+		}
+		src.Archive = archive
+	}
+	if src.Type == "" && src.URL != "" {
+		src.Type = ProjectSourceGit
+	}
+	if src.Type == ProjectSourceSnapshot {
+		return nil, fmt.Errorf("use snapshot_clone_request to clone a snapshot")
+	}
+	return src, src.Validate()
+}
+
+// gitURLPattern accepts the URLs cloned for clients. Clones run on the
+// host, so ssh and scp-style URLs, which would use the server's keys, are
+// refused.
+var gitURLPattern = regexp.MustCompile(`^https://\S+$`)
+
+// gitFileRootEnv names a host directory whose repositories may be cloned
+// through file:// URLs; without it file:// URLs are refused
+const gitFileRootEnv = "REMOTECLAUDE_GIT_FILE_ROOT"
+
+// gitBranchPattern is a conservative subset of valid ref names
+var gitBranchPattern = regexp.MustCompile(`^[\w][\w./-]*$`)
+
+// Validate checks the source before any work is done
+func (src *ProjectSource) Validate() error {
+	switch src.Type {
+	case ProjectSourceGit:
+		if err := checkGitURL(src.URL); err != nil {
+			return err
+		}
+		if src.Branch != "" && (!gitBranchPattern.MatchString(src.Branch) || strings.Contains(src.Branch, "..")) {
+			return fmt.Errorf("invalid branch %q", src.Branch)
+		}
+	case ProjectSourceArchive:
+		if len(src.Archive) == 0 {
+			return fmt.Errorf("empty archive")
+		}
+		if len(src.Archive) > maxProjectArchiveBytes {
+			return fmt.Errorf("archive too large: %d bytes (max %d)", len(src.Archive), maxProjectArchiveBytes)
+		}
+	case ProjectSourceSnapshot:
+		if src.Path == "" {
+			return fmt.Errorf("missing snapshot archive")
+		}
+	default:
+		return fmt.Errorf("unknown project source type %q", src.Type)
+	}
+	return nil
+}
+
+// checkGitURL accepts https URLs, and file:// URLs below gitFileRootEnv
+func checkGitURL(rawURL string) error {
+	if !strings.HasPrefix(rawURL, "file://") {
+		if !gitURLPattern.MatchString(rawURL) {
+			return fmt.Errorf("unsupported git URL %q; use an https URL", rawURL)
+		}
+		return nil
+	}
+
+	root := os.Getenv(gitFileRootEnv)
+	if root == "" {
+		return fmt.Errorf("file:// URLs are disabled; set %s to allow cloning from a host directory", gitFileRootEnv)
+	}
+	repo := filepath.Clean(strings.TrimPrefix(rawURL, "file://"))
+	if resolved, err := filepath.EvalSymlinks(repo); err == nil {
+		repo = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	rel, err := filepath.Rel(root, repo)
+	if err != nil || !filepath.IsAbs(repo) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("git URL %q is outside %s", rawURL, gitFileRootEnv)
+	}
+	return nil
+}
+
+// String describes the source for logs and status messages
+func (src *ProjectSource) String() string {
+	if src.Type == ProjectSourceGit {
+		if src.Branch != "" {
+			return fmt.Sprintf("%s (%s)", src.URL, src.Branch)
+		}
+		return src.URL
+	}
+	if src.Filename != "" {
+		return src.Filename
+	}
+	if src.Path != "" {
+		return filepath.Base(src.Path)
+	}
+	return fmt.Sprintf("%d byte archive", len(src.Archive))
+}
+
+// workspaceTar turns the source into a tar stream of the workspace contents.
+// Every entry is checked to stay inside the workspace.
+func (src *ProjectSource) workspaceTar(ctx context.Context, progress func(stage, message string)) ([]byte, error) {
+	if src.Type == ProjectSourceGit {
+		dir, err := ioutil.TempDir("", "remoteclaude-clone-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+
+		if err := gitClone(ctx, src.URL, src.Branch, dir, progress); err != nil {
+			return nil, err
+		}
+		return tarDirectory(dir, maxProjectArchiveBytes)
+	}
+
+	progress("unpacking", fmt.Sprintf("Unpacking %s", src.String()))
+	return normalizeArchive(src.Archive)
+}
+
+// gitClone clones url into dir on the host, passing git's progress lines on
+func gitClone(ctx context.Context, url, branch, dir string, progress func(stage, message string)) error {
+	ctx, cancel := context.WithTimeout(ctx, gitCloneTimeout)
+	defer cancel()
+
+	args := []string{"clone", "--progress"}
+	if branch != "" {
+		args = append(args, "--branch", branch)
+	}
+	args = append(args, "--", url, dir)
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	// The server's git configuration, credential helpers and keys stay out
+	// of clones made for clients
+	protocol := "https"
+	if strings.HasPrefix(url, "file://") {
+		protocol = "file"
+	}
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ALLOW_PROTOCOL="+protocol,
+		"GIT_CONFIG_NOSYSTEM=1", "GIT_CONFIG_GLOBAL="+os.DevNull, "GIT_SSH_COMMAND=false")
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	progress("cloning", fmt.Sprintf("Cloning %s", url))
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to run git: %v", err)
+	}
+
+	// git rewrites progress lines with \r; keep the last few lines for errors
+	var tail []string
+	scanner := bufio.NewScanner(stderr)
+	scanner.Split(scanCRLF)
+	lastSent := time.Time{}
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		tail = append(tail, line)
+		if len(tail) > 5 {
+			tail = tail[1:]
+		}
+		if time.Since(lastSent) >= 500*time.Millisecond || strings.Contains(line, "done") {
+			progress("cloning", line)
+			lastSent = time.Now()
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("git clone timed out after %v", gitCloneTimeout)
+		}
+		return fmt.Errorf("git clone failed: %s", gitErrorSummary(tail, err))
+	}
+	return nil
+}
+
+// gitErrorSummary picks git's own fatal/error lines over the exit status
+func gitErrorSummary(lines []string, err error) string {
+	var messages []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "fatal:") || strings.HasPrefix(line, "error:") {
+			messages = append(messages, line)
+		}
+	}
+	if len(messages) == 0 {
+		return err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// scanCRLF splits on \n and on the \r git uses for progress updates
+func scanCRLF(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// tarDirectory archives the contents of dir, failing once the files add
+// up to more than limit bytes. Symlinks must stay inside the workspace, as
+// in uploaded archives.
+func tarDirectory(dir string, limit int64) ([]byte, error) {
+	var buf bytes.Buffer
+	var total int64
+	tw := tar.NewWriter(&buf)
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+			if err := checkArchiveLink(filepath.ToSlash(rel), link); err != nil {
+				return err
+			}
+		}
+		if info.Mode().IsRegular() {
+			if total += info.Size(); total > limit {
+				return fmt.Errorf("repository is larger than %d bytes", limit)
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(tw, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// normalizeArchive rewrites a tar, tar.gz or zip upload into a plain tar
+// with checked entry names. A single top-level directory, as produced by
+// most "download as archive" buttons, is stripped.
+func normalizeArchive(data []byte) ([]byte, error) {
+	entries, err := readArchive(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("archive is empty")
+	}
+	prefix := commonTopDirectory(entries)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		name := strings.TrimPrefix(entry.header.Name, prefix)
+		if name == "" {
+			continue
+		}
+		header := *entry.header
+		header.Name = name
+		header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(&header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type archiveEntry struct {
+	header *tar.Header
+	data   []byte
+}
+
+// readArchive reads the files, directories and symlinks of an archive,
+// rejecting entries that would land outside the workspace
+func readArchive(data []byte) ([]archiveEntry, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")) || bytes.HasPrefix(data, []byte("PK\x05\x06")):
+		return readZip(data)
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip archive: %v", err)
+		}
+		defer gz.Close()
+		return readTar(gz)
+	case len(data) > 262 && string(data[257:262]) == "ustar":
+		return readTar(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unsupported archive format; use tar, tar.gz or zip")
+	}
+}
+
+func readTar(r io.Reader) ([]archiveEntry, error) {
+	var entries []archiveEntry
+	var total int64
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid tar archive: %v", err)
+		}
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeRegA, tar.TypeDir, tar.TypeSymlink:
+		default:
+			continue // devices, hard links and metadata entries are skipped
+		}
+		if total += header.Size; total > maxProjectArchiveBytes {
+			return nil, fmt.Errorf("archive unpacks to more than %d bytes", maxProjectArchiveBytes)
+		}
+		entry, err := newArchiveEntry(header, tr)
+		if err != nil {
+			return nil, err
+		}
+		if entry.header != nil {
+			entries = append(entries, entry)
+		}
+	}
+}
+
+func readZip(data []byte) ([]archiveEntry, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %v", err)
+	}
+	var entries []archiveEntry
+	var total int64
+	for _, file := range zr.File {
+		info := file.FileInfo()
+		header := &tar.Header{
+			Name:    file.Name,
+			Mode:    int64(info.Mode().Perm()),
+			ModTime: file.Modified,
+		}
+		switch {
+		case info.IsDir():
+			header.Typeflag = tar.TypeDir
+		case info.Mode()&os.ModeSymlink != 0:
+			header.Typeflag = tar.TypeSymlink
+		case info.Mode().IsRegular():
+			header.Typeflag = tar.TypeReg
+			header.Size = int64(file.UncompressedSize64)
+		default:
+			continue
+		}
+		if total += header.Size; total > maxProjectArchiveBytes {
+			return nil, fmt.Errorf("archive unpacks to more than %d bytes", maxProjectArchiveBytes)
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("invalid zip entry %s: %v", file.Name, err)
+		}
+		entry, err := newArchiveEntry(header, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		if entry.header == nil {
+			continue
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			// zip stores the link target as the file content
+			entry.header.Linkname = string(entry.data)
+			entry.header.Size = 0
+			entry.data = nil
+			if err := checkArchiveLink(entry.header.Name, entry.header.Linkname); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// newArchiveEntry cleans the entry name and reads its content. Entries for
+// the archive root come back without a header.
+func newArchiveEntry(header *tar.Header, r io.Reader) (archiveEntry, error) {
+	name := strings.TrimLeft(path.Clean(strings.ReplaceAll(header.Name, "\\", "/")), "/")
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return archiveEntry{}, fmt.Errorf("archive entry escapes the workspace: %s", header.Name)
+	}
+	if name == "" || name == "." {
+		return archiveEntry{}, nil
+	}
+	header.Name = name
+	if header.Typeflag == tar.TypeDir {
+		header.Name += "/"
+	}
+	if header.Typeflag == tar.TypeSymlink && header.Linkname != "" {
+		if err := checkArchiveLink(header.Name, header.Linkname); err != nil {
+			return archiveEntry{}, err
+		}
+	}
+
+	var data []byte
+	if header.Typeflag != tar.TypeDir {
+		var err error
+		data, err = ioutil.ReadAll(io.LimitReader(r, maxProjectArchiveBytes+1))
+		if err != nil {
+			return archiveEntry{}, fmt.Errorf("failed to read %s: %v", header.Name, err)
+		}
+		if len(data) > maxProjectArchiveBytes {
+			return archiveEntry{}, fmt.Errorf("archive entry too large: %s", header.Name)
+		}
+	}
+	if header.Typeflag != tar.TypeSymlink {
+		header.Size = int64(len(data))
+	}
+	if header.Typeflag == tar.TypeRegA {
+		header.Typeflag = tar.TypeReg
+	}
+	return archiveEntry{header: header, data: data}, nil
+}
+
+// checkArchiveLink rejects symlinks pointing outside the workspace
+func checkArchiveLink(name, target string) error {
+	if path.IsAbs(target) {
+		if target == workspaceRoot || strings.HasPrefix(path.Clean(target), workspaceRoot+"/") {
+			return nil
+		}
+		return fmt.Errorf("archive symlink escapes the workspace: %s -> %s", name, target)
+	}
+	resolved := path.Join(path.Dir(name), target)
+	if resolved != ".." && !strings.HasPrefix(resolved, "../") {
+		return nil
+	}
+	return fmt.Errorf("archive symlink escapes the workspace: %s -> %s", name, target)
+}
+
+// commonTopDirectory returns "dir/" when every entry lives below one
+// top-level directory, or ""
+func commonTopDirectory(entries []archiveEntry) string {
+	top := ""
+	for _, entry := range entries {
+		first := strings.SplitN(entry.header.Name, "/", 2)
+		if len(first) < 2 {
+			if entry.header.Typeflag != tar.TypeDir {
+				return "" // a file at the top level
+			}
+		}
+		if top == "" {
+			top = first[0]
+		} else if first[0] != top {
+			return ""
+		}
+	}
+	if top == "" {
+		return ""
+	}
+	return top + "/"
+}
+
+// populateWorkspace fills the project volume from src
+func (dm *DockerManager) populateWorkspace(ctx context.Context, project *Project, src *ProjectSource, progress func(stage, message string)) error {
+	if src.Type == ProjectSourceSnapshot {
+		// Our own snapshots are trusted and streamed as they are
+		progress("copying", fmt.Sprintf("Restoring snapshot %s", src.String()))
+		return dm.extractSnapshot(ctx, project.ContainerID, src.Path, false)
+	}
+
+	archive, err := src.workspaceTar(ctx, progress)
+	if err != nil {
+		return err
+	}
+
+	progress("copying", fmt.Sprintf("Copying %d KB into the workspace", len(archive)/1024))
+	result, err := dm.execContainer(ctx, project.ContainerID,
+		[]string{"tar", "-x", "-f", "-", "-C", workspaceRoot, "--no-same-owner", "--no-same-permissions"}, nil, bytes.NewReader(archive))
+	if err = execFailure(result, err); err != nil {
+		return fmt.Errorf("failed to unpack into the workspace: %v: %s", err, strings.TrimSpace(result.combined()))
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// DefaultTemplate is used for projects created without a type, and for
+// types no template claims
+const DefaultTemplate = "general"
+
+// DefaultProjectImage is the image of templates that do not name one
+const DefaultProjectImage = "remoteclaude-ubuntu-claude:latest"
+
+// ErrTemplateNotFound is returned for unknown template names
+var ErrTemplateNotFound = errors.New("template not found")
+
+//go:embed templates/*.json
+var builtinTemplates embed.FS
+
+// templateNamePattern is what template names and aliases may look like
+var templateNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// ProjectTemplate declares how projects of one type are created
+type ProjectTemplate struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Aliases     []string `json:"aliases,omitempty"` // other project types it answers to
+	Image       string   `json:"image,omitempty"`
+
+	// Files are written into /workspace after initialization. Paths are
+	// relative to /workspace; contents are text/template strings over
+	// ProjectID, ProjectName and ProjectType.
+	Files map[string]string `json:"files,omitempty"`
+
+	// PostCreate runs in order once the files exist; a failure fails the
+	// project creation
+	PostCreate []string `json:"post_create,omitempty"`
+
+	Env           map[string]string `json:"env,omitempty"`
+	QuickCommands []QuickCommand    `json:"quick_commands,omitempty"`
+	Resources     *ResourceLimits   `json:"resources,omitempty"`
+
+	Source string `json:"source"` // "builtin" or the file it was read from
+}
+
+// templateData is what template files are rendered with
+type templateData struct {
+	ProjectID   string
+	ProjectName string
+	ProjectType string
+}
+
+// Validate checks names, paths and templates of t
+func (t *ProjectTemplate) Validate() error {
+	if !templateNamePattern.MatchString(t.Name) {
+		return fmt.Errorf("invalid template name %q", t.Name)
+	}
+	for _, alias := range t.Aliases {
+		if !templateNamePattern.MatchString(alias) {
+			return fmt.Errorf("template %s: invalid alias %q", t.Name, alias)
+		}
+	}
+	for name, content := range t.Files {
+		if filepath.IsAbs(name) {
+			return fmt.Errorf("template %s: file path must be relative: %s", t.Name, name)
+		}
+		if _, err := resolveWorkspacePath(name); err != nil {
+			return fmt.Errorf("template %s: %v", t.Name, err)
+		}
+		if _, err := template.New(name).Parse(content); err != nil {
+			return fmt.Errorf("template %s: %v", t.Name, err)
+		}
+	}
+	for _, command := range t.QuickCommands {
+		if command.ID == "" || command.Command == "" {
+			return fmt.Errorf("template %s: quick commands need an id and a command", t.Name)
+		}
+	}
+	if t.Resources != nil {
+		if _, err := parseMemoryLimit(t.Resources.Memory); err != nil {
+			return fmt.Errorf("template %s: %v", t.Name, err)
+		}
+		if _, err := parseCPULimit(t.Resources.CPUs); err != nil {
+			return fmt.Errorf("template %s: %v", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// RenderFiles returns the scaffold files of t for a project, by path
+func (t *ProjectTemplate) RenderFiles(project *Project) (map[string][]byte, error) {
+	data := templateData{ProjectID: project.ID, ProjectName: project.Name, ProjectType: project.Type}
+	files := make(map[string][]byte, len(t.Files))
+	for name, content := range t.Files {
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(content)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("failed to render %s: %v", name, err)
+		}
+		files[name] = buf.Bytes()
+	}
+	return files, nil
+}
+
+// TemplateStore reads the builtin templates and template files from
+// ~/.remoteclaude/templates. A file with the name of a builtin template
+// replaces it. Files are read on every call so edits apply immediately.
+type TemplateStore struct {
+	dir string
+}
+
+// NewTemplateStore creates the store for ~/.remoteclaude/templates
+func NewTemplateStore() *TemplateStore {
+	return &TemplateStore{
+		dir: filepath.Join(os.Getenv("HOME"), ".remoteclaude", "templates"),
+	}
+}
+
+// List returns every valid template, sorted by name. Broken template files
+// are logged and skipped.
+func (ts *TemplateStore) List() []*ProjectTemplate {
+	byName := make(map[string]*ProjectTemplate)
+
+	entries, _ := builtinTemplates.ReadDir("templates")
+	for _, entry := range entries {
+		data, err := builtinTemplates.ReadFile("templates/" + entry.Name())
+		if err != nil {
+			continue
+		}
+		if t, err := parseTemplate(data, "builtin"); err != nil {
+			log.Printf("⚠️ Invalid builtin template %s: %v", entry.Name(), err)
+		} else {
+			byName[t.Name] = t
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(ts.dir, "*.json"))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Printf("⚠️ Failed to read template %s: %v", file, err)
+			continue
+		}
+		if t, err := parseTemplate(data, file); err != nil {
+			log.Printf("⚠️ Skipping template %s: %v", file, err)
+		} else {
+			byName[t.Name] = t
+		}
+	}
+
+	templates := make([]*ProjectTemplate, 0, len(byName))
+	for _, t := range byName {
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates
+}
+
+// Get finds a template by name or alias
+func (ts *TemplateStore) Get(name string) (*ProjectTemplate, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	templates := ts.List()
+	for _, t := range templates {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	for _, t := range templates {
+		if containsString(t.Aliases, name) {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+}
+
+// Resolve returns the template for a project type, falling back to
+// DefaultTemplate for types no template claims
+func (ts *TemplateStore) Resolve(projectType string) (*ProjectTemplate, error) {
+	if projectType == "" {
+		projectType = DefaultTemplate
+	}
+	t, err := ts.Get(projectType)
+	if errors.Is(err, ErrTemplateNotFound) && projectType != DefaultTemplate {
+		log.Printf("⚠️ No template for project type %q, using %s", projectType, DefaultTemplate)
+		return ts.Get(DefaultTemplate)
+	}
+	return t, err
+}
+
+// parseTemplate decodes and validates one template file
+func parseTemplate(data []byte, source string) (*ProjectTemplate, error) {
+	var t ProjectTemplate
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&t); err != nil {
+		return nil, err
+	}
+	if t.Image == "" {
+		t.Image = DefaultProjectImage
+	}
+	t.Source = source
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// applyTemplate writes the scaffold files of t into the project workspace
+// and runs its post-create commands
+func (dm *DockerManager) applyTemplate(project *Project, t *ProjectTemplate) error {
+	files, err := t.RenderFiles(project)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := dm.WriteFile(project.ID, name, files[name]); err != nil {
+			return fmt.Errorf("failed to write %s: %v", name, err)
+		}
+	}
+
+	for _, command := range t.PostCreate {
+		log.Printf("🔧 Post-create (%s): %s", t.Name, command)
+		output, err := dm.ExecuteCommand(project.ID, command)
+		if err != nil {
+			return fmt.Errorf("post-create command %q failed: %v: %s", command, err, strings.TrimSpace(output))
+		}
+	}
+	return nil
+}
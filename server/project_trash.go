@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ProjectStatusTrashed marks a removed project that can still be restored
+const ProjectStatusTrashed = "trashed"
+
+// DefaultTrashRetention is how long trashed projects are kept when the user
+// configuration does not say otherwise
+const DefaultTrashRetention = 7 * 24 * time.Hour
+
+// ErrProjectTrashed is returned when a trashed project is used or trashed
+// again
+var ErrProjectTrashed = errors.New("project is in the trash")
+
+// ErrProjectNotTrashed is returned when restoring a project that is not in
+// the trash
+var ErrProjectNotTrashed = errors.New("project is not in the trash")
+
+// RemoveProject moves a project to the trash. Its container is stopped and
+// kept as it is, so nothing outside the workspace volume is lost; the
+// registry records that it is trashed. Projects without a container have
+// nothing to keep and are purged right away.
+func (dm *DockerManager) RemoveProject(projectID string) (*Project, error) {
+	project, err := dm.GetProject(projectID)
+	if errors.Is(err, ErrContainerNotFound) {
+		return nil, dm.PurgeProject(projectID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !project.TrashedAt.IsZero() {
+		return nil, fmt.Errorf("%w: %s", ErrProjectTrashed, projectID)
+	}
+
+	if err := dm.StopProject(projectID); err != nil {
+		return nil, err
+	}
+	record, err := dm.registry.Update(projectID, func(record *ProjectRecord) {
+		record.TrashedAt = time.Now().UTC()
+	})
+	if err != nil {
+		return nil, err
+	}
+	project.Status = "stopped"
+	record.apply(project)
+
+	log.Printf("🗑️ Project moved to trash: %s", projectID)
+	return project, nil
+}
+
+// RestoreProject takes a project out of the trash and starts it again
+func (dm *DockerManager) RestoreProject(projectID string) (*Project, error) {
+	project, err := dm.GetProject(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if project.TrashedAt.IsZero() {
+		return nil, fmt.Errorf("%w: %s", ErrProjectNotTrashed, projectID)
+	}
+
+	// StartProject refuses trashed projects, so the flag is cleared first and
+	// put back if the container does not start
+	trashedAt := project.TrashedAt
+	if _, err := dm.registry.Update(projectID, func(record *ProjectRecord) {
+		record.TrashedAt = time.Time{}
+	}); err != nil {
+		return nil, err
+	}
+	if err := dm.StartProject(projectID); err != nil {
+		if _, rerr := dm.registry.Update(projectID, func(record *ProjectRecord) {
+			record.TrashedAt = trashedAt
+		}); rerr != nil {
+			log.Printf("⚠️ Failed to put project %s back in the trash: %v", projectID, rerr)
+		}
+		return nil, err
+	}
+	project.TrashedAt = time.Time{}
+	project.Status = "running"
+
+	log.Printf("♻️ Project restored from trash: %s", projectID)
+	return project, nil
+}
+
+// trashRetention is how long the default user keeps trashed projects
+func (s *Server) trashRetention() time.Duration {
+	userConfig, err := s.configManager.LoadUserConfig(DefaultUserID)
+	if err != nil {
+		log.Printf("⚠️ Failed to load user config for trash retention: %v", err)
+		return DefaultTrashRetention
+	}
+	switch {
+	case userConfig.TrashRetentionDays < 0:
+		return -1
+	case userConfig.TrashRetentionDays == 0:
+		return DefaultTrashRetention
+	}
+	return time.Duration(userConfig.TrashRetentionDays) * 24 * time.Hour
+}
+
+// PurgeExpiredTrash purges projects trashed longer than retention ago and
+// returns their IDs
+func (dm *DockerManager) PurgeExpiredTrash(retention time.Duration) ([]string, error) {
+	projects, err := dm.ListProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	var purged []string
+	for _, project := range projects {
+		if project.TrashedAt.IsZero() || time.Since(project.TrashedAt) < retention {
+			continue
+		}
+		if err := dm.PurgeProject(project.ID); err != nil {
+			log.Printf("⚠️ Failed to purge trashed project %s: %v", project.ID, err)
+			continue
+		}
+		purged = append(purged, project.ID)
+	}
+	return purged, nil
+}
+
+// StartTrashReaper purges expired trash every interval until the returned
+// stop function is called. retention is asked on every pass so changes to
+// the configuration apply without a restart; a negative retention keeps
+// trashed projects forever.
+func (dm *DockerManager) StartTrashReaper(interval time.Duration, retention func() time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				keep := retention()
+				if keep < 0 {
+					continue
+				}
+				purged, err := dm.PurgeExpiredTrash(keep)
+				if err != nil {
+					log.Printf("⚠️ Trash reaper: %v", err)
+				} else if len(purged) > 0 {
+					log.Printf("🧹 Trash reaper purged %d projects: %v", len(purged), purged)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	log.Printf("🧹 Trash reaper running every %v", interval)
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
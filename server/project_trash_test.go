@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTrashKeepsContainer(t *testing.T) {
+	dm, engine := newTestDockerManager(t)
+	project := startTestProject(t, dm, "trash")
+
+	trashed, err := dm.RemoveProject(project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trashed.Status != ProjectStatusTrashed || trashed.TrashedAt.IsZero() {
+		t.Errorf("trashed project = %+v", trashed)
+	}
+	c, ok := engine.Container(project.ContainerID)
+	if !ok || c.Info.Running {
+		t.Fatal("the trashed container was removed or is still running")
+	}
+
+	if _, err := dm.ExecuteCommand(project.ID, "ls"); !errors.Is(err, ErrProjectTrashed) {
+		t.Errorf("ExecuteCommand = %v, want ErrProjectTrashed", err)
+	}
+	if err := dm.StartProject(project.ID); !errors.Is(err, ErrProjectTrashed) {
+		t.Errorf("StartProject = %v, want ErrProjectTrashed", err)
+	}
+	if _, err := dm.RemoveProject(project.ID); !errors.Is(err, ErrProjectTrashed) {
+		t.Errorf("second RemoveProject = %v, want ErrProjectTrashed", err)
+	}
+	projects, err := dm.ListProjects()
+	if err != nil || len(projects) != 1 || projects[0].Status != ProjectStatusTrashed {
+		t.Fatalf("ListProjects = %+v, %v", projects, err)
+	}
+
+	// A restore whose start fails leaves the project in the trash
+	engine.StartErr = errors.New("start failed")
+	if _, err := dm.RestoreProject(project.ID); err == nil {
+		t.Fatal("RestoreProject succeeded without starting the container")
+	}
+	if got, _ := dm.GetProject(project.ID); got.Status != ProjectStatusTrashed || !got.TrashedAt.Equal(trashed.TrashedAt) {
+		t.Errorf("after a failed restore: %+v", got)
+	}
+	engine.StartErr = nil
+
+	restored, err := dm.RestoreProject(project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Status != "running" || restored.ContainerID != project.ContainerID {
+		t.Errorf("restored project = %+v", restored)
+	}
+	if c, _ := engine.Container(project.ContainerID); !c.Info.Running {
+		t.Error("restored container is not running")
+	}
+	if _, err := dm.ExecuteCommand(project.ID, "ls"); err != nil {
+		t.Errorf("ExecuteCommand after restore: %v", err)
+	}
+}
+
+func TestPurgeExpiredTrash(t *testing.T) {
+	dm, engine := newTestDockerManager(t)
+	kept := startTestProject(t, dm, "kept")
+	expired := startTestProject(t, dm, "expired")
+	orphan := startTestProject(t, dm, "orphan")
+
+	for _, project := range []*Project{kept, expired, orphan} {
+		if _, err := dm.RemoveProject(project.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, project := range []*Project{expired, orphan} {
+		dm.registry.Update(project.ID, func(record *ProjectRecord) {
+			record.TrashedAt = time.Now().Add(-48 * time.Hour)
+		})
+	}
+	// A trashed project whose container was deleted behind our back
+	engine.ContainerRemove(context.Background(), orphan.ContainerID, true)
+
+	purged, err := dm.PurgeExpiredTrash(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purged) != 2 {
+		t.Fatalf("purged %v, want %s and %s", purged, expired.ID, orphan.ID)
+	}
+	if _, ok := engine.Container(expired.ContainerID); ok {
+		t.Error("expired container was not removed")
+	}
+	if engine.HasVolume(volumeName(expired.ID)) {
+		t.Error("expired workspace volume was not removed")
+	}
+	projects, _ := dm.ListProjects()
+	if len(projects) != 1 || projects[0].ID != kept.ID {
+		t.Errorf("left after purge: %+v", projects)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ScriptedTurn is one canned reply of the scripted backend
+type ScriptedTurn struct {
+	Match   string             `json:"match,omitempty"` // case-insensitive substring of the prompt; empty matches anything
+	Reply   string             `json:"reply"`
+	Tools   []ScriptedToolCall `json:"tools,omitempty"`    // tool activity reported before the reply
+	IsError bool               `json:"is_error,omitempty"` // finish with an error result
+}
+
+// ScriptedToolCall is a tool_use / tool_result pair the scripted backend reports
+type ScriptedToolCall struct {
+	Name   string          `json:"name"`
+	Input  json.RawMessage `json:"input,omitempty"`
+	Output string          `json:"output"`
+}
+
+// scriptedWordDelay paces text deltas so clients see the reply stream in
+const scriptedWordDelay = 20 * time.Millisecond
+
+// ScriptedBackend answers from a fixed script without calling any model.
+// Output depends only on the script and the prompt, which makes it suitable
+// for tests and offline demos of the conversation flow.
+type ScriptedBackend struct {
+	projectID string
+	script    []ScriptedTurn
+	runs      runTracker
+
+	mu       sync.Mutex
+	sessions int
+}
+
+// NewScriptedBackend creates a scripted backend. With an empty script every
+// prompt is echoed back.
+func NewScriptedBackend(projectID string, script []ScriptedTurn) *ScriptedBackend {
+	return &ScriptedBackend{projectID: projectID, script: script}
+}
+
+// turnFor picks the first turn matching prompt
+func (b *ScriptedBackend) turnFor(prompt string) ScriptedTurn {
+	lower := strings.ToLower(prompt)
+	for _, turn := range b.script {
+		if turn.Match == "" || strings.Contains(lower, strings.ToLower(turn.Match)) {
+			return turn
+		}
+	}
+	return ScriptedTurn{Reply: fmt.Sprintf("Scripted reply to: %s", prompt)}
+}
+
+// sessionID keeps a resumed session and numbers new ones
+func (b *ScriptedBackend) sessionID(resumeID string) string {
+	if resumeID != "" {
+		return resumeID
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sessions++
+	return fmt.Sprintf("scripted-%s-%d", b.projectID, b.sessions)
+}
+
+func (b *ScriptedBackend) Stream(ctx context.Context, req AIRequest) (<-chan ClaudeEvent, error) {
+	turn := b.turnFor(req.Prompt)
+	sessionID := b.sessionID(req.ResumeID)
+	ctx, finish := b.runs.start(ctx)
+
+	events := make(chan ClaudeEvent, 64)
+	go func() {
+		defer close(events)
+		defer finish()
+
+		// send delivers an event unless the run was cancelled first
+		send := func(event ClaudeEvent) bool {
+			select {
+			case <-ctx.Done():
+				err := runContextError(ctx, 0)
+				events <- ClaudeEvent{Type: ClaudeEventError, Error: err.Error(), Err: err}
+				return false
+			default:
+				events <- event
+				return true
+			}
+		}
+
+		if !send(ClaudeEvent{Type: ClaudeEventInit, SessionID: sessionID, Model: "scripted"}) {
+			return
+		}
+		for i, tool := range turn.Tools {
+			toolUseID := fmt.Sprintf("scripted_tool_%d", i+1)
+			input := tool.Input
+			if len(input) == 0 {
+				input = json.RawMessage("{}")
+			}
+			if !send(ClaudeEvent{Type: ClaudeEventToolUse, SessionID: sessionID, ToolUseID: toolUseID, ToolName: tool.Name, ToolInput: input}) ||
+				!send(ClaudeEvent{Type: ClaudeEventToolResult, SessionID: sessionID, ToolUseID: toolUseID, ToolOutput: tool.Output}) {
+				return
+			}
+		}
+
+		words := strings.SplitAfter(turn.Reply, " ")
+		for _, word := range words {
+			if !send(ClaudeEvent{Type: ClaudeEventText, SessionID: sessionID, Text: word}) {
+				return
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(scriptedWordDelay):
+			}
+		}
+
+		subtype := "success"
+		if turn.IsError {
+			subtype = "error_during_execution"
+		}
+		send(ClaudeEvent{
+			Type:      ClaudeEventResult,
+			SessionID: sessionID,
+			IsError:   turn.IsError,
+			Result: &ClaudeResult{
+				SessionID:  sessionID,
+				Subtype:    subtype,
+				Text:       turn.Reply,
+				IsError:    turn.IsError,
+				NumTurns:   1 + len(turn.Tools),
+				DurationMs: int64(len(words)) * scriptedWordDelay.Milliseconds(), // nominal, for stable output
+				Usage: ClaudeUsage{
+					InputTokens:  int64(len(strings.Fields(req.Prompt))),
+					OutputTokens: int64(len(strings.Fields(turn.Reply))),
+				},
+			},
+		})
+	}()
+	return events, nil
+}
+
+func (b *ScriptedBackend) Ask(ctx context.Context, req AIRequest) (*ClaudeResult, error) {
+	events, err := b.Stream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return collectClaudeResult(events)
+}
+
+func (b *ScriptedBackend) Cancel() {
+	b.runs.cancelAll()
+}
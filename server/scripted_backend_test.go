@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// drain collects every event of a stream, failing the test if it never ends
+func drain(t *testing.T, events <-chan ClaudeEvent) []ClaudeEvent {
+	t.Helper()
+	var all []ClaudeEvent
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return all
+			}
+			all = append(all, event)
+		case <-timeout:
+			t.Fatalf("stream did not end; got %d events", len(all))
+		}
+	}
+}
+
+func eventTypes(events []ClaudeEvent) []ClaudeEventType {
+	types := make([]ClaudeEventType, len(events))
+	for i, event := range events {
+		types[i] = event.Type
+	}
+	return types
+}
+
+func TestScriptedBackendStreamOrder(t *testing.T) {
+	backend := NewScriptedBackend("demo", []ScriptedTurn{{
+		Match: "tests",
+		Reply: "All tests pass",
+		Tools: []ScriptedToolCall{{Name: "Bash", Output: "ok"}},
+	}})
+
+	events, err := backend.Stream(context.Background(), AIRequest{Prompt: "Run the TESTS please"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := drain(t, events)
+
+	want := []ClaudeEventType{
+		ClaudeEventInit,
+		ClaudeEventToolUse, ClaudeEventToolResult,
+		ClaudeEventText, ClaudeEventText, ClaudeEventText,
+		ClaudeEventResult,
+	}
+	got := eventTypes(all)
+	if len(got) != len(want) {
+		t.Fatalf("event types = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("event types = %v, want %v", got, want)
+		}
+	}
+
+	sessionID := all[0].SessionID
+	if sessionID == "" {
+		t.Fatal("init event has no session ID")
+	}
+	if all[1].ToolName != "Bash" || all[1].ToolUseID != all[2].ToolUseID || all[2].ToolOutput != "ok" {
+		t.Errorf("tool events = %+v, %+v", all[1], all[2])
+	}
+	var text strings.Builder
+	for _, event := range all[3:6] {
+		text.WriteString(event.Text)
+	}
+	if text.String() != "All tests pass" {
+		t.Errorf("streamed text = %q", text.String())
+	}
+	result := all[len(all)-1].Result
+	if result == nil || result.IsError || result.Text != "All tests pass" || result.SessionID != sessionID || result.NumTurns != 2 {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestScriptedBackendAsk(t *testing.T) {
+	backend := NewScriptedBackend("demo", nil)
+
+	result, err := backend.Ask(context.Background(), AIRequest{Prompt: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Text != "Scripted reply to: hello" || result.Subtype != "success" {
+		t.Errorf("result = %+v", result)
+	}
+
+	// Resuming keeps the session, a new conversation gets a new one
+	resumed, err := backend.Ask(context.Background(), AIRequest{Prompt: "again", ResumeID: result.SessionID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.SessionID != result.SessionID {
+		t.Errorf("resumed session = %q, want %q", resumed.SessionID, result.SessionID)
+	}
+	fresh, _ := backend.Ask(context.Background(), AIRequest{Prompt: "new"})
+	if fresh.SessionID == result.SessionID {
+		t.Errorf("new conversation reused session %q", fresh.SessionID)
+	}
+}
+
+func TestScriptedBackendErrorResult(t *testing.T) {
+	backend := NewScriptedBackend("demo", []ScriptedTurn{{Reply: "Build failed", IsError: true}})
+
+	events, err := backend.Stream(context.Background(), AIRequest{Prompt: "build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := drain(t, events)
+	last := all[len(all)-1]
+	if last.Type != ClaudeEventResult || !last.IsError || last.Result.Subtype != "error_during_execution" {
+		t.Fatalf("last event = %+v", last)
+	}
+
+	result, err := backend.Ask(context.Background(), AIRequest{Prompt: "build"})
+	if err == nil || result == nil || !result.IsError {
+		t.Fatalf("Ask = %+v, %v; want an error result", result, err)
+	}
+}
+
+func TestScriptedBackendCancel(t *testing.T) {
+	reply := strings.Repeat("word ", 200)
+	backend := NewScriptedBackend("demo", []ScriptedTurn{{Reply: reply}})
+
+	events, err := backend.Stream(context.Background(), AIRequest{Prompt: "long"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := <-events; first.Type != ClaudeEventInit {
+		t.Fatalf("first event = %v", first.Type)
+	}
+	backend.Cancel()
+
+	all := drain(t, events)
+	last := all[len(all)-1]
+	if last.Type != ClaudeEventError || !errors.Is(last.Err, ErrRunCanceled) {
+		t.Fatalf("last event = %+v, want a canceled error", last)
+	}
+	for _, event := range all {
+		if event.Type == ClaudeEventResult {
+			t.Fatal("canceled run still delivered a result")
+		}
+	}
+}
+
+func TestScriptedBackendContextCancel(t *testing.T) {
+	backend := NewScriptedBackend("demo", []ScriptedTurn{{Reply: strings.Repeat("word ", 200)}})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	var result *ClaudeResult
+	var err error
+	go func() {
+		defer close(done)
+		result, err = backend.Ask(ctx, AIRequest{Prompt: "long"})
+	}()
+	time.Sleep(3 * scriptedWordDelay)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ask did not return after cancel")
+	}
+	if result != nil || !errors.Is(err, ErrRunCanceled) {
+		t.Fatalf("Ask = %+v, %v; want ErrRunCanceled", result, err)
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Agent permission modes
+const (
+	AgentPermissionsPrompt = "prompt" // ask the user for every Edit, Write and Bash call (default)
+	AgentPermissionsSkip   = "skip"   // let Claude use every tool unasked; container runtime only
+)
+
+// permissionURLEnv passes the per-run permission endpoint to the hook command
+const permissionURLEnv = "REMOTECLAUDE_PERMISSION_URL"
+
+// permissionHookPath is the HTTP endpoint the PreToolUse hook calls
+const permissionHookPath = "/permission/tool"
+
+// permissionToolMatcher selects the tools whose calls need approval
+const permissionToolMatcher = "Edit|MultiEdit|Write|NotebookEdit|Bash"
+
+// permissionHookCommand forwards the hook input to the server and prints the
+// decision. Exit status 2 makes the CLI block the call when the server cannot
+// be reached.
+const permissionHookCommand = `curl -fsS --max-time 900 -H 'Content-Type: application/json' --data-binary @- "$` + permissionURLEnv + `" || { echo "RemoteClaude permission service unreachable" >&2; exit 2; }`
+
+// maxPermissionPreview caps the preview sent with a permission request
+const maxPermissionPreview = 64 << 10
+
+// permissionHookSettings is passed with --settings so every matching tool
+// call runs the permission hook first
+var permissionHookSettings = func() string {
+	settings := map[string]interface{}{
+		"hooks": map[string]interface{}{
+			"PreToolUse": []interface{}{
+				map[string]interface{}{
+					"matcher": permissionToolMatcher,
+					"hooks": []interface{}{
+						map[string]interface{}{"type": "command", "command": permissionHookCommand, "timeout": 900},
+					},
+				},
+			},
+		},
+	}
+	data, _ := json.Marshal(settings)
+	return string(data)
+}()
+
+// toolHookInput is the PreToolUse hook payload written by the CLI
+type toolHookInput struct {
+	SessionID     string          `json:"session_id"`
+	HookEventName string          `json:"hook_event_name"`
+	Cwd           string          `json:"cwd"`
+	ToolName      string          `json:"tool_name"`
+	ToolInput     json.RawMessage `json:"tool_input"`
+	ToolUseID     string          `json:"tool_use_id"`
+}
+
+// toolHookOutput is the PreToolUse decision returned to the CLI
+type toolHookOutput struct {
+	HookSpecificOutput struct {
+		HookEventName            string `json:"hookEventName"`
+		PermissionDecision       string `json:"permissionDecision"` // "allow" or "deny"
+		PermissionDecisionReason string `json:"permissionDecisionReason"`
+	} `json:"hookSpecificOutput"`
+}
+
+// permissionRun is a Claude run whose tool calls are routed to the user
+type permissionRun struct {
+	projectID   string
+	inContainer bool
+}
+
+// root is the workspace the run's path rules are relative to
+func (r *permissionRun) root(cwd string) string {
+	if r.inContainer {
+		return "/workspace"
+	}
+	return cwd
+}
+
+// ToolPermissionGate turns the CLI's PreToolUse hook calls into
+// PermissionRequests. Each run gets its own token, so a hook call can only
+// ask on behalf of the project that started the run, and each approval
+// covers exactly one tool call.
+type ToolPermissionGate struct {
+	pm            *PermissionManager
+	policy        *PermissionPolicy
+	audit         *AuditLog
+	dockerManager *DockerManager
+	port          string
+	notify        func(projectID string, req *PermissionRequest) error
+
+	mu   sync.Mutex
+	runs map[string]*permissionRun // token -> run
+}
+
+// NewToolPermissionGate creates a gate answering hook calls on port. Calls
+// covered by a rule of policy are decided without asking; every decision is
+// written to audit.
+func NewToolPermissionGate(pm *PermissionManager, policy *PermissionPolicy, audit *AuditLog, dm *DockerManager, port string, notify func(string, *PermissionRequest) error) *ToolPermissionGate {
+	return &ToolPermissionGate{
+		pm:            pm,
+		policy:        policy,
+		audit:         audit,
+		dockerManager: dm,
+		port:          port,
+		notify:        notify,
+		runs:          make(map[string]*permissionRun),
+	}
+}
+
+// register enables permission prompts for one run of the agent. It returns
+// the CLI arguments and environment for the run, and a release func that
+// revokes the run's token once it has finished.
+func (g *ToolPermissionGate) register(projectID string, agent *ClaudeAgent) ([]string, []string, func()) {
+	tokenBytes := make([]byte, 16)
+	rand.Read(tokenBytes)
+	token := hex.EncodeToString(tokenBytes)
+	inContainer := agent.containerID != ""
+
+	g.mu.Lock()
+	g.runs[token] = &permissionRun{projectID: projectID, inContainer: inContainer}
+	g.mu.Unlock()
+
+	release := func() {
+		g.mu.Lock()
+		delete(g.runs, token)
+		g.mu.Unlock()
+	}
+
+	url := fmt.Sprintf("http://%s:%s%s?token=%s", permissionCallbackHost(inContainer), g.port, permissionHookPath, token)
+	args := []string{"--permission-mode", "default", "--settings", permissionHookSettings}
+	return args, []string{permissionURLEnv + "=" + url}, release
+}
+
+// permissionCallbackHost is the server's address as seen by the CLI.
+// REMOTECLAUDE_CALLBACK_HOST overrides it, e.g. for containers on a network
+// without the host-gateway alias.
+func permissionCallbackHost(inContainer bool) string {
+	if host := os.Getenv("REMOTECLAUDE_CALLBACK_HOST"); host != "" {
+		return host
+	}
+	if inContainer {
+		return "host.docker.internal"
+	}
+	return "127.0.0.1"
+}
+
+// ErrUnknownRunToken is returned for hook calls from runs the gate does not
+// know, or no longer knows
+var ErrUnknownRunToken = errors.New("unknown or expired run token")
+
+// AnswerHook decides a PreToolUse hook call of the run holding token. It
+// blocks until the user decides or the request times out, which denies the
+// call; ctx ends with the hook call.
+func (g *ToolPermissionGate) AnswerHook(ctx context.Context, token string, input *toolHookInput) (*toolHookOutput, error) {
+	g.mu.Lock()
+	run := g.runs[token]
+	g.mu.Unlock()
+	if run == nil {
+		return nil, ErrUnknownRunToken
+	}
+
+	req := g.newPermissionRequest(run, input)
+	started := time.Now()
+	approved, reason, decidedBy := g.decide(ctx, req, run.root(input.Cwd))
+
+	audit := AuditContext{Client: "claude", Source: AuditSourceClaudeTool, Decision: AuditDecisionDeny, DecidedBy: decidedBy}
+	if approved {
+		audit.Decision = AuditDecisionAllow
+	}
+	g.audit.Record(audit, req.ProjectID, req.Tool+" "+req.Target, nil, time.Since(started), nil)
+
+	var out toolHookOutput
+	out.HookSpecificOutput.HookEventName = "PreToolUse"
+	out.HookSpecificOutput.PermissionDecision = "deny"
+	if approved {
+		out.HookSpecificOutput.PermissionDecision = "allow"
+	}
+	out.HookSpecificOutput.PermissionDecisionReason = reason
+	return &out, nil
+}
+
+// decide applies the permission rules to req, or asks the user and waits for
+// the answer. ctx ends with the hook call, e.g. when the run is cancelled.
+// It returns the decision, the reason given to Claude and who decided.
+func (g *ToolPermissionGate) decide(ctx context.Context, req *PermissionRequest, root string) (bool, string, string) {
+	decision, err := g.policy.Evaluate(DefaultUserID, req, root)
+	if err != nil {
+		log.Printf("⚠️ Failed to evaluate permission rules for %s: %v", req.ProjectID, err)
+	}
+	if decision.Rule != nil {
+		verdict := "Denied"
+		if decision.Effect == PolicyAllow {
+			verdict = "Allowed"
+		}
+		reason := fmt.Sprintf("%s by %s permission rule %s.", verdict, decision.Scope, decision.Rule.ID)
+		if decision.Rule.Comment != "" {
+			reason += " Comment: " + decision.Rule.Comment
+		}
+		log.Printf("📜 %s %s in %s: %s", reason, req.Tool, req.ProjectID, req.Target)
+		return decision.Effect == PolicyAllow, reason, fmt.Sprintf("rule:%s/%s", decision.Scope, decision.Rule.ID)
+	}
+
+	log.Printf("🔐 Asking permission for %s in %s: %s", req.Tool, req.ProjectID, req.Target)
+
+	g.pm.AddPendingRequest(req)
+	if err := g.notify(req.ProjectID, req); err != nil {
+		g.pm.RemovePendingRequest(req.RequestID)
+		return false, fmt.Sprintf("Could not ask the user for permission: %v", err), "error"
+	}
+
+	resp, err := g.pm.WaitForResponse(ctx, req.RequestID)
+	switch {
+	case errors.Is(err, ErrPermissionExpired):
+		return false, "The user did not answer the permission request in time. The operation was not performed.", "timeout"
+	case err != nil:
+		return false, fmt.Sprintf("The permission request was abandoned: %v", err), "canceled"
+	case !resp.Approved:
+		reason := "The user denied this operation."
+		if resp.UserComment != "" {
+			reason += " Comment: " + resp.UserComment
+		}
+		return false, reason, "user"
+	default:
+		reason := "Approved by the user."
+		if resp.UserComment != "" {
+			reason += " Comment: " + resp.UserComment
+		}
+		return true, reason, "user"
+	}
+}
+
+// newPermissionRequest describes a tool call with its exact arguments and a
+// preview: a unified diff for file edits, the command line for Bash
+func (g *ToolPermissionGate) newPermissionRequest(run *permissionRun, input *toolHookInput) *PermissionRequest {
+	req := &PermissionRequest{
+		RequestID: g.pm.generateRequestID(),
+		ProjectID: run.projectID,
+		Tool:      input.ToolName,
+		ToolInput: input.ToolInput,
+		ToolUseID: input.ToolUseID,
+		SessionID: input.SessionID,
+	}
+
+	var args struct {
+		FilePath     string `json:"file_path"`
+		NotebookPath string `json:"notebook_path"`
+		Content      string `json:"content"`
+		OldString    string `json:"old_string"`
+		NewString    string `json:"new_string"`
+		ReplaceAll   bool   `json:"replace_all"`
+		Edits        []struct {
+			OldString  string `json:"old_string"`
+			NewString  string `json:"new_string"`
+			ReplaceAll bool   `json:"replace_all"`
+		} `json:"edits"`
+		NewSource   string `json:"new_source"`
+		Command     string `json:"command"`
+		Description string `json:"description"`
+	}
+	json.Unmarshal(input.ToolInput, &args)
+
+	switch input.ToolName {
+	case "Write":
+		current, exists := g.readFile(run, args.FilePath)
+		req.Action = "modify_file"
+		if !exists {
+			req.Action = "create_file"
+		}
+		req.Target = args.FilePath
+		req.Description = fmt.Sprintf("Write file: %s", args.FilePath)
+		req.Preview = FormatUnifiedDiff(args.FilePath, current, args.Content)
+
+	case "Edit", "MultiEdit":
+		type edit struct {
+			old, new string
+			all      bool
+		}
+		edits := []edit{{args.OldString, args.NewString, args.ReplaceAll}}
+		if input.ToolName == "MultiEdit" {
+			edits = edits[:0]
+			for _, e := range args.Edits {
+				edits = append(edits, edit{e.OldString, e.NewString, e.ReplaceAll})
+			}
+		}
+
+		req.Action = "modify_file"
+		req.Target = args.FilePath
+		req.Description = fmt.Sprintf("Edit file: %s", args.FilePath)
+
+		// Diff the whole file when it can be read, otherwise just the replaced text
+		current, exists := g.readFile(run, args.FilePath)
+		updated := current
+		for _, e := range edits {
+			if !exists || !strings.Contains(updated, e.old) {
+				exists = false
+				break
+			}
+			n := 1
+			if e.all {
+				n = -1
+			}
+			updated = strings.Replace(updated, e.old, e.new, n)
+		}
+		if exists {
+			req.Preview = FormatUnifiedDiff(args.FilePath, current, updated)
+		} else {
+			var preview strings.Builder
+			for _, e := range edits {
+				preview.WriteString(FormatUnifiedDiff(args.FilePath, e.old+"\n", e.new+"\n"))
+			}
+			req.Preview = preview.String()
+		}
+
+	case "NotebookEdit":
+		req.Action = "modify_file"
+		req.Target = args.NotebookPath
+		req.Description = fmt.Sprintf("Edit notebook: %s", args.NotebookPath)
+		req.Preview = args.NewSource
+
+	case "Bash":
+		req.Action = "execute_command"
+		req.Target = args.Command
+		req.Description = "Run command"
+		if args.Description != "" {
+			req.Description = fmt.Sprintf("Run command: %s", args.Description)
+		}
+		req.Preview = args.Command
+
+	default:
+		req.Action = "use_tool"
+		req.Target = input.ToolName
+		req.Description = fmt.Sprintf("Use tool: %s", input.ToolName)
+		req.Preview = string(input.ToolInput)
+	}
+
+	if len(req.Preview) > maxPermissionPreview {
+		req.Preview = req.Preview[:maxPermissionPreview] + "\n… (truncated)"
+	}
+	return req
+}
+
+// readFile returns the current content of a file the tool call targets, from
+// the project container or the host depending on where the run happens
+func (g *ToolPermissionGate) readFile(run *permissionRun, path string) (string, bool) {
+	if path == "" {
+		return "", false
+	}
+	if run.inContainer {
+		content, err := g.dockerManager.ReadFile(run.projectID, path, 0, 0)
+		if err != nil {
+			return "", false
+		}
+		return string(content.Data), true
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
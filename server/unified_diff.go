@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DiffFile is one file section of a unified diff
+type DiffFile struct {
+	OldPath  string     `json:"old_path"`
+	NewPath  string     `json:"new_path"`
+	Status   string     `json:"status"` // "modified", "added", "deleted" or "renamed"
+	IsBinary bool       `json:"is_binary,omitempty"`
+	Hunks    []DiffHunk `json:"hunks"`
+}
+
+// DiffHunk is a single @@ section of a file diff
+type DiffHunk struct {
+	OldStart int      `json:"old_start"`
+	OldLines int      `json:"old_lines"`
+	NewStart int      `json:"new_start"`
+	NewLines int      `json:"new_lines"`
+	Section  string   `json:"section,omitempty"` // text after the closing @@, usually a function name
+	Lines    []string `json:"lines"`             // raw lines including the ' ', '+', '-' or '\' marker
+}
+
+// Path returns the path the file has after the diff is applied
+func (f *DiffFile) Path() string {
+	if f.NewPath != "" {
+		return f.NewPath
+	}
+	return f.OldPath
+}
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@ ?(.*)$`)
+
+// ParseUnifiedDiff parses git-style and plain unified diffs. Paths keep any
+// a/ and b/ prefixes so the caller can decide on the strip level.
+func ParseUnifiedDiff(text string) ([]DiffFile, error) {
+	var files []DiffFile
+	var current *DiffFile
+	var hunk *DiffHunk
+
+	flush := func() {
+		if current == nil {
+			return
+		}
+		if hunk != nil {
+			current.Hunks = append(current.Hunks, *hunk)
+			hunk = nil
+		}
+		if current.Status == "" {
+			switch {
+			case current.OldPath == "/dev/null":
+				current.Status = "added"
+			case current.NewPath == "/dev/null":
+				current.Status = "deleted"
+			case stripDiffPrefix(current.OldPath) != stripDiffPrefix(current.NewPath):
+				current.Status = "renamed"
+			default:
+				current.Status = "modified"
+			}
+		}
+		files = append(files, *current)
+		current = nil
+	}
+
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		// Lines still owed to the current hunk are always hunk content, even
+		// when they look like "--- " / "+++ " file headers
+		if hunk != nil && !hunk.complete() && isHunkLine(line) && (line != "" || i < len(lines)-1) {
+			if line == "" {
+				line = " "
+			}
+			hunk.Lines = append(hunk.Lines, line)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			flush()
+			current = &DiffFile{}
+			if oldPath, newPath, ok := parseGitDiffHeader(line); ok {
+				current.OldPath, current.NewPath = oldPath, newPath
+			}
+
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			// A ---/+++ pair starts a new file unless it follows a diff --git header
+			if current == nil || len(current.Hunks) > 0 || hunk != nil {
+				flush()
+				current = &DiffFile{}
+			}
+			current.OldPath = parseDiffFilePath(line[4:])
+			current.NewPath = parseDiffFilePath(lines[i+1][4:])
+			i++
+
+		case strings.HasPrefix(line, "@@ "):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: hunk without file header", i+1)
+			}
+			m := hunkHeaderRe.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("line %d: malformed hunk header: %s", i+1, line)
+			}
+			if hunk != nil {
+				current.Hunks = append(current.Hunks, *hunk)
+			}
+			hunk = &DiffHunk{
+				OldStart: atoiDefault(m[1], 0),
+				OldLines: atoiDefault(m[2], 1),
+				NewStart: atoiDefault(m[3], 0),
+				NewLines: atoiDefault(m[4], 1),
+				Section:  m[5],
+			}
+
+		case hunk != nil && line != "" && isHunkLine(line):
+			// Hand-written hunks may undercount; keep collecting content
+			hunk.Lines = append(hunk.Lines, line)
+
+		case current != nil && hunk == nil:
+			// Extended git headers between "diff --git" and the first hunk
+			switch {
+			case strings.HasPrefix(line, "new file mode"):
+				current.Status = "added"
+			case strings.HasPrefix(line, "deleted file mode"):
+				current.Status = "deleted"
+			case strings.HasPrefix(line, "rename from "):
+				current.Status = "renamed"
+				current.OldPath = "a/" + strings.TrimPrefix(line, "rename from ")
+			case strings.HasPrefix(line, "rename to "):
+				current.Status = "renamed"
+				current.NewPath = "b/" + strings.TrimPrefix(line, "rename to ")
+			case strings.HasPrefix(line, "Binary files ") || line == "GIT binary patch":
+				current.IsBinary = true
+			}
+		}
+	}
+	flush()
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file changes found in diff")
+	}
+	return files, nil
+}
+
+// complete reports whether the hunk holds as many lines as its header declares
+func (h *DiffHunk) complete() bool {
+	oldSeen, newSeen := 0, 0
+	for _, l := range h.Lines {
+		switch l[0] {
+		case ' ':
+			oldSeen++
+			newSeen++
+		case '-':
+			oldSeen++
+		case '+':
+			newSeen++
+		}
+	}
+	return oldSeen >= h.OldLines && newSeen >= h.NewLines
+}
+
+// isHunkLine reports whether line can belong to a hunk body. Empty lines are
+// accepted because some editors strip the space of blank context lines.
+func isHunkLine(line string) bool {
+	if line == "" {
+		return true
+	}
+	switch line[0] {
+	case ' ', '+', '-', '\\':
+		return true
+	}
+	return false
+}
+
+// parseGitDiffHeader splits "diff --git a/x b/y" into its two paths
+func parseGitDiffHeader(line string) (string, string, bool) {
+	rest := strings.TrimPrefix(line, "diff --git ")
+	if strings.HasPrefix(rest, `"`) {
+		// Quoted paths: fall back to the ---/+++ lines
+		return "", "", false
+	}
+	idx := strings.Index(rest, " b/")
+	if idx < 0 {
+		return "", "", false
+	}
+	return rest[:idx], rest[idx+1:], true
+}
+
+// parseDiffFilePath extracts the path from a ---/+++ line, dropping any
+// trailing timestamp and unquoting git's C-style quoted paths
+func parseDiffFilePath(s string) string {
+	if tab := strings.IndexByte(s, '\t'); tab >= 0 {
+		s = s[:tab]
+	}
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, `"`) {
+		if unquoted, err := strconv.Unquote(s); err == nil {
+			return unquoted
+		}
+	}
+	return s
+}
+
+// stripDiffPrefix removes a git a/ or b/ prefix
+func stripDiffPrefix(p string) string {
+	if strings.HasPrefix(p, "a/") || strings.HasPrefix(p, "b/") {
+		return p[2:]
+	}
+	return p
+}
+
+// diffStripLevel returns the -p level for the diff: 1 when every path carries
+// git's a/ and b/ prefixes, 0 otherwise (hand-written diffs)
+func diffStripLevel(files []DiffFile) int {
+	for _, f := range files {
+		for _, p := range []string{f.OldPath, f.NewPath} {
+			if p == "" || p == "/dev/null" {
+				continue
+			}
+			if !strings.HasPrefix(p, "a/") && !strings.HasPrefix(p, "b/") {
+				return 0
+			}
+		}
+	}
+	return 1
+}
+
+func atoiDefault(s string, def int) int {
+	if s == "" {
+		return def
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// diffContextLines is the number of unchanged lines around each change in
+// diffs produced by FormatUnifiedDiff
+const diffContextLines = 3
+
+// maxDiffCells bounds the line-matching table; larger inputs are shown as a
+// single hunk replacing every line
+const maxDiffCells = 4 << 20
+
+// FormatUnifiedDiff renders the change from oldText to newText as a unified
+// diff of path. An empty oldText is shown as a new file.
+func FormatUnifiedDiff(path, oldText, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+	oldLines, newLines := splitDiffLines(oldText), splitDiffLines(newText)
+
+	// ops holds one ' ', '-' or '+' line per entry, in output order
+	var ops []string
+	n, m := len(oldLines), len(newLines)
+	if n*m > maxDiffCells {
+		for _, line := range oldLines {
+			ops = append(ops, "-"+line)
+		}
+		for _, line := range newLines {
+			ops = append(ops, "+"+line)
+		}
+	} else {
+		// lcs[i][j] is the longest common subsequence of oldLines[i:] and newLines[j:]
+		lcs := make([][]int, n+1)
+		for i := range lcs {
+			lcs[i] = make([]int, m+1)
+		}
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if oldLines[i] == newLines[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < n || j < m {
+			switch {
+			case i < n && j < m && oldLines[i] == newLines[j]:
+				ops = append(ops, " "+oldLines[i])
+				i++
+				j++
+			case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+				ops = append(ops, "-"+oldLines[i])
+				i++
+			default:
+				ops = append(ops, "+"+newLines[j])
+				j++
+			}
+		}
+	}
+
+	oldName := "a/" + strings.TrimPrefix(path, "/")
+	if oldText == "" {
+		oldName = "/dev/null"
+	}
+	var out strings.Builder
+	out.WriteString(fmt.Sprintf("--- %s\n+++ b/%s\n", oldName, strings.TrimPrefix(path, "/")))
+
+	// Group changes that are close together into hunks
+	for start := 0; start < len(ops); {
+		if ops[start][0] == ' ' {
+			start++
+			continue
+		}
+		from := start - diffContextLines
+		if from < 0 {
+			from = 0
+		}
+		end, unchanged := start, 0
+		for end < len(ops) && unchanged <= 2*diffContextLines {
+			if ops[end][0] == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			end++
+		}
+		end -= unchanged
+		end += diffContextLines
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		oldStart, newStart := 1, 1
+		for _, op := range ops[:from] {
+			if op[0] != '+' {
+				oldStart++
+			}
+			if op[0] != '-' {
+				newStart++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, op := range ops[from:end] {
+			if op[0] != '+' {
+				oldCount++
+			}
+			if op[0] != '-' {
+				newCount++
+			}
+		}
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+
+		out.WriteString(fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount))
+		for _, op := range ops[from:end] {
+			out.WriteString(op)
+			out.WriteString("\n")
+		}
+		start = end
+	}
+	return out.String()
+}
+
+// splitDiffLines splits text into lines without their terminators
+func splitDiffLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExceeded is returned when a blocking usage budget is used up
+var ErrBudgetExceeded = errors.New("usage budget exceeded")
+
+// usageDateFormat keys daily rollups; days are counted in server local time
+const usageDateFormat = "2006-01-02"
+
+// UsageRecord is the usage of a single Claude run, stored on the assistant
+// ConversationMessage it produced
+type UsageRecord struct {
+	InputTokens              int64   `json:"input_tokens"`
+	OutputTokens             int64   `json:"output_tokens"`
+	CacheCreationInputTokens int64   `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int64   `json:"cache_read_input_tokens,omitempty"`
+	CostUSD                  float64 `json:"cost_usd"`
+	DurationMs               int64   `json:"duration_ms"`
+	NumTurns                 int     `json:"num_turns,omitempty"`
+}
+
+// newUsageRecord extracts the usage from a run's final result; nil when the
+// run produced no result
+func newUsageRecord(result *ClaudeResult) *UsageRecord {
+	if result == nil {
+		return nil
+	}
+	return &UsageRecord{
+		InputTokens:              result.Usage.InputTokens,
+		OutputTokens:             result.Usage.OutputTokens,
+		CacheCreationInputTokens: result.Usage.CacheCreationInputTokens,
+		CacheReadInputTokens:     result.Usage.CacheReadInputTokens,
+		CostUSD:                  result.TotalCostUSD,
+		DurationMs:               result.DurationMs,
+		NumTurns:                 result.NumTurns,
+	}
+}
+
+// UsageTotals sums the usage of a number of runs
+type UsageTotals struct {
+	Requests                 int64   `json:"requests"`
+	InputTokens              int64   `json:"input_tokens"`
+	OutputTokens             int64   `json:"output_tokens"`
+	CacheCreationInputTokens int64   `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int64   `json:"cache_read_input_tokens"`
+	CostUSD                  float64 `json:"cost_usd"`
+	DurationMs               int64   `json:"duration_ms"`
+}
+
+// Tokens is the number of tokens counted against token budgets
+func (t UsageTotals) Tokens() int64 {
+	return t.InputTokens + t.OutputTokens + t.CacheCreationInputTokens + t.CacheReadInputTokens
+}
+
+// add accumulates one run
+func (t *UsageTotals) add(u *UsageRecord) {
+	t.Requests++
+	t.InputTokens += u.InputTokens
+	t.OutputTokens += u.OutputTokens
+	t.CacheCreationInputTokens += u.CacheCreationInputTokens
+	t.CacheReadInputTokens += u.CacheReadInputTokens
+	t.CostUSD += u.CostUSD
+	t.DurationMs += u.DurationMs
+}
+
+// merge accumulates another total
+func (t *UsageTotals) merge(o UsageTotals) {
+	t.Requests += o.Requests
+	t.InputTokens += o.InputTokens
+	t.OutputTokens += o.OutputTokens
+	t.CacheCreationInputTokens += o.CacheCreationInputTokens
+	t.CacheReadInputTokens += o.CacheReadInputTokens
+	t.CostUSD += o.CostUSD
+	t.DurationMs += o.DurationMs
+}
+
+// DailyUsage is the rollup for one user, project and day
+type DailyUsage struct {
+	Date      string `json:"date"` // YYYY-MM-DD
+	UserID    string `json:"user_id"`
+	ProjectID string `json:"project_id"`
+	UsageTotals
+}
+
+// UsageQuery filters a usage report. Empty fields match everything; From and
+// To are inclusive YYYY-MM-DD dates.
+type UsageQuery struct {
+	UserID    string `json:"user_id,omitempty"`
+	ProjectID string `json:"project_id,omitempty"`
+	From      string `json:"from,omitempty"`
+	To        string `json:"to,omitempty"`
+}
+
+// UsageReport is the answer to a UsageQuery
+type UsageReport struct {
+	Query     UsageQuery             `json:"query"`
+	Total     UsageTotals            `json:"total"`
+	ByProject map[string]UsageTotals `json:"by_project"`
+	ByUser    map[string]UsageTotals `json:"by_user"`
+	ByDay     []DailyUsage           `json:"by_day"` // one entry per day, summed over the filtered users and projects
+}
+
+// UsageTracker records per-run usage into daily rollups persisted under
+// ~/.remoteclaude/usage
+type UsageTracker struct {
+	path string
+
+	mu    sync.Mutex
+	daily map[string]*DailyUsage // date|user|project -> rollup
+}
+
+// NewUsageTracker creates a tracker and loads previously recorded usage
+func NewUsageTracker() *UsageTracker {
+	usageDir := filepath.Join(os.Getenv("HOME"), ".remoteclaude", "usage")
+	os.MkdirAll(usageDir, 0755)
+
+	ut := &UsageTracker{
+		path:  filepath.Join(usageDir, "daily.json"),
+		daily: make(map[string]*DailyUsage),
+	}
+	if err := ut.load(); err != nil {
+		log.Printf("⚠️ Failed to load usage history: %v", err)
+	}
+	return ut
+}
+
+func usageKey(date, userID, projectID string) string {
+	return date + "|" + userID + "|" + projectID
+}
+
+// load reads the rollups written by save
+func (ut *UsageTracker) load() error {
+	data, err := ioutil.ReadFile(ut.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var days []*DailyUsage
+	if err := json.Unmarshal(data, &days); err != nil {
+		return fmt.Errorf("failed to unmarshal usage: %v", err)
+	}
+	for _, day := range days {
+		ut.daily[usageKey(day.Date, day.UserID, day.ProjectID)] = day
+	}
+	return nil
+}
+
+// save writes all rollups; the caller holds ut.mu
+func (ut *UsageTracker) save() error {
+	days := make([]*DailyUsage, 0, len(ut.daily))
+	for _, day := range ut.daily {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return usageKey(days[i].Date, days[i].UserID, days[i].ProjectID) < usageKey(days[j].Date, days[j].UserID, days[j].ProjectID)
+	})
+
+	data, err := json.MarshalIndent(days, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage: %v", err)
+	}
+	tmp := ut.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ut.path)
+}
+
+// Record adds one run to today's rollup for userID and projectID
+func (ut *UsageTracker) Record(userID, projectID string, usage *UsageRecord) {
+	if usage == nil {
+		return
+	}
+	date := time.Now().Format(usageDateFormat)
+	key := usageKey(date, userID, projectID)
+
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	day, exists := ut.daily[key]
+	if !exists {
+		day = &DailyUsage{Date: date, UserID: userID, ProjectID: projectID}
+		ut.daily[key] = day
+	}
+	day.add(usage)
+
+	if err := ut.save(); err != nil {
+		log.Printf("⚠️ Failed to save usage: %v", err)
+	}
+	log.Printf("💰 Recorded usage for %s/%s: %d in, %d out, $%.4f", userID, projectID, usage.InputTokens, usage.OutputTokens, usage.CostUSD)
+}
+
+// Report aggregates the rollups matching q
+func (ut *UsageTracker) Report(q UsageQuery) *UsageReport {
+	report := &UsageReport{
+		Query:     q,
+		ByProject: make(map[string]UsageTotals),
+		ByUser:    make(map[string]UsageTotals),
+		ByDay:     []DailyUsage{},
+	}
+	byDay := make(map[string]*DailyUsage)
+
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+
+	for _, day := range ut.daily {
+		if (q.UserID != "" && day.UserID != q.UserID) ||
+			(q.ProjectID != "" && day.ProjectID != q.ProjectID) ||
+			(q.From != "" && day.Date < q.From) ||
+			(q.To != "" && day.Date > q.To) {
+			continue
+		}
+
+		report.Total.merge(day.UsageTotals)
+
+		project := report.ByProject[day.ProjectID]
+		project.merge(day.UsageTotals)
+		report.ByProject[day.ProjectID] = project
+
+		user := report.ByUser[day.UserID]
+		user.merge(day.UsageTotals)
+		report.ByUser[day.UserID] = user
+
+		summed, exists := byDay[day.Date]
+		if !exists {
+			summed = &DailyUsage{Date: day.Date, UserID: q.UserID, ProjectID: q.ProjectID}
+			byDay[day.Date] = summed
+		}
+		summed.merge(day.UsageTotals)
+	}
+
+	for _, day := range byDay {
+		report.ByDay = append(report.ByDay, *day)
+	}
+	sort.Slice(report.ByDay, func(i, j int) bool { return report.ByDay[i].Date < report.ByDay[j].Date })
+	return report
+}
+
+// Budget enforcement modes
+const (
+	BudgetEnforceWarn  = "warn"  // report, but keep running requests (default)
+	BudgetEnforceBlock = "block" // refuse new Claude requests once a limit is reached
+)
+
+// UsageBudget caps a user's Claude spending. A zero limit is not enforced.
+type UsageBudget struct {
+	DailyCostUSD        float64 `json:"daily_cost_usd,omitempty"`
+	MonthlyCostUSD      float64 `json:"monthly_cost_usd,omitempty"`
+	DailyTokens         int64   `json:"daily_tokens,omitempty"`
+	ProjectDailyCostUSD float64 `json:"project_daily_cost_usd,omitempty"` // per project, on top of the user-wide limits
+	Enforcement         string  `json:"enforcement,omitempty"`            // "warn" or "block"; empty means warn
+	WarnAtPercent       int     `json:"warn_at_percent,omitempty"`        // warn from this share of a limit; 0 means 80
+}
+
+// BudgetLimitStatus is the state of one budget limit
+type BudgetLimitStatus struct {
+	Limit   string  `json:"limit"` // e.g. "daily_cost_usd"
+	Max     float64 `json:"max"`
+	Used    float64 `json:"used"`
+	Percent float64 `json:"percent"`
+}
+
+// BudgetStatus compares current usage to a UsageBudget
+type BudgetStatus struct {
+	Enforcement string              `json:"enforcement"`
+	Limits      []BudgetLimitStatus `json:"limits"`
+	Warnings    []string            `json:"warnings,omitempty"`
+	Exceeded    bool                `json:"exceeded"`
+	Blocked     bool                `json:"blocked"` // Exceeded with block enforcement
+}
+
+// CheckBudget evaluates userID's budget, including the per-project limit for projectID
+func (ut *UsageTracker) CheckBudget(userID, projectID string, budget UsageBudget) *BudgetStatus {
+	now := time.Now()
+	today := now.Format(usageDateFormat)
+	monthStart := now.Format("2006-01") + "-01"
+
+	status := &BudgetStatus{Enforcement: budget.Enforcement, Limits: []BudgetLimitStatus{}}
+	if status.Enforcement == "" {
+		status.Enforcement = BudgetEnforceWarn
+	}
+	warnAt := float64(budget.WarnAtPercent)
+	if warnAt <= 0 {
+		warnAt = 80
+	}
+
+	check := func(limit string, max, used float64) {
+		if max <= 0 {
+			return
+		}
+		ls := BudgetLimitStatus{Limit: limit, Max: max, Used: used, Percent: used / max * 100}
+		status.Limits = append(status.Limits, ls)
+		switch {
+		case used >= max:
+			status.Exceeded = true
+			status.Warnings = append(status.Warnings, fmt.Sprintf("%s budget exceeded: %.4g of %.4g", limit, used, max))
+		case ls.Percent >= warnAt:
+			status.Warnings = append(status.Warnings, fmt.Sprintf("%s budget at %.0f%%: %.4g of %.4g", limit, ls.Percent, used, max))
+		}
+	}
+
+	daily := ut.Report(UsageQuery{UserID: userID, From: today, To: today}).Total
+	check("daily_cost_usd", budget.DailyCostUSD, daily.CostUSD)
+	check("daily_tokens", float64(budget.DailyTokens), float64(daily.Tokens()))
+	if budget.MonthlyCostUSD > 0 {
+		monthly := ut.Report(UsageQuery{UserID: userID, From: monthStart, To: today}).Total
+		check("monthly_cost_usd", budget.MonthlyCostUSD, monthly.CostUSD)
+	}
+	if budget.ProjectDailyCostUSD > 0 && projectID != "" {
+		project := ut.Report(UsageQuery{UserID: userID, ProjectID: projectID, From: today, To: today}).Total
+		check("project_daily_cost_usd", budget.ProjectDailyCostUSD, project.CostUSD)
+	}
+
+	status.Blocked = status.Exceeded && status.Enforcement == BudgetEnforceBlock
+	return status
+}
+
+// budgetStatus loads the default user's budget and evaluates it for projectID
+func (s *Server) budgetStatus(projectID string) *BudgetStatus {
+	userConfig, err := s.configManager.LoadUserConfig(DefaultUserID)
+	if err != nil {
+		log.Printf("⚠️ Failed to load user config for budget check: %v", err)
+		userConfig = s.configManager.getDefaultUserConfig(DefaultUserID)
+	}
+	return s.usageTracker.CheckBudget(DefaultUserID, projectID, userConfig.Budget)
+}
+
+// checkBudget refuses a new Claude run once a blocking budget is used up
+func (s *Server) checkBudget(projectID string) error {
+	status := s.budgetStatus(projectID)
+	if status.Blocked {
+		return fmt.Errorf("%w: %s", ErrBudgetExceeded, status.Warnings[0])
+	}
+	return nil
+}
+
+// recordUsage rolls a finished run's usage into the project and user totals
+func (s *Server) recordUsage(projectID string, usage *UsageRecord) {
+	s.usageTracker.Record(DefaultUserID, projectID, usage)
+}
+
+// usageQueryFromMap reads a UsageQuery from message data or URL parameters.
+// Without a date range the last 30 days are reported.
+func usageQueryFromMap(get func(string) string) (UsageQuery, error) {
+	q := UsageQuery{
+		UserID:    get("user_id"),
+		ProjectID: get("project_id"),
+		From:      get("from"),
+		To:        get("to"),
+	}
+	for _, date := range []string{q.From, q.To} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse(usageDateFormat, date); err != nil {
+			return q, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", date)
+		}
+	}
+	if q.From == "" && q.To == "" {
+		q.From = time.Now().AddDate(0, 0, -29).Format(usageDateFormat)
+	}
+	return q, nil
+}
@@ -521,7 +521,13 @@ func (wi *WebInterface) executeSudoCommand(command []string, password string) (s
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 	
+	started := time.Now()
 	err := cmd.Run()
+	wi.server.auditLog.Record(AuditContext{
+		Client:   "web",
+		Source:   AuditSourceSudo,
+		Decision: AuditDecisionPassword,
+	}, "", strings.Join(command, " "), processExitCode(err), time.Since(started), err)
 	
 	// Combine stdout and stderr for output
 	output := stdout.String()
@@ -951,11 +957,31 @@ func (wi *WebInterface) sendErrorResponse(w http.ResponseWriter, errorMsg string
 func (wi *WebInterface) handleSyncProjects(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
+	// Same sorting and filtering as project_list_request, from the query
+	query := map[string]interface{}{}
+	for _, key := range []string{"sort", "tag", "owner", "active_within"} {
+		if value := r.URL.Query().Get(key); value != "" {
+			query[key] = value
+		}
+	}
+	if r.URL.Query().Get("include_missing") == "false" {
+		query["include_missing"] = false
+	}
+	if r.URL.Query().Get("include_trash") == "true" {
+		query["include_trash"] = true
+	}
+	opts, err := projectListOptionsFromMap(query)
+	if err != nil {
+		wi.sendErrorResponse(w, err.Error())
+		return
+	}
+
 	projects, err := wi.server.dockerManager.ListProjects()
 	if err != nil {
 		wi.sendErrorResponse(w, fmt.Sprintf("Failed to list projects: %v", err))
 		return
 	}
+	projects = FilterProjects(projects, opts)
 
 	// Convert projects to web-friendly format
 	var webProjects []map[string]interface{}
@@ -965,9 +991,13 @@ func (wi *WebInterface) handleSyncProjects(w http.ResponseWriter, r *http.Reques
 			"name":          project.Name,
 			"type":          project.Type,
 			"status":        project.Status,
-			"container_id":  project.ContainerID[:12],
+			"container_id":  shortContainerID(project.ContainerID),
 			"created_at":    project.CreatedAt.Format("2006-01-02 15:04:05"),
 			"last_access":   project.LastAccess.Format("2006-01-02 15:04:05"),
+			"owner":         project.Owner,
+			"tags":          project.Tags,
+			"missing":       project.Status == ProjectStatusMissing,
+			"error":         project.Error,
 		})
 	}
 
@@ -1036,6 +1066,72 @@ func (wi *WebInterface) handleSyncSessions(w http.ResponseWriter, r *http.Reques
 	json.NewEncoder(w).Encode(response)
 }
 
+// handleUsage returns Claude usage totals filtered by user_id, project_id,
+// from and to, together with the current budget status
+func (wi *WebInterface) handleUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	q, err := usageQueryFromMap(r.URL.Query().Get)
+	if err != nil {
+		wi.sendErrorResponse(w, err.Error())
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"report": wi.server.usageTracker.Report(q),
+			"budget": wi.server.budgetStatus(q.ProjectID),
+		},
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleAudit returns audit log entries filtered by user_id, project_id,
+// client, source, decision, from, to and limit
+func (wi *WebInterface) handleAudit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	q, err := auditQueryFromMap(r.URL.Query().Get)
+	if err != nil {
+		wi.sendErrorResponse(w, err.Error())
+		return
+	}
+	entries, err := wi.server.auditLog.Query(q)
+	if err != nil {
+		wi.sendErrorResponse(w, fmt.Sprintf("Failed to read audit log: %v", err))
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"entries": entries,
+			"count":   len(entries),
+			"query":   q,
+		},
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleAuditVerify checks the audit log's hash chain
+func (wi *WebInterface) handleAuditVerify(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	verification := wi.server.auditLog.Verify()
+	response := APIResponse{
+		Success: true,
+		Data:    verification,
+	}
+	if !verification.Valid {
+		response.Message = "Audit log has been modified"
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
 // handleStatusStream provides Server-Sent Events for real-time status updates
 func (wi *WebInterface) handleStatusStream(w http.ResponseWriter, r *http.Request) {
 	// Set headers for Server-Sent Events
@@ -1154,6 +1250,9 @@ func (wi *WebInterface) StartWebServer() {
 	webMux.HandleFunc("/api/logs", wi.handleLogs)
 	webMux.HandleFunc("/api/wireguard-qr", wi.handleWireGuardQR)
 	webMux.HandleFunc("/api/vpn-connection-qr", wi.handleVPNConnectionQR)
+	webMux.HandleFunc("/api/usage", wi.handleUsage)
+	webMux.HandleFunc("/api/audit", wi.handleAudit)
+	webMux.HandleFunc("/api/audit/verify", wi.handleAuditVerify)
 
 	// Web-Mobile Synchronization APIs
 	webMux.HandleFunc("/api/sync/projects", wi.handleSyncProjects)
@@ -1173,4 +1272,28 @@ func (wi *WebInterface) StartWebServer() {
 			log.Printf("❌ Web server failed to start: %v", err)
 		}
 	}()
-}
\ No newline at end of file
+}
+
+// handlePermissionHook answers the PreToolUse hook calls of Claude runs.
+// The response blocks until the user decides.
+func (s *Server) handlePermissionHook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var input toolHookInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "invalid hook input", http.StatusBadRequest)
+		return
+	}
+
+	out, err := s.permissionGate.AnswerHook(r.Context(), r.URL.Query().Get("token"), &input)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// workspaceRoot is where every project volume is mounted inside its container
+const workspaceRoot = "/workspace"
+
+// ErrPathOutsideWorkspace is returned for paths that resolve outside /workspace
+var ErrPathOutsideWorkspace = errors.New("path is outside " + workspaceRoot)
+
+// exit codes used by the workspace shell snippets below
+const (
+	exitPathEscape = 3
+	exitNotFile    = 4
+)
+
+// workspaceGuard is prepended to every file operation script. It resolves
+// symlinks inside the container so a link pointing out of /workspace is
+// rejected as well as a literal "../" path.
+const workspaceGuard = `inside() { case "$(realpath -m -- "$1")" in /workspace|/workspace/*) return 0;; esac; echo "path escapes /workspace: $1" >&2; exit 3; }; `
+
+// FileEntry describes a single file or directory inside a workspace
+type FileEntry struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Type    string    `json:"type"` // "file", "dir", "symlink" or "other"
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// FileContent is the payload returned for a (possibly partial) file read
+type FileContent struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	Size   int64  `json:"size"`
+	Data   []byte `json:"-"`
+}
+
+// resolveWorkspacePath maps a user supplied path onto an absolute, cleaned
+// path inside /workspace. Relative paths are taken relative to /workspace.
+func resolveWorkspacePath(p string) (string, error) {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	if strings.ContainsRune(p, 0) {
+		return "", fmt.Errorf("invalid path: %q", p)
+	}
+
+	var resolved string
+	if path.IsAbs(p) {
+		resolved = path.Clean(p)
+	} else {
+		resolved = path.Join(workspaceRoot, p)
+	}
+
+	if resolved != workspaceRoot && !strings.HasPrefix(resolved, workspaceRoot+"/") {
+		return "", fmt.Errorf("%w: %s", ErrPathOutsideWorkspace, p)
+	}
+	return resolved, nil
+}
+
+// ExecArgs runs argv inside the project container without going through a
+// shell. stdin may be nil. Like Exec, a non-zero exit is reported via ExitCode.
+func (dm *DockerManager) ExecArgs(projectID string, argv []string, stdin io.Reader) (*ExecResult, error) {
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	// Find container for project
+	containerID, err := dm.getContainerID(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if container is running and start if necessary
+	if err := dm.ensureContainerRunning(containerID, projectID); err != nil {
+		return nil, fmt.Errorf("failed to ensure container is running: %w", err)
+	}
+
+	return dm.execContainer(context.Background(), containerID, argv, nil, stdin)
+}
+
+// runWorkspaceScript runs a guarded /bin/sh snippet with the given positional
+// arguments and converts a non-zero exit into an error.
+func (dm *DockerManager) runWorkspaceScript(projectID, script string, args []string, stdin io.Reader) (*ExecResult, error) {
+	argv := append([]string{"/bin/sh", "-c", workspaceGuard + script, "sh"}, args...)
+	result, err := dm.ExecArgs(projectID, argv, stdin)
+	if err != nil {
+		return nil, err
+	}
+
+	switch result.ExitCode {
+	case 0:
+		return result, nil
+	case exitPathEscape:
+		return nil, fmt.Errorf("%w: %s", ErrPathOutsideWorkspace, strings.TrimSpace(string(result.Stderr)))
+	default:
+		msg := strings.TrimSpace(string(result.Stderr))
+		if msg == "" {
+			msg = fmt.Sprintf("exit status %d", result.ExitCode)
+		}
+		return nil, fmt.Errorf("%s", msg)
+	}
+}
+
+// ReadFile reads length bytes starting at offset. A length of zero or less
+// reads to the end of the file.
+func (dm *DockerManager) ReadFile(projectID, filePath string, offset, length int64) (*FileContent, error) {
+	target, err := resolveWorkspacePath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("invalid offset: %d", offset)
+	}
+
+	// First line of output is the file size, the raw bytes follow
+	script := `inside "$1"; [ -f "$1" ] || { echo "not a regular file: $1" >&2; exit 4; }; ` +
+		`stat -c %s -- "$1"; ` +
+		`if [ "$3" -gt 0 ]; then dd if="$1" bs=65536 iflag=skip_bytes,count_bytes skip="$2" count="$3" status=none; ` +
+		`else tail -c +"$(($2 + 1))" -- "$1"; fi`
+	result, err := dm.runWorkspaceScript(projectID, script,
+		[]string{target, strconv.FormatInt(offset, 10), strconv.FormatInt(length, 10)}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	header, data, found := bytes.Cut(result.Stdout, []byte("\n"))
+	if !found {
+		return nil, fmt.Errorf("unexpected read output for %s", target)
+	}
+	size, err := strconv.ParseInt(string(header), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file size: %v", err)
+	}
+
+	return &FileContent{
+		Path:   target,
+		Offset: offset,
+		Length: int64(len(data)),
+		Size:   size,
+		Data:   data,
+	}, nil
+}
+
+// WriteFile replaces the file with data, creating parent directories. The
+// content is written to a temporary file first and renamed into place.
+func (dm *DockerManager) WriteFile(projectID, filePath string, data []byte) (string, error) {
+	target, err := resolveWorkspacePath(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	script := `inside "$1"; mkdir -p -- "$(dirname -- "$1")" && ` +
+		`tmp="$1.rc-tmp.$$" && cat > "$tmp" && mv -f -- "$tmp" "$1"`
+	if _, err := dm.runWorkspaceScript(projectID, script, []string{target}, bytes.NewReader(data)); err != nil {
+		return "", err
+	}
+
+	log.Printf("📝 Wrote %d bytes to %s in %s", len(data), target, projectID)
+	return target, nil
+}
+
+// AppendFile appends data to the file, creating it if needed
+func (dm *DockerManager) AppendFile(projectID, filePath string, data []byte) (string, error) {
+	target, err := resolveWorkspacePath(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	script := `inside "$1"; mkdir -p -- "$(dirname -- "$1")" && cat >> "$1"`
+	if _, err := dm.runWorkspaceScript(projectID, script, []string{target}, bytes.NewReader(data)); err != nil {
+		return "", err
+	}
+
+	log.Printf("📝 Appended %d bytes to %s in %s", len(data), target, projectID)
+	return target, nil
+}
+
+// DeletePath removes a file, or a directory tree when recursive is set
+func (dm *DockerManager) DeletePath(projectID, filePath string, recursive bool) (string, error) {
+	target, err := resolveWorkspacePath(filePath)
+	if err != nil {
+		return "", err
+	}
+	if target == workspaceRoot {
+		return "", fmt.Errorf("refusing to delete %s itself", workspaceRoot)
+	}
+
+	script := `inside "$1"; rm -- "$1"`
+	if recursive {
+		script = `inside "$1"; rm -r -- "$1"`
+	}
+	if _, err := dm.runWorkspaceScript(projectID, script, []string{target}, nil); err != nil {
+		return "", err
+	}
+
+	log.Printf("🗑️ Deleted %s in %s", target, projectID)
+	return target, nil
+}
+
+// MovePath renames src to dst, creating the destination's parent directory
+func (dm *DockerManager) MovePath(projectID, src, dst string) (string, string, error) {
+	from, err := resolveWorkspacePath(src)
+	if err != nil {
+		return "", "", err
+	}
+	to, err := resolveWorkspacePath(dst)
+	if err != nil {
+		return "", "", err
+	}
+	if from == workspaceRoot {
+		return "", "", fmt.Errorf("refusing to move %s itself", workspaceRoot)
+	}
+
+	script := `inside "$1"; inside "$2"; mkdir -p -- "$(dirname -- "$2")" && mv -- "$1" "$2"`
+	if _, err := dm.runWorkspaceScript(projectID, script, []string{from, to}, nil); err != nil {
+		return "", "", err
+	}
+
+	log.Printf("📦 Moved %s to %s in %s", from, to, projectID)
+	return from, to, nil
+}
+
+// MakeDir creates a directory and any missing parents
+func (dm *DockerManager) MakeDir(projectID, dirPath string) (string, error) {
+	target, err := resolveWorkspacePath(dirPath)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := dm.runWorkspaceScript(projectID, `inside "$1"; mkdir -p -- "$1"`, []string{target}, nil); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// ListDir lists the direct children of a workspace directory
+func (dm *DockerManager) ListDir(projectID, dirPath string) ([]FileEntry, error) {
+	target, err := resolveWorkspacePath(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	script := `inside "$1"; [ -d "$1" ] || { echo "not a directory: $1" >&2; exit 4; }; ` +
+		`find "$1" -mindepth 1 -maxdepth 1 -printf '%y\t%s\t%T@\t%m\t%f\0'`
+	result, err := dm.runWorkspaceScript(projectID, script, []string{target}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []FileEntry{}
+	for _, record := range strings.Split(string(result.Stdout), "\x00") {
+		if record == "" {
+			continue
+		}
+		entry, ok := parseFindRecord(record)
+		if !ok {
+			continue
+		}
+		entry.Path = path.Join(target, entry.Name)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// parseFindRecord parses one "%y\t%s\t%T@\t%m\t<name>" record from find -printf
+func parseFindRecord(record string) (FileEntry, bool) {
+	parts := strings.SplitN(record, "\t", 5)
+	if len(parts) != 5 {
+		return FileEntry{}, false
+	}
+
+	size, _ := strconv.ParseInt(parts[1], 10, 64)
+	var modTime time.Time
+	if secs, err := strconv.ParseFloat(parts[2], 64); err == nil {
+		modTime = time.Unix(0, int64(secs*float64(time.Second))).UTC()
+	}
+
+	return FileEntry{
+		Name:    parts[4],
+		Type:    findTypeName(parts[0]),
+		Size:    size,
+		Mode:    parts[3],
+		ModTime: modTime,
+	}, true
+}
+
+// findTypeName converts a find %y type letter into a readable type
+func findTypeName(t string) string {
+	switch t {
+	case "f":
+		return "file"
+	case "d":
+		return "dir"
+	case "l":
+		return "symlink"
+	default:
+		return "other"
+	}
+}
+
+// PatchResult reports the outcome of checking or applying a unified diff
+type PatchResult struct {
+	Applied   bool              `json:"applied"`
+	CheckOnly bool              `json:"check_only"`
+	Files     []PatchFileResult `json:"files"`
+	Error     string            `json:"error,omitempty"`
+	Output    string            `json:"output,omitempty"` // raw git apply output
+}
+
+// PatchFileResult is the per-file part of a PatchResult
+type PatchFileResult struct {
+	Path        string             `json:"path"`
+	Status      string             `json:"status"` // diff status: modified, added, deleted, renamed
+	Hunks       int                `json:"hunks"`
+	OK          bool               `json:"ok"`
+	Error       string             `json:"error,omitempty"`
+	FailedHunks []PatchHunkFailure `json:"failed_hunks,omitempty"`
+}
+
+// PatchHunkFailure identifies a hunk that did not apply
+type PatchHunkFailure struct {
+	Index    int    `json:"index"` // 1-based position within the file
+	OldStart int    `json:"old_start"`
+	Section  string `json:"section,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+var (
+	patchHunkFailedRe = regexp.MustCompile(`^error: patch failed: (.+):(\d+)$`)
+	patchFileErrorRe  = regexp.MustCompile(`^error: (.+?): (No such file or directory|does not exist in index|already exists in working directory|patch does not apply|does not match index)$`)
+)
+
+// ApplyPatch checks that diff applies cleanly inside /workspace and, unless
+// checkOnly is set, applies it. git apply is all-or-nothing, so either every
+// file is patched or none is. A patch that does not apply is reported through
+// the result rather than as an error.
+func (dm *DockerManager) ApplyPatch(projectID, diff string, checkOnly bool) (*PatchResult, error) {
+	files, err := ParseUnifiedDiff(diff)
+	if err != nil {
+		return nil, fmt.Errorf("invalid diff: %v", err)
+	}
+
+	strip := diffStripLevel(files)
+	result := &PatchResult{CheckOnly: checkOnly}
+	for _, f := range files {
+		filePath := f.Path()
+		if filePath == "/dev/null" {
+			filePath = f.OldPath
+		}
+		if strip == 1 {
+			filePath = stripDiffPrefix(filePath)
+		}
+		if _, err := resolveWorkspacePath(filePath); err != nil {
+			return nil, err
+		}
+		result.Files = append(result.Files, PatchFileResult{
+			Path:   filePath,
+			Status: f.Status,
+			Hunks:  len(f.Hunks),
+			OK:     true,
+		})
+	}
+
+	args := []string{"git", "apply", "-v", "--recount", "--whitespace=nowarn", fmt.Sprintf("-p%d", strip)}
+	check, err := dm.ExecArgs(projectID, append(append([]string{}, args...), "--check", "-"), strings.NewReader(diff))
+	if err != nil {
+		return nil, err
+	}
+	result.Output = string(check.Stderr)
+	if check.ExitCode != 0 {
+		recordPatchFailures(result, files, string(check.Stderr))
+		log.Printf("⚠️ Patch does not apply cleanly in %s", projectID)
+		return result, nil
+	}
+	if checkOnly {
+		return result, nil
+	}
+
+	applied, err := dm.ExecArgs(projectID, append(args, "-"), strings.NewReader(diff))
+	if err != nil {
+		return nil, err
+	}
+	result.Output = string(applied.Stderr)
+	if applied.ExitCode != 0 {
+		recordPatchFailures(result, files, string(applied.Stderr))
+		return result, nil
+	}
+
+	result.Applied = true
+	log.Printf("🩹 Applied patch to %d file(s) in %s", len(result.Files), projectID)
+	return result, nil
+}
+
+// recordPatchFailures maps git apply's error output back onto files and hunks
+func recordPatchFailures(result *PatchResult, files []DiffFile, output string) {
+	fileIndex := func(p string) int {
+		for i := range result.Files {
+			if result.Files[i].Path == p {
+				return i
+			}
+		}
+		return -1
+	}
+
+	var searched []string
+	searching := false
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case line == "error: while searching for:":
+			searching = true
+			searched = nil
+
+		case patchHunkFailedRe.MatchString(line):
+			searching = false
+			m := patchHunkFailedRe.FindStringSubmatch(line)
+			i := fileIndex(m[1])
+			if i < 0 {
+				continue
+			}
+			oldStart, _ := strconv.Atoi(m[2])
+			failure := PatchHunkFailure{
+				OldStart: oldStart,
+				Reason:   "context does not match",
+			}
+			if len(searched) > 0 {
+				failure.Reason = "context does not match:\n" + strings.TrimRight(strings.Join(searched, "\n"), "\n")
+			}
+			for h, hunk := range files[i].Hunks {
+				if hunk.OldStart == oldStart {
+					failure.Index = h + 1
+					failure.Section = hunk.Section
+					break
+				}
+			}
+			result.Files[i].OK = false
+			result.Files[i].FailedHunks = append(result.Files[i].FailedHunks, failure)
+
+		case patchFileErrorRe.MatchString(line):
+			searching = false
+			m := patchFileErrorRe.FindStringSubmatch(line)
+			if i := fileIndex(m[1]); i >= 0 {
+				result.Files[i].OK = false
+				if result.Files[i].Error == "" {
+					result.Files[i].Error = m[2]
+				}
+			}
+
+		case searching:
+			searched = append(searched, line)
+
+		case strings.HasPrefix(line, "error: ") || strings.HasPrefix(line, "fatal: "):
+			if result.Error == "" {
+				result.Error = strings.TrimPrefix(strings.TrimPrefix(line, "error: "), "fatal: ")
+			}
+		}
+	}
+
+	if result.Error == "" {
+		result.Error = "patch does not apply"
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotFormatVersion is the layout of snapshot archives: a gzipped tar
+// of the contents of /workspace
+const snapshotFormatVersion = 1
+
+// snapshotTimeout bounds taking or restoring one snapshot
+const snapshotTimeout = 30 * time.Minute
+
+// maxAutomaticSnapshots is how many of the snapshots taken by restores and
+// clones are kept per project; older ones are deleted
+const maxAutomaticSnapshots = 5
+
+// ErrSnapshotNotFound is returned for unknown snapshot IDs
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// snapshotIDPattern is what snapshot and project IDs must look like to be
+// used as file names
+var snapshotIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Snapshot describes one archived state of a project workspace
+type Snapshot struct {
+	ID            string            `json:"id"`
+	ProjectID     string            `json:"project_id"`
+	ProjectName   string            `json:"project_name"`
+	ProjectType   string            `json:"project_type"`
+	Label         string            `json:"label,omitempty"`
+	Automatic     bool              `json:"automatic,omitempty"` // taken by a restore or clone, pruned
+	CreatedAt     time.Time         `json:"created_at"`
+	CreatedBy     string            `json:"created_by,omitempty"`
+	Size          int64             `json:"size"`
+	SHA256        string            `json:"sha256"`
+	FormatVersion int               `json:"format_version"`
+	Config        map[string]string `json:"config,omitempty"`
+	Resources     ResourceLimits    `json:"resources"`
+}
+
+// SnapshotStore keeps snapshots under ~/.remoteclaude/snapshots/<project>,
+// each as <id>.tar.gz with its metadata in <id>.json
+type SnapshotStore struct {
+	dir   string
+	locks sync.Map // project ID -> *sync.Mutex
+}
+
+// NewSnapshotStore creates the store at ~/.remoteclaude/snapshots
+func NewSnapshotStore() *SnapshotStore {
+	return &SnapshotStore{
+		dir: filepath.Join(os.Getenv("HOME"), ".remoteclaude", "snapshots"),
+	}
+}
+
+// lock serialises snapshot operations on one project and returns the
+// unlock function
+func (ss *SnapshotStore) lock(projectID string) func() {
+	lock, _ := ss.locks.LoadOrStore(projectID, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+func (ss *SnapshotStore) projectDir(projectID string) (string, error) {
+	if !snapshotIDPattern.MatchString(projectID) {
+		return "", fmt.Errorf("invalid project ID %q", projectID)
+	}
+	return filepath.Join(ss.dir, projectID), nil
+}
+
+// archivePath returns where the archive of a snapshot is stored
+func (ss *SnapshotStore) archivePath(projectID, snapshotID string) (string, error) {
+	dir, err := ss.projectDir(projectID)
+	if err != nil {
+		return "", err
+	}
+	if !snapshotIDPattern.MatchString(snapshotID) {
+		return "", fmt.Errorf("invalid snapshot ID %q", snapshotID)
+	}
+	return filepath.Join(dir, snapshotID+".tar.gz"), nil
+}
+
+// Get reads the metadata of one snapshot
+func (ss *SnapshotStore) Get(projectID, snapshotID string) (*Snapshot, error) {
+	archive, err := ss.archivePath(projectID, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(strings.TrimSuffix(archive, ".tar.gz") + ".json")
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s/%s", ErrSnapshotNotFound, projectID, snapshotID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot metadata %s: %v", snapshotID, err)
+	}
+	return &snapshot, nil
+}
+
+// List returns the snapshots of a project, or of every project when
+// projectID is empty, newest first
+func (ss *SnapshotStore) List(projectID string) ([]*Snapshot, error) {
+	pattern := filepath.Join(ss.dir, "*", "*.json")
+	if projectID != "" {
+		dir, err := ss.projectDir(projectID)
+		if err != nil {
+			return nil, err
+		}
+		pattern = filepath.Join(dir, "*.json")
+	}
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := []*Snapshot{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var snapshot Snapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			log.Printf("⚠️ Skipping unreadable snapshot metadata %s: %v", file, err)
+			continue
+		}
+		snapshots = append(snapshots, &snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt) })
+	return snapshots, nil
+}
+
+// Delete removes a snapshot's archive and metadata
+func (ss *SnapshotStore) Delete(projectID, snapshotID string) error {
+	defer ss.lock(projectID)()
+	return ss.remove(projectID, snapshotID)
+}
+
+func (ss *SnapshotStore) remove(projectID, snapshotID string) error {
+	if _, err := ss.Get(projectID, snapshotID); err != nil {
+		return err
+	}
+	archive, _ := ss.archivePath(projectID, snapshotID)
+	if err := os.Remove(archive); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(strings.TrimSuffix(archive, ".tar.gz") + ".json")
+}
+
+// save writes the metadata of a snapshot whose archive is in place
+func (ss *SnapshotStore) save(snapshot *Snapshot) error {
+	archive, err := ss.archivePath(snapshot.ProjectID, snapshot.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(strings.TrimSuffix(archive, ".tar.gz")+".json", data, 0600)
+}
+
+// pruneAutomatic deletes all but the newest maxAutomaticSnapshots automatic
+// snapshots of a project
+func (ss *SnapshotStore) pruneAutomatic(projectID string) {
+	snapshots, err := ss.List(projectID)
+	if err != nil {
+		return
+	}
+	kept := 0
+	for _, snapshot := range snapshots {
+		if !snapshot.Automatic {
+			continue
+		}
+		if kept < maxAutomaticSnapshots {
+			kept++
+			continue
+		}
+		if err := ss.remove(projectID, snapshot.ID); err != nil {
+			log.Printf("⚠️ Failed to prune snapshot %s of %s: %v", snapshot.ID, projectID, err)
+			continue
+		}
+		log.Printf("🧹 Pruned automatic snapshot %s of %s", snapshot.ID, projectID)
+	}
+}
+
+// newSnapshotID is sortable by time and unique
+func newSnapshotID() string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// CreateSnapshot archives the workspace volume of a project
+func (dm *DockerManager) CreateSnapshot(projectID, label, createdBy string) (*Snapshot, error) {
+	defer dm.snapshots.lock(projectID)()
+	return dm.createSnapshot(projectID, label, createdBy, false)
+}
+
+// createSnapshot archives the workspace of a project whose snapshot lock
+// is held
+func (dm *DockerManager) createSnapshot(projectID, label, createdBy string, automatic bool) (*Snapshot, error) {
+	project, err := dm.GetProject(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if err := dm.ensureContainerRunning(project.ContainerID, projectID); err != nil {
+		return nil, fmt.Errorf("failed to ensure container is running: %w", err)
+	}
+
+	snapshot := &Snapshot{
+		ID:            newSnapshotID(),
+		ProjectID:     projectID,
+		ProjectName:   project.Name,
+		ProjectType:   project.Type,
+		Label:         label,
+		Automatic:     automatic,
+		CreatedAt:     time.Now().UTC(),
+		CreatedBy:     createdBy,
+		FormatVersion: snapshotFormatVersion,
+		Config:        project.Config,
+		Resources:     project.Resources,
+	}
+	archive, err := dm.snapshots.archivePath(projectID, snapshot.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(archive), 0700); err != nil {
+		return nil, err
+	}
+
+	// Written under a temporary name so a broken run leaves no snapshot
+	partial := archive + ".partial"
+	file, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(partial)
+
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(file, hash)}
+	var stderr strings.Builder
+	code, err := dm.engine.Exec(ctx, project.ContainerID, ExecOptions{
+		Cmd:    []string{"tar", "-c", "-z", "-f", "-", "-C", workspaceRoot, "."},
+		Stdout: counter,
+		Stderr: &stderr,
+	})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && code != 0 {
+		err = fmt.Errorf("tar exited with status %d: %s", code, strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot %s: %v", projectID, err)
+	}
+
+	snapshot.Size = counter.n
+	snapshot.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if err := os.Rename(partial, archive); err != nil {
+		return nil, err
+	}
+	if err := dm.snapshots.save(snapshot); err != nil {
+		os.Remove(archive)
+		return nil, err
+	}
+
+	log.Printf("📸 Snapshot %s of %s: %d bytes", snapshot.ID, projectID, snapshot.Size)
+	return snapshot, nil
+}
+
+// RestoreSnapshot rolls a project's workspace back to one of its snapshots.
+// The current state is snapshotted first, so a restore can be undone; that
+// safety snapshot is returned.
+func (dm *DockerManager) RestoreSnapshot(projectID, snapshotID, restoredBy string) (*Snapshot, error) {
+	defer dm.snapshots.lock(projectID)()
+	defer dm.snapshots.pruneAutomatic(projectID)
+
+	snapshot, err := dm.snapshots.Get(projectID, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := dm.verifiedSnapshotArchive(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	backup, err := dm.createSnapshot(projectID, fmt.Sprintf("Before restoring %s", snapshotID), restoredBy, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to back up the workspace before restoring: %v", err)
+	}
+
+	containerID, err := dm.getContainerID(projectID)
+	if err != nil {
+		return backup, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+	if err := dm.extractSnapshot(ctx, containerID, archive, true); err != nil {
+		return backup, fmt.Errorf("failed to restore %s (the previous state is in snapshot %s): %v", snapshotID, backup.ID, err)
+	}
+
+	log.Printf("⏪ Restored %s to snapshot %s", projectID, snapshotID)
+	return backup, nil
+}
+
+// CloneSnapshot creates a new project whose workspace starts as the
+// snapshot, and returns it with the snapshot it was cloned from. Without a
+// snapshotID the current workspace is snapshotted first. An empty name
+// derives one from the original project.
+func (dm *DockerManager) CloneSnapshot(projectID, snapshotID, name, creator string, progress func(stage, message string)) (*Project, *Snapshot, error) {
+	defer dm.snapshots.lock(projectID)()
+	defer dm.snapshots.pruneAutomatic(projectID)
+
+	var snapshot *Snapshot
+	var err error
+	if snapshotID == "" {
+		snapshot, err = dm.createSnapshot(projectID, "Clone source", creator, true)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to snapshot the project for cloning: %v", err)
+		}
+	} else if snapshot, err = dm.snapshots.Get(projectID, snapshotID); err != nil {
+		return nil, nil, err
+	}
+	archive, err := dm.verifiedSnapshotArchive(snapshot)
+	if err != nil {
+		return nil, snapshot, err
+	}
+
+	if name == "" {
+		name = snapshot.ProjectName + " clone"
+	}
+	resources := snapshot.Resources
+	project, err := dm.CreateProject(ProjectCreateRequest{
+		Name:      name,
+		Type:      snapshot.ProjectType,
+		Config:    snapshot.Config,
+		Resources: &resources,
+		Creator:   creator,
+		Source:    &ProjectSource{Type: ProjectSourceSnapshot, Path: archive},
+		Progress:  progress,
+	})
+	return project, snapshot, err
+}
+
+// verifiedSnapshotArchive checks a snapshot's archive against its metadata
+// and returns its path
+func (dm *DockerManager) verifiedSnapshotArchive(snapshot *Snapshot) (string, error) {
+	if snapshot.FormatVersion > snapshotFormatVersion {
+		return "", fmt.Errorf("snapshot %s has format %d, newer than %d", snapshot.ID, snapshot.FormatVersion, snapshotFormatVersion)
+	}
+	archive, err := dm.snapshots.archivePath(snapshot.ProjectID, snapshot.ID)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(archive)
+	if err != nil {
+		return "", fmt.Errorf("snapshot archive missing: %v", err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != snapshot.SHA256 {
+		return "", fmt.Errorf("snapshot %s is corrupt: checksum mismatch", snapshot.ID)
+	}
+	return archive, nil
+}
+
+// extractSnapshot unpacks a snapshot archive into /workspace, emptying the
+// workspace first when clear is set
+func (dm *DockerManager) extractSnapshot(ctx context.Context, containerID, archive string, clear bool) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	script := `cd /workspace && tar -x -z -f - --no-same-owner`
+	if clear {
+		script = `cd /workspace && find . -mindepth 1 -maxdepth 1 -exec rm -rf -- {} + && tar -x -z -f - --no-same-owner`
+	}
+	result, err := dm.execContainer(ctx, containerID, []string{"/bin/sh", "-c", script}, nil, file)
+	if err = execFailure(result, err); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(result.combined()))
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRestoreSnapshotPrunesAutomatic(t *testing.T) {
+	dm, _ := newTestDockerManager(t)
+	project := startTestProject(t, dm, "prune")
+
+	manual, err := dm.CreateSnapshot(project.ID, "release", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxAutomaticSnapshots+2; i++ {
+		backup, err := dm.RestoreSnapshot(project.ID, manual.ID, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !backup.Automatic {
+			t.Errorf("restore backup %s is not automatic", backup.ID)
+		}
+	}
+
+	snapshots, err := dm.snapshots.List(project.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	automatic := 0
+	keptManual := false
+	for _, snapshot := range snapshots {
+		if snapshot.Automatic {
+			automatic++
+		}
+		keptManual = keptManual || snapshot.ID == manual.ID
+	}
+	if automatic != maxAutomaticSnapshots || !keptManual {
+		t.Errorf("kept %d automatic snapshots (want %d), manual kept = %v", automatic, maxAutomaticSnapshots, keptManual)
+	}
+
+	clone, source, err := dm.CloneSnapshot(project.ID, "", "fork", "alice", func(stage, message string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !source.Automatic || clone.ID == project.ID {
+		t.Errorf("clone = %s from %+v", clone.ID, source)
+	}
+}
+
+func TestSnapshotOperationsSerialized(t *testing.T) {
+	dm, engine := newTestDockerManager(t)
+	project := startTestProject(t, dm, "serial")
+	base, err := dm.CreateSnapshot(project.ID, "base", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	engine.ExecFunc = func(ctx context.Context, c *FakeContainer, opts ExecOptions) (int, error) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return 0, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := dm.CreateSnapshot(project.ID, "", "alice"); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := dm.RestoreSnapshot(project.ID, base.ID, "alice"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxActive != 1 {
+		t.Errorf("%d snapshot operations ran at once in one project", maxActive)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultTreeDepth   = 3
+	maxTreeDepth       = 10
+	maxTreeEntries     = 5000
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+	maxSearchLineLen   = 300
+)
+
+// TreeNode is one entry of a recursive workspace listing
+type TreeNode struct {
+	Name      string      `json:"name"`
+	Path      string      `json:"path"`
+	Type      string      `json:"type"` // "file", "dir", "symlink" or "other"
+	Size      int64       `json:"size"`
+	Mode      string      `json:"mode,omitempty"`
+	ModTime   time.Time   `json:"mod_time"`
+	GitStatus string      `json:"git_status,omitempty"` // porcelain XY code, e.g. " M", "A ", "??"
+	Dirty     bool        `json:"dirty,omitempty"`      // directory contains changed files
+	Children  []*TreeNode `json:"children,omitempty"`
+}
+
+// WorkspaceTree is the response for a recursive workspace listing
+type WorkspaceTree struct {
+	Root      *TreeNode `json:"root"`
+	Depth     int       `json:"depth"`
+	Entries   int       `json:"entries"`
+	Truncated bool      `json:"truncated"`
+	GitRepo   bool      `json:"git_repo"`
+}
+
+// WorkspaceSearchRequest describes a filename and/or content search
+type WorkspaceSearchRequest struct {
+	Path          string `json:"path"`
+	Glob          string `json:"glob"`  // filename pattern, e.g. "*.py"
+	Query         string `json:"query"` // content to grep for; empty means filename search only
+	Regex         bool   `json:"regex"`
+	CaseSensitive bool   `json:"case_sensitive"`
+	Offset        int    `json:"offset"`
+	Limit         int    `json:"limit"`
+}
+
+// WorkspaceSearchMatch is a single search hit
+type WorkspaceSearchMatch struct {
+	Path    string    `json:"path"`
+	Line    int       `json:"line,omitempty"`
+	Text    string    `json:"text,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// WorkspaceSearchResult is one page of search hits
+type WorkspaceSearchResult struct {
+	Mode       string                 `json:"mode"` // "filename" or "content"
+	Matches    []WorkspaceSearchMatch `json:"matches"`
+	Offset     int                    `json:"offset"`
+	Limit      int                    `json:"limit"`
+	HasMore    bool                   `json:"has_more"`
+	NextOffset int                    `json:"next_offset,omitempty"`
+}
+
+// gitSectionMarker separates the find output from the git status output
+const gitSectionMarker = "\x00--remoteclaude-git--\x00"
+
+// WorkspaceTree returns a recursive listing of dir annotated with git status.
+// .git is skipped and node_modules is listed but not descended into.
+func (dm *DockerManager) WorkspaceTree(projectID, dir string, depth int) (*WorkspaceTree, error) {
+	target, err := resolveWorkspacePath(dir)
+	if err != nil {
+		return nil, err
+	}
+	if depth <= 0 {
+		depth = defaultTreeDepth
+	}
+	if depth > maxTreeDepth {
+		depth = maxTreeDepth
+	}
+
+	const entry = `%y\t%s\t%T@\t%m\t%p\0`
+	script := `inside "$1"; [ -d "$1" ] || { echo "not a directory: $1" >&2; exit 4; }; ` +
+		`find "$1" -mindepth 1 -maxdepth "$2" -name .git -prune -o -name node_modules -prune -printf '` + entry + `' -o -printf '` + entry + `' | head -z -n "$3"; ` +
+		`printf '\0--remoteclaude-git--\0'; ` +
+		`top=$(git -C "$1" rev-parse --show-toplevel 2>/dev/null) && printf '%s\0' "$top" && git -C "$1" status --porcelain=v1 -z --untracked-files=all 2>/dev/null; true`
+	result, err := dm.runWorkspaceScript(projectID, script,
+		[]string{target, strconv.Itoa(depth), strconv.Itoa(maxTreeEntries + 1)}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	findOutput, gitOutput, _ := bytes.Cut(result.Stdout, []byte(gitSectionMarker))
+
+	root := &TreeNode{Name: path.Base(target), Path: target, Type: "dir"}
+	nodes := map[string]*TreeNode{target: root}
+	tree := &WorkspaceTree{Root: root, Depth: depth}
+
+	var records []FileEntry
+	for _, record := range strings.Split(string(findOutput), "\x00") {
+		if record == "" {
+			continue
+		}
+		entry, ok := parseFindRecord(record)
+		if !ok {
+			continue
+		}
+		entry.Path = entry.Name
+		entry.Name = path.Base(entry.Path)
+		records = append(records, entry)
+	}
+	if len(records) > maxTreeEntries {
+		records = records[:maxTreeEntries]
+		tree.Truncated = true
+	}
+
+	// Parents sort before their children
+	sort.Slice(records, func(i, j int) bool { return records[i].Path < records[j].Path })
+	for _, entry := range records {
+		parent := nodes[path.Dir(entry.Path)]
+		if parent == nil {
+			continue
+		}
+		node := &TreeNode{
+			Name:    entry.Name,
+			Path:    entry.Path,
+			Type:    entry.Type,
+			Size:    entry.Size,
+			Mode:    entry.Mode,
+			ModTime: entry.ModTime,
+		}
+		parent.Children = append(parent.Children, node)
+		nodes[entry.Path] = node
+		tree.Entries++
+	}
+
+	statuses, isRepo := parseGitStatusZ(string(gitOutput))
+	tree.GitRepo = isRepo
+	for filePath, code := range statuses {
+		if node := nodes[filePath]; node != nil {
+			node.GitStatus = code
+		}
+		// Mark every listed ancestor directory as dirty
+		for dir := path.Dir(filePath); strings.HasPrefix(dir, target); dir = path.Dir(dir) {
+			if node := nodes[dir]; node != nil {
+				node.Dirty = true
+			}
+			if dir == target {
+				break
+			}
+		}
+	}
+
+	return tree, nil
+}
+
+// parseGitStatusZ parses "<toplevel>\0" followed by `git status --porcelain=v1 -z`
+// output into absolute path -> XY code
+func parseGitStatusZ(output string) (map[string]string, bool) {
+	statuses := make(map[string]string)
+	records := strings.Split(output, "\x00")
+	if len(records) == 0 || records[0] == "" {
+		return statuses, false
+	}
+
+	top := records[0]
+	for i := 1; i < len(records); i++ {
+		record := records[i]
+		if len(record) < 4 {
+			continue
+		}
+		code := record[:2]
+		statuses[path.Join(top, record[3:])] = code
+		// Renames and copies are followed by the original path
+		if code[0] == 'R' || code[0] == 'C' {
+			i++
+		}
+	}
+	return statuses, true
+}
+
+// SearchWorkspace finds files by name glob, or lines by content when Query is
+// set. Results are paged with Offset and Limit.
+func (dm *DockerManager) SearchWorkspace(projectID string, req WorkspaceSearchRequest) (*WorkspaceSearchResult, error) {
+	dir := req.Path
+	if dir == "" {
+		dir = "."
+	}
+	target, err := resolveWorkspacePath(dir)
+	if err != nil {
+		return nil, err
+	}
+	if req.Query == "" && req.Glob == "" {
+		return nil, fmt.Errorf("search needs a filename glob or a content query")
+	}
+	if req.Offset < 0 {
+		req.Offset = 0
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultSearchLimit
+	}
+	if req.Limit > maxSearchLimit {
+		req.Limit = maxSearchLimit
+	}
+
+	// Fetch one extra record to learn whether another page exists
+	want := req.Offset + req.Limit + 1
+	args := []string{target, req.Query, req.Glob, strconv.Itoa(want)}
+
+	result := &WorkspaceSearchResult{Offset: req.Offset, Limit: req.Limit, Matches: []WorkspaceSearchMatch{}}
+	var records []string
+
+	if req.Query == "" {
+		result.Mode = "filename"
+		nameTest := "-name"
+		if strings.Contains(req.Glob, "/") {
+			nameTest = "-path"
+			args[2] = path.Join(target, req.Glob)
+		}
+		script := `inside "$1"; find "$1" -name .git -prune -o -name node_modules -prune -o -type f ` + nameTest +
+			` "$3" -printf '%s\t%T@\t%p\0' | head -z -n "$4"`
+		out, err := dm.runWorkspaceScript(projectID, script, args, nil)
+		if err != nil {
+			return nil, err
+		}
+		records = strings.Split(string(out.Stdout), "\x00")
+	} else {
+		result.Mode = "content"
+		// Flags are fixed strings chosen here; user input only travels as arguments
+		flags := " -F"
+		if req.Regex {
+			flags = " -E"
+		}
+		if !req.CaseSensitive {
+			flags += " -i"
+		}
+		include := ""
+		if req.Glob != "" {
+			include = ` --include="$3"`
+		}
+		script := `inside "$1"; grep -rnI --null --exclude-dir=.git --exclude-dir=node_modules` + flags + include +
+			` -e "$2" -- "$1" | head -n "$4"`
+		out, err := dm.runWorkspaceScript(projectID, script, args, nil)
+		if err != nil {
+			return nil, err
+		}
+		records = strings.Split(strings.TrimSuffix(string(out.Stdout), "\n"), "\n")
+	}
+
+	var matches []WorkspaceSearchMatch
+	for _, record := range records {
+		if record == "" {
+			continue
+		}
+		if match, ok := parseSearchRecord(result.Mode, record); ok {
+			matches = append(matches, match)
+		}
+	}
+
+	if len(matches) > req.Offset {
+		matches = matches[req.Offset:]
+	} else {
+		matches = nil
+	}
+	if len(matches) > req.Limit {
+		matches = matches[:req.Limit]
+		result.HasMore = true
+		result.NextOffset = req.Offset + req.Limit
+	}
+	result.Matches = append(result.Matches, matches...)
+	return result, nil
+}
+
+// parseSearchRecord parses one find or grep --null record
+func parseSearchRecord(mode, record string) (WorkspaceSearchMatch, bool) {
+	if mode == "filename" {
+		parts := strings.SplitN(record, "\t", 3)
+		if len(parts) != 3 {
+			return WorkspaceSearchMatch{}, false
+		}
+		size, _ := strconv.ParseInt(parts[0], 10, 64)
+		var modTime time.Time
+		if secs, err := strconv.ParseFloat(parts[1], 64); err == nil {
+			modTime = time.Unix(0, int64(secs*float64(time.Second))).UTC()
+		}
+		return WorkspaceSearchMatch{Path: parts[2], Size: size, ModTime: modTime}, true
+	}
+
+	// grep --null -n: "<path>\0<line>:<text>"
+	filePath, rest, found := strings.Cut(record, "\x00")
+	if !found {
+		return WorkspaceSearchMatch{}, false
+	}
+	lineNo, text, found := strings.Cut(rest, ":")
+	if !found {
+		return WorkspaceSearchMatch{}, false
+	}
+	line, err := strconv.Atoi(lineNo)
+	if err != nil {
+		return WorkspaceSearchMatch{}, false
+	}
+	if len(text) > maxSearchLineLen {
+		cut := maxSearchLineLen
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text = text[:cut]
+	}
+	return WorkspaceSearchMatch{Path: filePath, Line: line, Text: text}, true
+}